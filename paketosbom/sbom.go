@@ -15,8 +15,11 @@ import (
 // the Software Bill of Materials metadata components should be structured and named.
 type BOMMetadata struct {
 	Architecture    string      `toml:"arch,omitempty"`
+	BuildFlags      []string    `toml:"build-flags,omitempty"`
 	CPE             string      `toml:"cpe,omitempty"`
+	CPEs            []string    `toml:"cpes,omitempty"`
 	DeprecationDate time.Time   `toml:"deprecation-date,omitempty"`
+	Homepage        string      `toml:"homepage,omitempty"`
 	Licenses        []string    `toml:"licenses,omitempty"`
 	PURL            string      `toml:"purl,omitempty"`
 	Checksum        BOMChecksum `toml:"checksum,omitempty"`
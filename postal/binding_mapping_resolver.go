@@ -0,0 +1,40 @@
+package postal
+
+import (
+	"github.com/paketo-buildpacks/packit/v2/postal/internal"
+	"github.com/paketo-buildpacks/packit/v2/servicebindings"
+)
+
+// BindingMappingResolver is a MappingResolver that reads dependency mappings
+// from platform bindings of type "dependency-mapping" as described by the CNB
+// platform bindings specification. Each file in such a binding is named by
+// the checksum of a dependency and contains the uri that dependency should be
+// fetched from instead.
+type BindingMappingResolver struct {
+	platformDir string
+	resolver    internal.DependencyMappingResolver
+}
+
+// NewBindingMappingResolver creates an instance of a BindingMappingResolver
+// that reads the bindings in the bindings directory of the given platform
+// directory. As with every service binding lookup, the SERVICE_BINDING_ROOT
+// and CNB_BINDINGS environment variables take precedence over that location
+// when set.
+func NewBindingMappingResolver(platformDir string) *BindingMappingResolver {
+	return &BindingMappingResolver{
+		platformDir: platformDir,
+		resolver:    internal.NewDependencyMappingResolver(servicebindings.NewResolver()),
+	}
+}
+
+// FindDependencyMapping returns the uri mapped to the given checksum, or an
+// empty string if there is no mapping. The platform directory given to
+// NewBindingMappingResolver is searched, falling back to the given
+// platformDir only when the resolver was created without one.
+func (r *BindingMappingResolver) FindDependencyMapping(checksum, platformDir string) (string, error) {
+	if r.platformDir != "" {
+		platformDir = r.platformDir
+	}
+
+	return r.resolver.FindDependencyMapping(checksum, platformDir)
+}
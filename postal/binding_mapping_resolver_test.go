@@ -0,0 +1,87 @@
+package postal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testBindingMappingResolver(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		platformDir string
+		resolver    *postal.BindingMappingResolver
+	)
+
+	it.Before(func() {
+		t.Setenv("SERVICE_BINDING_ROOT", "")
+		t.Setenv("CNB_BINDINGS", "")
+
+		var err error
+		platformDir, err = os.MkdirTemp("", "platform")
+		Expect(err).NotTo(HaveOccurred())
+
+		bindingDir := filepath.Join(platformDir, "bindings", "some-binding")
+		Expect(os.MkdirAll(bindingDir, os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(bindingDir, "type"), []byte("dependency-mapping"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(bindingDir, "some-sha"), []byte("https://mirror.example.com/some-dependency.tgz\n"), 0600)).To(Succeed())
+
+		otherBindingDir := filepath.Join(platformDir, "bindings", "other-binding")
+		Expect(os.MkdirAll(otherBindingDir, os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(otherBindingDir, "type"), []byte("some-other-type"), 0600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(otherBindingDir, "other-sha"), []byte("https://mirror.example.com/other-dependency.tgz"), 0600)).To(Succeed())
+
+		resolver = postal.NewBindingMappingResolver(platformDir)
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(platformDir)).To(Succeed())
+	})
+
+	context("FindDependencyMapping", func() {
+		it("returns the uri mapped to the checksum", func() {
+			uri, err := resolver.FindDependencyMapping("sha256:some-sha", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(uri).To(Equal("https://mirror.example.com/some-dependency.tgz"))
+		})
+
+		context("when the checksum is only mapped by a binding of another type", func() {
+			it("returns an empty string", func() {
+				uri, err := resolver.FindDependencyMapping("sha256:other-sha", "")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(uri).To(BeEmpty())
+			})
+		})
+
+		context("when the resolver is created without a platform directory", func() {
+			it("uses the given platform directory", func() {
+				uri, err := postal.NewBindingMappingResolver("").FindDependencyMapping("sha256:some-sha", platformDir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(uri).To(Equal("https://mirror.example.com/some-dependency.tgz"))
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the bindings cannot be read", func() {
+				it.Before(func() {
+					Expect(os.Chmod(filepath.Join(platformDir, "bindings"), 0000)).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Chmod(filepath.Join(platformDir, "bindings"), os.ModePerm)).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := resolver.FindDependencyMapping("sha256:some-sha", "")
+					Expect(err).To(MatchError(ContainSubstring("failed to resolve 'dependency-mapping' binding")))
+				})
+			})
+		})
+	})
+}
@@ -1,11 +1,16 @@
 package postal
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver/v3"
 	"github.com/paketo-buildpacks/packit/v2/cargo"
 )
 
@@ -13,6 +18,10 @@ type Checksum = cargo.Checksum
 
 // Dependency is a representation of a buildpack dependency.
 type Dependency struct {
+	// BuildFlags are the compiler flags that the dependency was built with.
+	// They are recorded in the BOM for tools that consume them downstream.
+	BuildFlags []string `toml:"build-flags" json:"build-flags,omitempty"`
+
 	// CPE is the Common Platform Enumerator for the dependency. Used in legacy
 	// image label SBOM (GenerateBillOfMaterials).
 	//
@@ -31,6 +40,14 @@ type Dependency struct {
 	// sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.
 	Checksum string `toml:"checksum"`
 
+	// Checksums maps algorithm names, such as "sha512", to the hex-encoded hash
+	// of the built dependency computed with that algorithm. They are validated
+	// in addition to Checksum.
+	Checksums map[string]string `toml:"checksums"`
+
+	// Homepage is the uri location of the project homepage for the dependency.
+	Homepage string `toml:"homepage"`
+
 	// ID is the identifier used to specify the dependency.
 	ID string `toml:"id"`
 
@@ -65,35 +82,350 @@ type Dependency struct {
 	// Stacks is a list of stacks for which the dependency is built.
 	Stacks []string `toml:"stacks"`
 
+	// BuildStacks is a list of build stacks for which the dependency is built.
+	// When it is empty, Stacks is used in its place. See Service.WithLayerType.
+	BuildStacks []string `toml:"build-stacks"`
+
+	// RunStacks is a list of run stacks for which the dependency is built.
+	// When it is empty, Stacks is used in its place. See Service.WithLayerType.
+	RunStacks []string `toml:"run-stacks"`
+
 	// URI is the uri location of the built dependency.
 	URI string `toml:"uri"`
 
+	// ExtraURIs are additional uri locations of the built dependency that are
+	// tried in order when it cannot be fetched from URI. The content at every
+	// location must match the same checksum.
+	ExtraURIs []string `toml:"extra-uris"`
+
+	// URIParts are the uri locations of the remaining parts of a dependency
+	// that is split across multiple files. Each part is fetched in order and
+	// appended to the content fetched from URI. The checksum covers the
+	// content of every part put together.
+	URIParts []string `toml:"uri-parts"`
+
+	// OCILayerDigests are the digests of the layers to fetch when the
+	// dependency is stored as an OCI image, rather than every layer of the
+	// image. The Transport must be a LayerTransport, such as OCITransport.
+	OCILayerDigests []string `toml:"oci-layer-digests"`
+
+	// EphemeralURI marks the uri locations of the dependency as short-lived,
+	// such as pre-signed download URLs that expire. Deliver always fetches such
+	// a dependency from the Transport, replacing the files of a previous
+	// delivery to the layer rather than skipping it, and leaves its uris out of
+	// the delivery manifest.
+	EphemeralURI bool `toml:"ephemeral-uri"`
+
+	// VerificationLevel is how much of the content of the dependency is
+	// validated as it is delivered: "full" validates all of it against its
+	// checksum, "fast" validates only its first and last 64 KiB against its
+	// FastChecksum, and "skip" does not validate it at all. The content is read
+	// in full either way, as it is extracted into the layer. An empty value is
+	// the same as "full".
+	VerificationLevel string `toml:"verification-level"`
+
+	// FastChecksum is a string that includes an algorithm and the hex-encoded
+	// hash of the first and last 64 KiB of the content of the dependency, or of
+	// all of it when it is no larger than 128 KiB, in the format
+	// "algorithm:hash". It is required to deliver the dependency with the
+	// "fast" VerificationLevel, as the checksum of the full content cannot be
+	// validated from only part of it.
+	FastChecksum string `toml:"fast-checksum"`
+
 	// Version is the specific version of the dependency.
 	Version string `toml:"version"`
 
 	// StripComponents behaves like the --strip-components flag on tar command
 	// removing the first n levels from the final decompression destination.
 	StripComponents int `toml:"strip-components"`
+
+	// StripSuffix is a glob pattern, such as "node-*", matched against the
+	// names of the top-level directories extracted from the dependency. The
+	// contents of a matching directory are moved up into the layer path and the
+	// directory itself is removed, leaving deeper paths untouched.
+	StripSuffix string `toml:"strip-suffix"`
+
+	// RequiredFiles is a list of paths, relative to the layer path, that must
+	// exist once the dependency has been extracted. Deliver returns an
+	// ErrMissingRequiredFile when any of them is missing.
+	RequiredFiles []string `toml:"required-files"`
+
+	// RequiredCapabilities is a list of the Linux capabilities, such as
+	// "CAP_NET_ADMIN", that the dependency needs at runtime. When a capability
+	// checker is configured with Service.WithCapabilityChecker, Deliver returns
+	// an ErrCapabilityMissing when any of them is unavailable.
+	RequiredCapabilities []string `toml:"required-capabilities"`
+
+	// Context is the lifecycle context that the dependency is used in. It is
+	// one of "build", "run", or "both". An empty value is treated as "both".
+	Context string `toml:"context"`
+
+	// OS is the container operating system, such as "linux" or "windows", that
+	// the dependency is built for. An empty value means any operating system.
+	OS string `toml:"os"`
+
+	// MinRuntimeVersion is the lowest version of the runtime operating system,
+	// such as "20.04", that the dependency is compatible with. See
+	// Service.WithRuntimeVersionCheck.
+	MinRuntimeVersion string `toml:"min-runtime-version"`
+
+	// MaxRuntimeVersion is the highest version of the runtime operating system
+	// that the dependency is compatible with. See
+	// Service.WithRuntimeVersionCheck.
+	MaxRuntimeVersion string `toml:"max-runtime-version"`
+
+	// ConflictsWith is a list of dependency IDs that cannot be installed
+	// alongside this dependency. See Service.ValidateNoConflicts.
+	ConflictsWith []string `toml:"conflicts-with"`
+
+	// Provides is a list of the build plan entries that the dependency
+	// satisfies. See Service.GenerateBuildPlanProvisions.
+	Provides []string `toml:"provides"`
+
+	// DownloadPriority is a scheduling hint used by Service.DeliverAll.
+	// Dependencies with a higher priority are delivered earlier.
+	DownloadPriority int `toml:"download-priority"`
+
+	// DownloadSize is the number of bytes transferred to download the
+	// dependency. It is not read from buildpack.toml, instead Deliver sets it on
+	// the dependency it records, such as in the DeliveryManifest, when the
+	// Transport reports the size of the download, as HTTPTransport does using
+	// the Content-Length header.
+	DownloadSize int64 `toml:"-"`
+
+	// SBOMURIs are the uri locations of pre-built SBOM documents describing the
+	// dependency, keyed by their format, such as "cyclonedx" or "spdx". See
+	// Service.FetchSBOM.
+	SBOMURIs map[string]string `toml:"sbom-uris"`
+
+	// ChangelogURI is the uri location of the changelog of the dependency. See
+	// Service.FetchChangelog.
+	ChangelogURI string `toml:"changelog-uri"`
+
+	// Metadata holds additional information about the dependency, such as the
+	// internal annotations added by a DependencyAnnotator.
+	Metadata map[string]string `toml:"metadata"`
+}
+
+// Equal reports whether the dependency has the same value as other in every
+// field. Deprecation dates are equal when they describe the same instant.
+func (d Dependency) Equal(other Dependency) bool {
+	if !d.DeprecationDate.Equal(other.DeprecationDate) {
+		return false
+	}
+
+	d.DeprecationDate = time.Time{}
+	other.DeprecationDate = time.Time{}
+
+	return reflect.DeepEqual(d, other)
+}
+
+// Less reports whether the dependency sorts before other. Dependencies are
+// ordered by ID and then by semantic version. Versions that are not valid
+// semantic versions are compared as strings.
+func (d Dependency) Less(other Dependency) bool {
+	if d.ID != other.ID {
+		return d.ID < other.ID
+	}
+
+	version, err := semver.NewVersion(d.Version)
+	if err != nil {
+		return d.Version < other.Version
+	}
+
+	otherVersion, err := semver.NewVersion(other.Version)
+	if err != nil {
+		return d.Version < other.Version
+	}
+
+	return version.LessThan(otherVersion)
+}
+
+// Sort sorts the given dependencies in place using Dependency.Less. The
+// original order of equal dependencies is preserved.
+func Sort(dependencies []Dependency) {
+	sort.SliceStable(dependencies, func(i, j int) bool {
+		return dependencies[i].Less(dependencies[j])
+	})
+}
+
+// Clone returns a deep copy of the dependency. The slices and maps of the
+// clone have their own backing storage so that they can be modified without
+// affecting the original dependency.
+func (d Dependency) Clone() Dependency {
+	d.BuildFlags = cloneStrings(d.BuildFlags)
+	d.CPEs = cloneStrings(d.CPEs)
+	d.Licenses = cloneStrings(d.Licenses)
+	d.Stacks = cloneStrings(d.Stacks)
+	d.BuildStacks = cloneStrings(d.BuildStacks)
+	d.RunStacks = cloneStrings(d.RunStacks)
+	d.ExtraURIs = cloneStrings(d.ExtraURIs)
+	d.URIParts = cloneStrings(d.URIParts)
+	d.OCILayerDigests = cloneStrings(d.OCILayerDigests)
+	d.ConflictsWith = cloneStrings(d.ConflictsWith)
+	d.Provides = cloneStrings(d.Provides)
+	d.RequiredFiles = cloneStrings(d.RequiredFiles)
+	d.RequiredCapabilities = cloneStrings(d.RequiredCapabilities)
+
+	d.Checksums = cloneStringMap(d.Checksums)
+	d.SBOMURIs = cloneStringMap(d.SBOMURIs)
+	d.Metadata = cloneStringMap(d.Metadata)
+
+	return d
+}
+
+// SerializeToTOML returns the dependency encoded as a [[metadata.dependencies]]
+// table of a buildpack.toml file, so that it can be written back into one.
+// Fields with a zero value are left out, and the DeprecationDate is written
+// as an RFC 3339 date-time.
+func (d Dependency) SerializeToTOML() ([]byte, error) {
+	fields := map[string]interface{}{}
+
+	value := reflect.ValueOf(d)
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("toml"), ",")[0]
+		if name == "" || name == "-" || value.Field(i).IsZero() {
+			continue
+		}
+
+		fields[name] = value.Field(i).Interface()
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	encoder := toml.NewEncoder(buffer)
+	encoder.Indent = ""
+
+	err := encoder.Encode(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"dependencies": []map[string]interface{}{fields},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize dependency: %w", err)
+	}
+
+	// The encoder writes a header for the empty [metadata] table, which is
+	// dropped so that the block can be appended to a buildpack.toml file that
+	// already defines that table
+	content := bytes.TrimLeft(buffer.Bytes(), "\n")
+	content = bytes.TrimPrefix(content, []byte("[metadata]\n"))
+
+	return bytes.TrimLeft(content, "\n"), nil
+}
+
+// DependencyAlias maps a dependency ID onto the ID of another dependency so
+// that the same artifact can be resolved under more than one ID.
+type DependencyAlias struct {
+	// Alias is the dependency ID that is being aliased.
+	Alias string `toml:"alias"`
+
+	// Target is the dependency ID that the alias resolves to. The target may
+	// itself be an alias.
+	Target string `toml:"target"`
+}
+
+type buildpackMetadata struct {
+	SchemaVersion     string            `toml:"schema-version"`
+	DefaultVersions   map[string]string `toml:"default-versions"`
+	Dependencies      []Dependency      `toml:"dependencies"`
+	DependencyAliases []DependencyAlias `toml:"dependency-aliases"`
 }
 
-func parseBuildpack(path, name string) ([]Dependency, string, error) {
-	file, err := os.Open(path)
+func parseBuildpack(path string) (buildpackMetadata, error) {
+	return parseBuildpackWithHook(path, nil)
+}
+
+// parseBuildpackWithHook parses the buildpack.toml at the given path, passing
+// its raw content through the given hook, when there is one, beforehand.
+func parseBuildpackWithHook(path string, hook func([]byte) ([]byte, error)) (buildpackMetadata, error) {
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to parse buildpack.toml: %w", err)
+		return buildpackMetadata{}, fmt.Errorf("failed to parse buildpack.toml: %w", err)
+	}
+
+	if hook != nil {
+		content, err = hook(content)
+		if err != nil {
+			return buildpackMetadata{}, fmt.Errorf("failed to apply pre-resolve hook: %w", err)
+		}
 	}
 
 	var buildpack struct {
-		Metadata struct {
-			DefaultVersions map[string]string `toml:"default-versions"`
-			Dependencies    []Dependency      `toml:"dependencies"`
-		} `toml:"metadata"`
+		Metadata buildpackMetadata `toml:"metadata"`
 	}
-	_, err = toml.NewDecoder(file).Decode(&buildpack)
+	_, err = toml.Decode(string(content), &buildpack)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to parse buildpack.toml: %w", err)
+		return buildpackMetadata{}, fmt.Errorf("failed to parse buildpack.toml: %w", err)
+	}
+
+	return buildpack.Metadata, nil
+}
+
+// resolveAlias follows the chain of dependency aliases starting at the given
+// id and returns the final target. An error is returned if the chain loops
+// back on itself.
+func resolveAlias(aliases []DependencyAlias, id string) (string, error) {
+	targets := map[string]string{}
+	for _, alias := range aliases {
+		targets[alias.Alias] = alias.Target
+	}
+
+	chain := []string{id}
+	seen := map[string]bool{id: true}
+	for {
+		target, ok := targets[id]
+		if !ok {
+			return id, nil
+		}
+
+		chain = append(chain, target)
+		if seen[target] {
+			return "", fmt.Errorf("circular dependency alias detected: %s", strings.Join(chain, " -> "))
+		}
+
+		seen[target] = true
+		id = target
+	}
+}
+
+func contextIncludes(context, filter string) bool {
+	return filter == "" || context == "" || context == "both" || context == filter
+}
+
+func osIncludes(os, filter string) bool {
+	return filter == "" || os == "" || os == filter
+}
+
+// runtimeVersionIncludes reports whether the runtime version is within the
+// MinRuntimeVersion and MaxRuntimeVersion of the dependency, inclusive.
+func runtimeVersionIncludes(dependency Dependency, runtimeVersion *semver.Version) (bool, error) {
+	if runtimeVersion == nil {
+		return true, nil
+	}
+
+	if dependency.MinRuntimeVersion != "" {
+		minVersion, err := semver.NewVersion(dependency.MinRuntimeVersion)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse min-runtime-version of %q: %w", dependency.ID, err)
+		}
+
+		if runtimeVersion.LessThan(minVersion) {
+			return false, nil
+		}
+	}
+
+	if dependency.MaxRuntimeVersion != "" {
+		maxVersion, err := semver.NewVersion(dependency.MaxRuntimeVersion)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse max-runtime-version of %q: %w", dependency.ID, err)
+		}
+
+		if runtimeVersion.GreaterThan(maxVersion) {
+			return false, nil
+		}
 	}
 
-	return buildpack.Metadata.Dependencies, buildpack.Metadata.DefaultVersions[name], nil
+	return true, nil
 }
 
 func stacksInclude(stacks []string, stack string) bool {
@@ -104,3 +436,49 @@ func stacksInclude(stacks []string, stack string) bool {
 	}
 	return false
 }
+
+// layerStacks returns the lists of stacks that must each include a stack for
+// the dependency to be usable on that stack in a layer of the given type.
+func layerStacks(dependency Dependency, layerType LayerType) [][]string {
+	buildStacks := dependency.BuildStacks
+	if len(buildStacks) == 0 {
+		buildStacks = dependency.Stacks
+	}
+
+	runStacks := dependency.RunStacks
+	if len(runStacks) == 0 {
+		runStacks = dependency.Stacks
+	}
+
+	switch layerType {
+	case LayerTypeBuild:
+		return [][]string{buildStacks}
+	case LayerTypeRun:
+		return [][]string{runStacks}
+	case LayerTypeBoth:
+		return [][]string{buildStacks, runStacks}
+	default:
+		return [][]string{dependency.Stacks}
+	}
+}
+
+func cloneStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+
+	return append([]string{}, values...)
+}
+
+func cloneStringMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+
+	clone := make(map[string]string, len(values))
+	for key, value := range values {
+		clone[key] = value
+	}
+
+	return clone
+}
@@ -0,0 +1,118 @@
+package postal
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type buildpackCacheKey struct {
+	path    string
+	modTime time.Time
+}
+
+type buildpackCacheEntry struct {
+	key       buildpackCacheKey
+	buildpack buildpackMetadata
+}
+
+// buildpackCache is a least-recently-used cache of parsed buildpack.toml
+// files. Entries are keyed by the path and modification time of the file so
+// that a file that changes on disk is parsed again.
+type buildpackCache struct {
+	maxEntries int
+
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[buildpackCacheKey]*list.Element
+}
+
+func newBuildpackCache(maxEntries int) *buildpackCache {
+	return &buildpackCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    map[buildpackCacheKey]*list.Element{},
+	}
+}
+
+// Parse returns the parsed contents of the buildpack.toml at the given path,
+// only calling parse to read the file from disk when it is not already
+// cached.
+func (c *buildpackCache) Parse(path string, parse func(string) (buildpackMetadata, error)) (buildpackMetadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return buildpackMetadata{}, fmt.Errorf("failed to parse buildpack.toml: %w", err)
+	}
+
+	key := buildpackCacheKey{path: path, modTime: info.ModTime()}
+
+	c.mutex.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.mutex.Unlock()
+
+		return element.Value.(buildpackCacheEntry).buildpack, nil
+	}
+	c.mutex.Unlock()
+
+	buildpack, err := parse(path)
+	if err != nil {
+		return buildpackMetadata{}, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(buildpackCacheEntry{key: key, buildpack: buildpack})
+
+		for c.order.Len() > c.maxEntries {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(buildpackCacheEntry).key)
+		}
+	}
+
+	return buildpack, nil
+}
+
+type modTimeCacheEntry struct {
+	modTime   time.Time
+	buildpack buildpackMetadata
+}
+
+// modTimeCache keeps the most recently parsed contents of each buildpack.toml
+// file along with the modification time the file had when it was parsed. A
+// file is only parsed again once its modification time changes. See
+// Service.ResolveWithCache.
+type modTimeCache struct {
+	entries sync.Map
+}
+
+// Parse returns the parsed contents of the buildpack.toml at the given path,
+// only calling parse when the modification time of the file differs from
+// the one that was cached for that path.
+func (c *modTimeCache) Parse(path string, parse func(string) (buildpackMetadata, error)) (buildpackMetadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return buildpackMetadata{}, fmt.Errorf("failed to parse buildpack.toml: %w", err)
+	}
+
+	if value, ok := c.entries.Load(path); ok {
+		entry := value.(modTimeCacheEntry)
+		if entry.modTime.Equal(info.ModTime()) {
+			return entry.buildpack, nil
+		}
+	}
+
+	buildpack, err := parse(path)
+	if err != nil {
+		return buildpackMetadata{}, err
+	}
+
+	c.entries.Store(path, modTimeCacheEntry{modTime: info.ModTime(), buildpack: buildpack})
+
+	return buildpack, nil
+}
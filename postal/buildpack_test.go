@@ -0,0 +1,224 @@
+package postal_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/postal/fakes"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testDependency(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	context("Clone", func() {
+		var dependency postal.Dependency
+
+		it.Before(func() {
+			dependency = postal.Dependency{
+				BuildFlags:           []string{"-O2"},
+				CPE:                  "some-cpe",
+				CPEs:                 []string{"some-cpe", "other-cpe"},
+				DeprecationDate:      time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC),
+				Checksum:             "sha256:some-sha",
+				Checksums:            map[string]string{"sha512": "some-sha512"},
+				Homepage:             "some-homepage",
+				ID:                   "some-entry",
+				Licenses:             []string{"MIT", "Apache-2.0"},
+				Name:                 "Some Entry",
+				PURL:                 "some-purl",
+				Source:               "some-source",
+				SourceChecksum:       "sha256:some-source-sha",
+				Stacks:               []string{"some-stack", "other-stack"},
+				BuildStacks:          []string{"some-build-stack"},
+				RunStacks:            []string{"some-run-stack"},
+				URI:                  "some-uri",
+				ExtraURIs:            []string{"some-extra-uri"},
+				URIParts:             []string{"some-uri-part"},
+				OCILayerDigests:      []string{"sha256:some-layer-digest"},
+				Version:              "1.2.3",
+				StripComponents:      1,
+				StripSuffix:          "some-*",
+				Context:              "build",
+				OS:                   "linux",
+				ConflictsWith:        []string{"other-entry"},
+				Provides:             []string{"some-provision"},
+				RequiredFiles:        []string{"bin/some-file"},
+				RequiredCapabilities: []string{"CAP_NET_ADMIN"},
+				DownloadPriority:     2,
+				SBOMURIs:             map[string]string{"spdx": "some-spdx-uri"},
+				Metadata:             map[string]string{"team-owner": "some-team"},
+			}
+		})
+
+		it("returns an equal copy of the dependency", func() {
+			Expect(dependency.Clone()).To(Equal(dependency))
+		})
+
+		it("does not share slices or maps with the original dependency", func() {
+			clone := dependency.Clone()
+
+			clone.BuildFlags[0] = "-O0"
+			clone.CPEs[0] = "changed-cpe"
+			clone.Licenses[0] = "changed-license"
+			clone.Stacks[0] = "changed-stack"
+			clone.BuildStacks[0] = "changed-build-stack"
+			clone.RunStacks[0] = "changed-run-stack"
+			clone.ExtraURIs[0] = "changed-extra-uri"
+			clone.URIParts[0] = "changed-uri-part"
+			clone.OCILayerDigests[0] = "sha256:changed-layer-digest"
+			clone.ConflictsWith[0] = "changed-entry"
+			clone.Provides[0] = "changed-provision"
+			clone.RequiredFiles[0] = "bin/changed-file"
+			clone.RequiredCapabilities[0] = "CAP_SYS_ADMIN"
+			clone.Checksums["sha512"] = "changed-sha512"
+			clone.SBOMURIs["spdx"] = "changed-spdx-uri"
+			clone.Metadata["team-owner"] = "changed-team"
+
+			Expect(dependency.BuildFlags).To(Equal([]string{"-O2"}))
+			Expect(dependency.CPEs).To(Equal([]string{"some-cpe", "other-cpe"}))
+			Expect(dependency.Licenses).To(Equal([]string{"MIT", "Apache-2.0"}))
+			Expect(dependency.Stacks).To(Equal([]string{"some-stack", "other-stack"}))
+			Expect(dependency.BuildStacks).To(Equal([]string{"some-build-stack"}))
+			Expect(dependency.RunStacks).To(Equal([]string{"some-run-stack"}))
+			Expect(dependency.ExtraURIs).To(Equal([]string{"some-extra-uri"}))
+			Expect(dependency.URIParts).To(Equal([]string{"some-uri-part"}))
+			Expect(dependency.OCILayerDigests).To(Equal([]string{"sha256:some-layer-digest"}))
+			Expect(dependency.ConflictsWith).To(Equal([]string{"other-entry"}))
+			Expect(dependency.Provides).To(Equal([]string{"some-provision"}))
+			Expect(dependency.RequiredFiles).To(Equal([]string{"bin/some-file"}))
+			Expect(dependency.RequiredCapabilities).To(Equal([]string{"CAP_NET_ADMIN"}))
+			Expect(dependency.Checksums).To(Equal(map[string]string{"sha512": "some-sha512"}))
+			Expect(dependency.SBOMURIs).To(Equal(map[string]string{"spdx": "some-spdx-uri"}))
+			Expect(dependency.Metadata).To(Equal(map[string]string{"team-owner": "some-team"}))
+		})
+	})
+
+	context("SerializeToTOML", func() {
+		var path string
+
+		it.Before(func() {
+			file, err := os.CreateTemp("", "buildpack.toml")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			path = file.Name()
+		})
+
+		it.After(func() {
+			Expect(os.RemoveAll(path)).To(Succeed())
+		})
+
+		it("produces a dependency table that resolves to an equal dependency", func() {
+			dependency := postal.Dependency{
+				BuildFlags:       []string{"-O2"},
+				CPE:              "some-cpe",
+				CPEs:             []string{"some-cpe", "other-cpe"},
+				DeprecationDate:  time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC),
+				Checksum:         "sha256:some-sha",
+				Checksums:        map[string]string{"sha512": "some-sha512"},
+				Homepage:         "some-homepage",
+				ID:               "some-entry",
+				Licenses:         []string{"MIT", "Apache-2.0"},
+				Name:             "Some Entry",
+				PURL:             "some-purl",
+				Source:           "some-source",
+				SourceChecksum:   "sha256:some-source-sha",
+				Stacks:           []string{"some-stack", "other-stack"},
+				URI:              "some-uri",
+				ExtraURIs:        []string{"some-extra-uri"},
+				URIParts:         []string{"some-uri-part"},
+				Version:          "1.2.3",
+				StripComponents:  1,
+				StripSuffix:      "some-*",
+				RequiredFiles:    []string{"bin/some-file"},
+				Context:          "build",
+				OS:               "linux",
+				ConflictsWith:    []string{"other-entry"},
+				Provides:         []string{"some-provision"},
+				DownloadPriority: 2,
+				SBOMURIs:         map[string]string{"cyclonedx": "some-cyclonedx-uri", "spdx": "some-spdx-uri"},
+			}
+
+			content, err := dependency.SerializeToTOML()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(HavePrefix("[[metadata.dependencies]]\n"))
+			Expect(string(content)).To(ContainSubstring("deprecation_date = 2022-04-01T00:00:00Z\n"))
+			Expect(string(content)).NotTo(ContainSubstring("sha256 ="))
+
+			Expect(os.WriteFile(path, content, 0600)).To(Succeed())
+
+			resolved, err := postal.NewService(&fakes.Transport{}).Resolve(path, "some-entry", "1.2.3", "some-stack")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resolved.Equal(dependency)).To(BeTrue())
+		})
+	})
+
+	context("Equal", func() {
+		it("is true for dependencies with the same fields", func() {
+			deprecationDate := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+
+			dependency := postal.Dependency{
+				ID:              "some-entry",
+				Version:         "1.2.3",
+				Stacks:          []string{"some-stack"},
+				DeprecationDate: deprecationDate,
+			}
+			other := postal.Dependency{
+				ID:              "some-entry",
+				Version:         "1.2.3",
+				Stacks:          []string{"some-stack"},
+				DeprecationDate: deprecationDate.In(time.FixedZone("some-zone", 3600)),
+			}
+
+			Expect(dependency.Equal(other)).To(BeTrue())
+		})
+
+		it("is false for dependencies with different fields", func() {
+			dependency := postal.Dependency{ID: "some-entry", Version: "1.2.3", Stacks: []string{"some-stack"}}
+
+			Expect(dependency.Equal(postal.Dependency{ID: "some-entry", Version: "1.2.4", Stacks: []string{"some-stack"}})).To(BeFalse())
+			Expect(dependency.Equal(postal.Dependency{ID: "some-entry", Version: "1.2.3", Stacks: []string{"other-stack"}})).To(BeFalse())
+		})
+	})
+
+	context("Sort", func() {
+		it("orders dependencies by ID and then by semantic version", func() {
+			dependencies := []postal.Dependency{
+				{ID: "some-entry", Version: "1.10.0"},
+				{ID: "other-entry", Version: "2.0.0"},
+				{ID: "some-entry", Version: "1.2.3"},
+				{ID: "other-entry", Version: "1.0.0"},
+				{ID: "some-entry", Version: "1.9.0"},
+			}
+
+			postal.Sort(dependencies)
+
+			Expect(dependencies).To(Equal([]postal.Dependency{
+				{ID: "other-entry", Version: "1.0.0"},
+				{ID: "other-entry", Version: "2.0.0"},
+				{ID: "some-entry", Version: "1.2.3"},
+				{ID: "some-entry", Version: "1.9.0"},
+				{ID: "some-entry", Version: "1.10.0"},
+			}))
+		})
+
+		it("keeps the order of equal dependencies", func() {
+			dependencies := []postal.Dependency{
+				{ID: "some-entry", Version: "1.2.3", URI: "first-uri"},
+				{ID: "some-entry", Version: "1.2.3", URI: "second-uri"},
+			}
+
+			postal.Sort(dependencies)
+
+			Expect(dependencies[0].URI).To(Equal("first-uri"))
+			Expect(dependencies[1].URI).To(Equal("second-uri"))
+		})
+	})
+}
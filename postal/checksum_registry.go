@@ -0,0 +1,117 @@
+package postal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+var hexPattern = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+// ChecksumResult describes the checksum declared for a single dependency in a
+// buildpack.toml file.
+type ChecksumResult struct {
+	// Checksum is the raw checksum string as declared on the dependency. When
+	// the dependency only declares the deprecated sha256 field, the value is
+	// given in the algorithm:hash form.
+	Checksum string
+
+	// Algorithm is the algorithm portion of the checksum.
+	Algorithm string
+
+	// WellFormed is true when the checksum is non-empty, uses a supported
+	// algorithm, and has a hex-encoded hash of the expected length.
+	WellFormed bool
+
+	// Overwritten lists the checksums of earlier dependencies with the same id
+	// and version, such as variants for other stacks, that this result
+	// replaced in the registry.
+	Overwritten []string
+}
+
+// ChecksumRegistry is a collection of ChecksumResult values for every
+// dependency in a buildpack.toml file keyed by "id@version".
+type ChecksumRegistry map[string]ChecksumResult
+
+// NewChecksumRegistry parses the buildpack.toml file at the given path and
+// returns a ChecksumRegistry describing the checksum of each dependency.
+func NewChecksumRegistry(path string) (ChecksumRegistry, error) {
+	buildpack, err := parseBuildpack(path)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := ChecksumRegistry{}
+	for _, dependency := range buildpack.Dependencies {
+		checksum := dependency.Checksum
+		if checksum == "" && dependency.SHA256 != "" {
+			checksum = fmt.Sprintf("sha256:%s", dependency.SHA256)
+		}
+
+		key := fmt.Sprintf("%s@%s", dependency.ID, dependency.Version)
+
+		var overwritten []string
+		if previous, ok := registry[key]; ok {
+			overwritten = append(previous.Overwritten, previous.Checksum)
+		}
+
+		registry[key] = ChecksumResult{
+			Checksum:    checksum,
+			Algorithm:   Checksum(checksum).Algorithm(),
+			WellFormed:  wellFormedChecksum(Checksum(checksum)),
+			Overwritten: overwritten,
+		}
+	}
+
+	return registry, nil
+}
+
+// Validate returns an error aggregating every dependency in the registry
+// whose checksum is not well-formed or that overwrote the checksum of another
+// dependency with the same id and version. If all checksums are well-formed
+// and none were overwritten, nil is returned.
+func (r ChecksumRegistry) Validate() error {
+	var keys []string
+	for key := range r {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		result := r[key]
+		for _, checksum := range result.Overwritten {
+			errs = append(errs, fmt.Errorf("dependency %q is declared more than once, overwriting the checksum %q", key, checksum))
+		}
+
+		if result.WellFormed {
+			continue
+		}
+
+		if result.Checksum == "" {
+			errs = append(errs, fmt.Errorf("dependency %q is missing a checksum", key))
+			continue
+		}
+
+		errs = append(errs, fmt.Errorf("dependency %q has a malformed checksum %q", key, result.Checksum))
+	}
+
+	return errors.Join(errs...)
+}
+
+func wellFormedChecksum(checksum Checksum) bool {
+	hash := checksum.Hash()
+	if !hexPattern.MatchString(hash) {
+		return false
+	}
+
+	switch checksum.Algorithm() {
+	case "sha256":
+		return len(hash) == 64
+	case "sha512":
+		return len(hash) == 128
+	default:
+		return false
+	}
+}
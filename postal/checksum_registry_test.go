@@ -0,0 +1,129 @@
+package postal_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testChecksumRegistry(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		path string
+	)
+
+	it.Before(func() {
+		file, err := os.CreateTemp("", "buildpack.toml")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = file.Name()
+		_, err = file.WriteString(`
+[[metadata.dependencies]]
+id = "some-entry"
+checksum = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+stacks = ["some-stack"]
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+checksum = "sha256:some-sha"
+stacks = ["other-stack", "another-stack"]
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+version = "4.5.6"
+
+[[metadata.dependencies]]
+id = "other-entry"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "other-entry"
+checksum = "md5:d41d8cd98f00b204e9800998ecf8427e"
+version = "4.5.6"
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(path)).To(Succeed())
+	})
+
+	context("NewChecksumRegistry", func() {
+		it("returns the checksum results for every dependency", func() {
+			registry, err := postal.NewChecksumRegistry(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(registry).To(Equal(postal.ChecksumRegistry{
+				"some-entry@1.2.3": {
+					Checksum:    "sha256:some-sha",
+					Algorithm:   "sha256",
+					WellFormed:  false,
+					Overwritten: []string{"sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+				},
+				"some-entry@4.5.6": {
+					Checksum:   "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+					Algorithm:  "sha256",
+					WellFormed: true,
+				},
+				"other-entry@1.2.3": {
+					Checksum:   "",
+					Algorithm:  "sha256",
+					WellFormed: false,
+				},
+				"other-entry@4.5.6": {
+					Checksum:   "md5:d41d8cd98f00b204e9800998ecf8427e",
+					Algorithm:  "md5",
+					WellFormed: false,
+				},
+			}))
+		})
+
+		context("failure cases", func() {
+			context("when the buildpack.toml is malformed", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(path, []byte("%%%"), 0600)).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := postal.NewChecksumRegistry(path)
+					Expect(err).To(MatchError(ContainSubstring("failed to parse buildpack.toml")))
+				})
+			})
+		})
+	})
+
+	context("Validate", func() {
+		it("returns an error listing every malformed checksum", func() {
+			registry, err := postal.NewChecksumRegistry(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = registry.Validate()
+			Expect(err).To(MatchError(ContainSubstring(`dependency "other-entry@1.2.3" is missing a checksum`)))
+			Expect(err).To(MatchError(ContainSubstring(`dependency "other-entry@4.5.6" has a malformed checksum "md5:d41d8cd98f00b204e9800998ecf8427e"`)))
+			Expect(err).To(MatchError(ContainSubstring(`dependency "some-entry@1.2.3" has a malformed checksum "sha256:some-sha"`)))
+			Expect(err).To(MatchError(ContainSubstring(`dependency "some-entry@1.2.3" is declared more than once, overwriting the checksum "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"`)))
+			Expect(err).NotTo(MatchError(ContainSubstring("some-entry@4.5.6")))
+		})
+
+		context("when every checksum is well-formed", func() {
+			it("returns nil", func() {
+				registry := postal.ChecksumRegistry{
+					"some-entry@1.2.3": {
+						Checksum:   "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+						Algorithm:  "sha256",
+						WellFormed: true,
+					},
+				}
+				Expect(registry.Validate()).To(Succeed())
+			})
+		})
+	})
+}
@@ -0,0 +1,135 @@
+package postal
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+type checksumHash struct {
+	algorithm string
+	expected  string
+	hash      hash.Hash
+}
+
+// checksumSet computes every checksum listed in the Checksums of a
+// Dependency over the content written to it.
+type checksumSet []checksumHash
+
+func newChecksumSet(checksums map[string]string) (checksumSet, error) {
+	var algorithms []string
+	for algorithm := range checksums {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+
+	var set checksumSet
+	for _, algorithm := range algorithms {
+		var h hash.Hash
+		switch algorithm {
+		case "sha256":
+			h = sha256.New()
+		case "sha512":
+			h = sha512.New()
+		default:
+			return nil, fmt.Errorf("unsupported algorithm %q: the following algorithms are supported [sha256, sha512]", algorithm)
+		}
+
+		set = append(set, checksumHash{
+			algorithm: algorithm,
+			expected:  strings.ToLower(checksums[algorithm]),
+			hash:      h,
+		})
+	}
+
+	return set, nil
+}
+
+func (cs checksumSet) Write(p []byte) (int, error) {
+	for _, c := range cs {
+		// Writing to a hash.Hash never returns an error
+		_, _ = c.hash.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// Validate returns an error wrapping ErrChecksumMismatch when any of the
+// computed checksums does not match the expected value.
+func (cs checksumSet) Validate() error {
+	for _, c := range cs {
+		if hex.EncodeToString(c.hash.Sum(nil)) != c.expected {
+			return fmt.Errorf("%w: %s", ErrChecksumMismatch, c.algorithm)
+		}
+	}
+
+	return nil
+}
+
+// fastVerificationSize is the number of bytes at each end of the content of a
+// dependency that are hashed when its VerificationLevel is "fast".
+const fastVerificationSize = 64 * 1024
+
+// headTailChecksum computes a checksum over only the first and last
+// fastVerificationSize bytes of the content written to it. Content that is no
+// longer than twice that size is hashed in full.
+type headTailChecksum struct {
+	head []byte
+	tail []byte
+}
+
+func (c *headTailChecksum) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if remaining := fastVerificationSize - len(c.head); remaining > 0 {
+		if remaining > len(p) {
+			remaining = len(p)
+		}
+
+		c.head = append(c.head, p[:remaining]...)
+		p = p[remaining:]
+	}
+
+	c.tail = append(c.tail, p...)
+	if len(c.tail) > fastVerificationSize {
+		c.tail = append(c.tail[:0], c.tail[len(c.tail)-fastVerificationSize:]...)
+	}
+
+	return n, nil
+}
+
+// Validate returns an error wrapping ErrChecksumMismatch when the hash of the
+// head and tail of the content does not match the given checksum, which is the
+// FastChecksum of the dependency rather than the checksum of its full
+// content.
+func (c *headTailChecksum) Validate(checksum Checksum) error {
+	h, err := newHash(checksum.Algorithm())
+	if err != nil {
+		return err
+	}
+
+	_, _ = h.Write(c.head)
+	_, _ = h.Write(c.tail)
+
+	if hex.EncodeToString(h.Sum(nil)) != strings.ToLower(checksum.Hash()) {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, checksum.Algorithm())
+	}
+
+	return nil
+}
+
+// newHash returns a hash.Hash for the given checksum algorithm.
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q: the following algorithms are supported [sha256, sha512]", algorithm)
+	}
+}
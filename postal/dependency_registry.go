@@ -0,0 +1,197 @@
+package postal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// DuplicateEntryError describes dependencies in a buildpack.toml file that
+// share the same id, version, and stack, which makes resolving them
+// ambiguous.
+type DuplicateEntryError struct {
+	ID      string
+	Version string
+	Stack   string
+
+	// Lines are the line numbers of the [[metadata.dependencies]] tables of
+	// every dependency that shares the id, version, and stack.
+	Lines []int
+}
+
+// Error implements the error.Error interface
+func (e DuplicateEntryError) Error() string {
+	var lines []string
+	for _, line := range e.Lines {
+		lines = append(lines, fmt.Sprintf("%d", line))
+	}
+
+	return fmt.Sprintf("found duplicate dependency %q version %q for stack %q on lines [%s]", e.ID, e.Version, e.Stack, strings.Join(lines, ", "))
+}
+
+type dependencyTriple struct {
+	id      string
+	version string
+	stack   string
+}
+
+// DependencyRegistry holds the dependencies declared in a buildpack.toml file
+// along with the line at which each of them is declared.
+type DependencyRegistry struct {
+	dependencies []Dependency
+	lines        []int
+}
+
+// NewDependencyRegistry parses the buildpack.toml file at the given path and
+// returns a DependencyRegistry of its dependencies.
+func NewDependencyRegistry(path string) (*DependencyRegistry, error) {
+	buildpack, err := parseBuildpack(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse buildpack.toml: %w", err)
+	}
+
+	var lines []int
+	if tables, ok := tree.Get("metadata.dependencies").([]*toml.Tree); ok {
+		for _, table := range tables {
+			lines = append(lines, table.Position().Line)
+		}
+	}
+
+	if len(lines) != len(buildpack.Dependencies) {
+		return nil, fmt.Errorf("failed to parse buildpack.toml: found %d dependency tables for %d dependencies", len(lines), len(buildpack.Dependencies))
+	}
+
+	return &DependencyRegistry{
+		dependencies: buildpack.Dependencies,
+		lines:        lines,
+	}, nil
+}
+
+// Validate returns a DuplicateEntryError for every id, version, and stack
+// that is declared by more than one dependency, in the order in which the
+// duplicates are first declared. A nil slice is returned when there are no
+// duplicates.
+func (r *DependencyRegistry) Validate() []DuplicateEntryError {
+	var triples []dependencyTriple
+	lines := map[dependencyTriple][]int{}
+	for i, dependency := range r.dependencies {
+		seen := map[string]bool{}
+		for _, stack := range dependency.Stacks {
+			if seen[stack] {
+				continue
+			}
+			seen[stack] = true
+
+			triple := dependencyTriple{id: dependency.ID, version: dependency.Version, stack: stack}
+			if _, ok := lines[triple]; !ok {
+				triples = append(triples, triple)
+			}
+
+			lines[triple] = append(lines[triple], r.lines[i])
+		}
+	}
+
+	var errs []DuplicateEntryError
+	for _, triple := range triples {
+		if len(lines[triple]) < 2 {
+			continue
+		}
+
+		errs = append(errs, DuplicateEntryError{
+			ID:      triple.id,
+			Version: triple.version,
+			Stack:   triple.stack,
+			Lines:   lines[triple],
+		})
+	}
+
+	return errs
+}
+
+// writeDependencyChecksums adds a checksum to the entries of the
+// [[metadata.dependencies]] table of the buildpack.toml at the given path,
+// keyed by their index in that table, and writes the file back in place. Each
+// checksum is inserted as the first key of its entry, so the rest of the file,
+// including its comments and formatting, is left as it is.
+func writeDependencyChecksums(path string, checksums map[int]string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to update buildpack.toml: %w", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to update buildpack.toml: %w", err)
+	}
+
+	tree, err := toml.LoadBytes(content)
+	if err != nil {
+		return fmt.Errorf("failed to update buildpack.toml: %w", err)
+	}
+
+	tables, _ := tree.Get("metadata.dependencies").([]*toml.Tree)
+	lines := strings.SplitAfter(string(content), "\n")
+
+	headers := map[int]string{}
+	for index, checksum := range checksums {
+		if index >= len(tables) {
+			return fmt.Errorf("failed to update buildpack.toml: dependency %d does not exist", index)
+		}
+
+		header := tables[index].Position().Line - 1
+		if header < 0 || header >= len(lines) || !strings.HasPrefix(strings.TrimSpace(lines[header]), "[[") {
+			return fmt.Errorf("failed to update buildpack.toml: dependency %d is not declared as a [[metadata.dependencies]] table", index)
+		}
+
+		headers[header] = checksum
+	}
+
+	var builder strings.Builder
+	for i, line := range lines {
+		checksum, ok := headers[i]
+		if !ok {
+			builder.WriteString(line)
+			continue
+		}
+
+		newline := "\n"
+		if strings.HasSuffix(line, "\r\n") {
+			newline = "\r\n"
+		}
+
+		if !strings.HasSuffix(line, "\n") {
+			line += newline
+		}
+
+		builder.WriteString(line)
+		builder.WriteString(fmt.Sprintf("%schecksum = %q%s", keyIndentation(lines, i), checksum, newline))
+	}
+
+	err = os.WriteFile(path, []byte(builder.String()), info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to update buildpack.toml: %w", err)
+	}
+
+	return nil
+}
+
+// keyIndentation returns the indentation of the first key of the table whose
+// header is on the given line, falling back to that of the header itself.
+func keyIndentation(lines []string, header int) string {
+	line := lines[header]
+	if header+1 < len(lines) {
+		next := strings.TrimSpace(lines[header+1])
+		if next != "" && !strings.HasPrefix(next, "#") && !strings.HasPrefix(next, "[") {
+			line = lines[header+1]
+		}
+	}
+
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
@@ -0,0 +1,113 @@
+package postal_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testDependencyRegistry(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		path string
+	)
+
+	it.Before(func() {
+		file, err := os.CreateTemp("", "buildpack.toml")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = file.Name()
+		_, err = file.WriteString(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["other-stack"]
+uri = "some-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(path)).To(Succeed())
+	})
+
+	context("Validate", func() {
+		it("reports every duplicate id, version, and stack with the lines they are declared on", func() {
+			registry, err := postal.NewDependencyRegistry(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(registry.Validate()).To(Equal([]postal.DuplicateEntryError{
+				{
+					ID:      "some-entry",
+					Version: "1.2.3",
+					Stack:   "some-stack",
+					Lines:   []int{2, 16},
+				},
+			}))
+		})
+
+		context("when there are no duplicates", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+stacks = ["some-stack", "other-stack"]
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+stacks = ["some-stack"]
+version = "4.5.6"
+`), 0600)).To(Succeed())
+			})
+
+			it("returns no errors", func() {
+				registry, err := postal.NewDependencyRegistry(path)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(registry.Validate()).To(BeEmpty())
+			})
+		})
+	})
+
+	context("DuplicateEntryError", func() {
+		it("describes the duplicate", func() {
+			err := postal.DuplicateEntryError{ID: "some-entry", Version: "1.2.3", Stack: "some-stack", Lines: []int{2, 16}}
+			Expect(err).To(MatchError(`found duplicate dependency "some-entry" version "1.2.3" for stack "some-stack" on lines [2, 16]`))
+		})
+	})
+
+	context("failure cases", func() {
+		context("when the buildpack.toml is malformed", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(path, []byte("this is not toml"), 0600)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := postal.NewDependencyRegistry(path)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse buildpack.toml")))
+			})
+		})
+	})
+}
@@ -0,0 +1,90 @@
+package postal
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+type etaSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// ETAReader is an io.Reader that tracks the rate at which bytes are read from
+// the reader it wraps over a rolling window of time and uses it to estimate
+// how long it will take to read the rest of a stream of a known size.
+type ETAReader struct {
+	reader io.Reader
+	total  int64
+	window time.Duration
+
+	mutex   sync.Mutex
+	read    int64
+	samples []etaSample
+}
+
+// NewETAReader returns an ETAReader that reads from reader, which is expected
+// to yield total bytes, such as the Content-Length of a download. The read
+// rate is measured over the given window of time.
+func NewETAReader(reader io.Reader, total int64, window time.Duration) *ETAReader {
+	return &ETAReader{
+		reader:  reader,
+		total:   total,
+		window:  window,
+		samples: []etaSample{{at: time.Now()}},
+	}
+}
+
+func (r *ETAReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	r.read += int64(n)
+	r.samples = append(r.samples, etaSample{at: now, bytes: r.read})
+
+	// Drop the samples that have fallen out of the window, always keeping
+	// one to measure the rate from
+	cutoff := now.Add(-r.window)
+	for len(r.samples) > 2 && r.samples[1].at.Before(cutoff) {
+		r.samples = r.samples[1:]
+	}
+
+	return n, err
+}
+
+// ETA returns the estimated time remaining until the stream has been read in
+// full. Zero is returned once every byte has been read, and -1 is returned
+// when no estimate can be made yet because no bytes have been read.
+func (r *ETAReader) ETA() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	remaining := r.total - r.read
+	if remaining <= 0 {
+		return 0
+	}
+
+	first, last := r.samples[0], r.samples[len(r.samples)-1]
+	elapsed := last.at.Sub(first.at)
+	if last.bytes == first.bytes || elapsed <= 0 {
+		return -1
+	}
+
+	bytesPerSecond := float64(last.bytes-first.bytes) / elapsed.Seconds()
+
+	return time.Duration(float64(remaining) / bytesPerSecond * float64(time.Second))
+}
+
+// ETASeconds returns ETA rounded to a whole number of seconds.
+func (r *ETAReader) ETASeconds() int {
+	eta := r.ETA()
+	if eta < 0 {
+		return -1
+	}
+
+	return int(eta.Round(time.Second) / time.Second)
+}
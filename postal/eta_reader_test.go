@@ -0,0 +1,76 @@
+package postal_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+// constantRateReader yields chunk bytes every interval.
+type constantRateReader struct {
+	reader   io.Reader
+	chunk    int
+	interval time.Duration
+}
+
+func (r constantRateReader) Read(p []byte) (int, error) {
+	time.Sleep(r.interval)
+
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+
+	return r.reader.Read(p)
+}
+
+func testETAReader(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		reader *postal.ETAReader
+	)
+
+	it.Before(func() {
+		// 1000 bytes every 10ms is 100000 bytes per second
+		reader = postal.NewETAReader(constantRateReader{
+			reader:   bytes.NewReader(make([]byte, 40000)),
+			chunk:    1000,
+			interval: 10 * time.Millisecond,
+		}, 40000, 100*time.Millisecond)
+	})
+
+	context("ETA", func() {
+		it("is unknown before any bytes are read", func() {
+			Expect(reader.ETA()).To(Equal(time.Duration(-1)))
+			Expect(reader.ETASeconds()).To(Equal(-1))
+		})
+
+		it("converges on the time remaining at the current rate", func() {
+			_, err := io.CopyN(io.Discard, reader, 10000)
+			Expect(err).NotTo(HaveOccurred())
+
+			// 30000 bytes remain at 100000 bytes per second
+			Expect(reader.ETA()).To(BeNumerically("~", 300*time.Millisecond, 150*time.Millisecond))
+
+			_, err = io.CopyN(io.Discard, reader, 20000)
+			Expect(err).NotTo(HaveOccurred())
+
+			// 10000 bytes remain at 100000 bytes per second
+			Expect(reader.ETA()).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+			Expect(reader.ETASeconds()).To(Equal(0))
+		})
+
+		it("is zero once every byte has been read", func() {
+			_, err := io.Copy(io.Discard, reader)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(reader.ETA()).To(Equal(time.Duration(0)))
+		})
+	})
+}
@@ -0,0 +1,28 @@
+package fakes
+
+import (
+	"sync"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+type DependencyAnnotator struct {
+	AnnotateCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			Dependency *postal.Dependency
+		}
+		Stub func(*postal.Dependency)
+	}
+}
+
+func (f *DependencyAnnotator) Annotate(param1 *postal.Dependency) {
+	f.AnnotateCall.mutex.Lock()
+	defer f.AnnotateCall.mutex.Unlock()
+	f.AnnotateCall.CallCount++
+	f.AnnotateCall.Receives.Dependency = param1
+	if f.AnnotateCall.Stub != nil {
+		f.AnnotateCall.Stub(param1)
+	}
+}
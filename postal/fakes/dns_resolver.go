@@ -0,0 +1,34 @@
+package fakes
+
+import (
+	"context"
+	"sync"
+)
+
+type DNSResolver struct {
+	LookupHostCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			Ctx  context.Context
+			Host string
+		}
+		Returns struct {
+			StringSlice []string
+			Error       error
+		}
+		Stub func(context.Context, string) ([]string, error)
+	}
+}
+
+func (f *DNSResolver) LookupHost(param1 context.Context, param2 string) ([]string, error) {
+	f.LookupHostCall.mutex.Lock()
+	defer f.LookupHostCall.mutex.Unlock()
+	f.LookupHostCall.CallCount++
+	f.LookupHostCall.Receives.Ctx = param1
+	f.LookupHostCall.Receives.Host = param2
+	if f.LookupHostCall.Stub != nil {
+		return f.LookupHostCall.Stub(param1, param2)
+	}
+	return f.LookupHostCall.Returns.StringSlice, f.LookupHostCall.Returns.Error
+}
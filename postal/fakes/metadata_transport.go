@@ -0,0 +1,61 @@
+package fakes
+
+import (
+	"io"
+	"sync"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+)
+
+type MetadataTransport struct {
+	DropCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			Root string
+			Uri  string
+		}
+		Returns struct {
+			ReadCloser io.ReadCloser
+			Error      error
+		}
+		Stub func(string, string) (io.ReadCloser, error)
+	}
+	DropWithMetadataCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			Root string
+			Uri  string
+		}
+		Returns struct {
+			ReadCloser        io.ReadCloser
+			TransportMetadata postal.TransportMetadata
+			Error             error
+		}
+		Stub func(string, string) (io.ReadCloser, postal.TransportMetadata, error)
+	}
+}
+
+func (f *MetadataTransport) Drop(param1 string, param2 string) (io.ReadCloser, error) {
+	f.DropCall.mutex.Lock()
+	defer f.DropCall.mutex.Unlock()
+	f.DropCall.CallCount++
+	f.DropCall.Receives.Root = param1
+	f.DropCall.Receives.Uri = param2
+	if f.DropCall.Stub != nil {
+		return f.DropCall.Stub(param1, param2)
+	}
+	return f.DropCall.Returns.ReadCloser, f.DropCall.Returns.Error
+}
+func (f *MetadataTransport) DropWithMetadata(param1 string, param2 string) (io.ReadCloser, postal.TransportMetadata, error) {
+	f.DropWithMetadataCall.mutex.Lock()
+	defer f.DropWithMetadataCall.mutex.Unlock()
+	f.DropWithMetadataCall.CallCount++
+	f.DropWithMetadataCall.Receives.Root = param1
+	f.DropWithMetadataCall.Receives.Uri = param2
+	if f.DropWithMetadataCall.Stub != nil {
+		return f.DropWithMetadataCall.Stub(param1, param2)
+	}
+	return f.DropWithMetadataCall.Returns.ReadCloser, f.DropWithMetadataCall.Returns.TransportMetadata, f.DropWithMetadataCall.Returns.Error
+}
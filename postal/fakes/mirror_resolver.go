@@ -0,0 +1,29 @@
+package fakes
+
+import "sync"
+
+type MirrorResolver struct {
+	FindDependencyMirrorCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			Uri string
+		}
+		Returns struct {
+			String string
+			Error  error
+		}
+		Stub func(string) (string, error)
+	}
+}
+
+func (f *MirrorResolver) FindDependencyMirror(param1 string) (string, error) {
+	f.FindDependencyMirrorCall.mutex.Lock()
+	defer f.FindDependencyMirrorCall.mutex.Unlock()
+	f.FindDependencyMirrorCall.CallCount++
+	f.FindDependencyMirrorCall.Receives.Uri = param1
+	if f.FindDependencyMirrorCall.Stub != nil {
+		return f.FindDependencyMirrorCall.Stub(param1)
+	}
+	return f.FindDependencyMirrorCall.Returns.String, f.FindDependencyMirrorCall.Returns.Error
+}
@@ -0,0 +1,29 @@
+package fakes
+
+import "sync"
+
+type RekorSubmitter struct {
+	SubmitCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			BomJSON []byte
+		}
+		Returns struct {
+			RekorURL string
+			Err      error
+		}
+		Stub func([]byte) (string, error)
+	}
+}
+
+func (f *RekorSubmitter) Submit(param1 []byte) (string, error) {
+	f.SubmitCall.mutex.Lock()
+	defer f.SubmitCall.mutex.Unlock()
+	f.SubmitCall.CallCount++
+	f.SubmitCall.Receives.BomJSON = param1
+	if f.SubmitCall.Stub != nil {
+		return f.SubmitCall.Stub(param1)
+	}
+	return f.SubmitCall.Returns.RekorURL, f.SubmitCall.Returns.Err
+}
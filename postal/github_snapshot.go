@@ -0,0 +1,111 @@
+package postal
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// GitHubSnapshotDetectorName is the name of the detector recorded in the
+	// snapshot produced by GenerateGitHubDependencySnapshot.
+	GitHubSnapshotDetectorName = "packit-postal"
+
+	// GitHubSnapshotDetectorURL is the url of the detector recorded in the
+	// snapshot produced by GenerateGitHubDependencySnapshot.
+	GitHubSnapshotDetectorURL = "https://github.com/paketo-buildpacks/packit"
+
+	// GitHubSnapshotManifest is the name of the manifest that every dependency
+	// is listed under in the snapshot produced by
+	// GenerateGitHubDependencySnapshot.
+	GitHubSnapshotManifest = "buildpack.toml"
+)
+
+type gitHubSnapshot struct {
+	Version   int                               `json:"version"`
+	SHA       string                            `json:"sha"`
+	Ref       string                            `json:"ref"`
+	Job       gitHubSnapshotJob                 `json:"job"`
+	Detector  gitHubSnapshotDetector            `json:"detector"`
+	Manifests map[string]gitHubSnapshotManifest `json:"manifests"`
+}
+
+type gitHubSnapshotJob struct {
+	Correlator string `json:"correlator"`
+	ID         string `json:"id"`
+}
+
+type gitHubSnapshotDetector struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+}
+
+type gitHubSnapshotManifest struct {
+	Name     string                           `json:"name"`
+	File     gitHubSnapshotFile               `json:"file"`
+	Resolved map[string]gitHubSnapshotPackage `json:"resolved"`
+}
+
+type gitHubSnapshotFile struct {
+	SourceLocation string `json:"source_location"`
+}
+
+type gitHubSnapshotPackage struct {
+	PackageURL   string `json:"package_url"`
+	Relationship string `json:"relationship"`
+	Scope        string `json:"scope"`
+}
+
+// GenerateGitHubDependencySnapshot will generate a snapshot in the format
+// accepted by the GitHub dependency submission API
+// (https://docs.github.com/en/rest/dependency-graph/dependency-submission)
+// describing the given collection of Dependency values. The buildpackRef and
+// sha identify the git ref and commit of the buildpack the dependencies belong
+// to. Every dependency is listed as a direct runtime dependency of the
+// buildpack.toml manifest. Dependencies without a PURL are identified by a
+// generic package url built from their ID and version.
+func (s Service) GenerateGitHubDependencySnapshot(buildpackRef, sha string, dependencies ...Dependency) ([]byte, error) {
+	manifest := gitHubSnapshotManifest{
+		Name:     GitHubSnapshotManifest,
+		File:     gitHubSnapshotFile{SourceLocation: GitHubSnapshotManifest},
+		Resolved: map[string]gitHubSnapshotPackage{},
+	}
+
+	for _, dependency := range dependencies {
+		purl := dependency.PURL
+		if purl == "" {
+			purl = fmt.Sprintf("pkg:generic/%s@%s", dependency.ID, dependency.Version)
+		}
+
+		manifest.Resolved[fmt.Sprintf("%s@%s", dependency.ID, dependency.Version)] = gitHubSnapshotPackage{
+			PackageURL:   purl,
+			Relationship: "direct",
+			Scope:        "runtime",
+		}
+	}
+
+	snapshot := gitHubSnapshot{
+		Version: 0,
+		SHA:     sha,
+		Ref:     buildpackRef,
+		Job: gitHubSnapshotJob{
+			Correlator: GitHubSnapshotDetectorName,
+			ID:         sha,
+		},
+		Detector: gitHubSnapshotDetector{
+			Name:    GitHubSnapshotDetectorName,
+			Version: "v2",
+			URL:     GitHubSnapshotDetectorURL,
+		},
+		Manifests: map[string]gitHubSnapshotManifest{
+			GitHubSnapshotManifest: manifest,
+		},
+	}
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate github dependency snapshot: %w", err)
+	}
+
+	return content, nil
+}
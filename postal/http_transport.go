@@ -0,0 +1,390 @@
+package postal
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSResolver serves as the interface for types that can look up the
+// addresses of a host. It is satisfied by *net.Resolver.
+//
+//go:generate faux --interface DNSResolver --output fakes/dns_resolver.go
+type DNSResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// HTTPTransportConfig is the set of configurable options for an
+// HTTPTransport.
+type HTTPTransportConfig struct {
+	maxIdleConns    int
+	maxConnsPerHost int
+
+	dnsCacheTTL time.Duration
+	dnsResolver DNSResolver
+
+	disableCompression bool
+	acceptEncodings    []string
+
+	tlsClientConfig *tls.Config
+	httpVersion     HTTPVersion
+
+	logWriter io.Writer
+}
+
+// HTTPVersion is the version of the HTTP protocol that an HTTPTransport uses
+// to make requests. See WithHTTPVersion.
+type HTTPVersion int
+
+const (
+	// HTTPAuto uses HTTP/2 when the server supports it and HTTP/1.1 otherwise.
+	HTTPAuto HTTPVersion = iota
+
+	// HTTP1 only ever uses HTTP/1.1.
+	HTTP1
+
+	// HTTP2 always attempts to use HTTP/2, even when a custom TLS client
+	// configuration is given, falling back to HTTP/1.1 only when the server
+	// does not support HTTP/2.
+	HTTP2
+)
+
+// HTTPTransportOption declares a function signature that can be used to
+// define optional modifications to the behavior of an HTTPTransport.
+type HTTPTransportOption func(config HTTPTransportConfig) HTTPTransportConfig
+
+// WithMaxIdleConns is an HTTPTransportOption that sets the maximum number of
+// idle (keep-alive) connections kept in the connection pool, both in total
+// and per host.
+func WithMaxIdleConns(n int) HTTPTransportOption {
+	return func(config HTTPTransportConfig) HTTPTransportConfig {
+		config.maxIdleConns = n
+		return config
+	}
+}
+
+// WithMaxConnsPerHost is an HTTPTransportOption that limits the total number
+// of connections per host. Requests made beyond this limit will wait for a
+// connection to become available rather than failing.
+func WithMaxConnsPerHost(n int) HTTPTransportOption {
+	return func(config HTTPTransportConfig) HTTPTransportConfig {
+		config.maxConnsPerHost = n
+		return config
+	}
+}
+
+// WithDNSCache is an HTTPTransportOption that caches the addresses a
+// hostname resolves to for the given ttl so that repeated connections to the
+// same host do not each perform a DNS lookup.
+func WithDNSCache(ttl time.Duration) HTTPTransportOption {
+	return func(config HTTPTransportConfig) HTTPTransportConfig {
+		config.dnsCacheTTL = ttl
+		return config
+	}
+}
+
+// WithDNSResolver is an HTTPTransportOption that sets the DNSResolver used to
+// look up the addresses of a host when WithDNSCache is given. It defaults to
+// net.DefaultResolver.
+func WithDNSResolver(resolver DNSResolver) HTTPTransportOption {
+	return func(config HTTPTransportConfig) HTTPTransportConfig {
+		config.dnsResolver = resolver
+		return config
+	}
+}
+
+// WithDisableHTTPCompression is an HTTPTransportOption that stops the
+// transport from requesting and transparently decompressing gzip encoded
+// responses, so that the bytes served for a dependency are returned unmodified.
+func WithDisableHTTPCompression() HTTPTransportOption {
+	return func(config HTTPTransportConfig) HTTPTransportConfig {
+		config.disableCompression = true
+		return config
+	}
+}
+
+// WithAcceptEncoding is an HTTPTransportOption that sets the Accept-Encoding
+// header of every request to the given encodings, such as "identity", rather
+// than letting the transport request a gzip encoded response. This stops
+// servers from compressing dependencies, such as .tgz files, that are already
+// compressed. Responses are returned as they are served, without being
+// transparently decompressed.
+func WithAcceptEncoding(encodings ...string) HTTPTransportOption {
+	return func(config HTTPTransportConfig) HTTPTransportConfig {
+		config.acceptEncodings = encodings
+		return config
+	}
+}
+
+// WithTLSClientConfig is an HTTPTransportOption that sets the TLS
+// configuration used to connect to servers, for example to trust the
+// certificate authority of a private dependency mirror.
+func WithTLSClientConfig(config *tls.Config) HTTPTransportOption {
+	return func(c HTTPTransportConfig) HTTPTransportConfig {
+		c.tlsClientConfig = config
+		return c
+	}
+}
+
+// WithHTTPVersion is an HTTPTransportOption that sets the version of the HTTP
+// protocol used to make requests. Some dependency mirrors have HTTP/2
+// implementations that cause downloads to stall, which HTTP1 works around. It
+// defaults to HTTPAuto.
+func WithHTTPVersion(v HTTPVersion) HTTPTransportOption {
+	return func(config HTTPTransportConfig) HTTPTransportConfig {
+		config.httpVersion = v
+		return config
+	}
+}
+
+// WithLogWriter is an HTTPTransportOption that sets a writer that the
+// transport writes a line to for every http(s) download, reporting the size of
+// the download given by the Content-Length header of the response when the
+// server sends one.
+func WithLogWriter(w io.Writer) HTTPTransportOption {
+	return func(config HTTPTransportConfig) HTTPTransportConfig {
+		config.logWriter = w
+		return config
+	}
+}
+
+// HTTPTransport is a Transport that fetches dependencies using either the
+// http(s):// or file:// scheme with a configurable connection pool.
+type HTTPTransport struct {
+	client         *http.Client
+	acceptEncoding string
+	logWriter      io.Writer
+}
+
+// NewHTTPTransport creates an instance of an HTTPTransport given a set of
+// HTTPTransportOption values.
+func NewHTTPTransport(options ...HTTPTransportOption) HTTPTransport {
+	var config HTTPTransportConfig
+	for _, option := range options {
+		config = option(config)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.maxIdleConns > 0 {
+		transport.MaxIdleConns = config.maxIdleConns
+		transport.MaxIdleConnsPerHost = config.maxIdleConns
+	}
+
+	if config.maxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = config.maxConnsPerHost
+	}
+
+	transport.DisableCompression = config.disableCompression
+
+	if config.tlsClientConfig != nil {
+		transport.TLSClientConfig = config.tlsClientConfig.Clone()
+	}
+
+	switch config.httpVersion {
+	case HTTP1:
+		// A non-nil, empty TLSNextProto disables HTTP/2, and the protocol must no
+		// longer be offered to servers during the TLS handshake
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if transport.TLSClientConfig != nil {
+			transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+			transport.TLSClientConfig.NextProtos = nil
+		}
+	case HTTP2:
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	if config.dnsCacheTTL > 0 {
+		resolver := config.dnsResolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+
+		cache := &dnsCache{
+			resolver: resolver,
+			ttl:      config.dnsCacheTTL,
+			entries:  map[string]dnsCacheEntry{},
+		}
+
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+
+			if net.ParseIP(host) != nil {
+				return dialer.DialContext(ctx, network, address)
+			}
+
+			addrs, err := cache.LookupHost(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, addr := range addrs {
+				var conn net.Conn
+				conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+				if err == nil {
+					return conn, nil
+				}
+			}
+
+			return nil, err
+		}
+	}
+
+	return HTTPTransport{
+		client:         &http.Client{Transport: transport},
+		acceptEncoding: strings.Join(config.acceptEncodings, ", "),
+		logWriter:      config.logWriter,
+	}
+}
+
+// Drop fetches the dependency at the given uri. Locations using the file://
+// scheme are resolved relative to the given root directory.
+func (t HTTPTransport) Drop(root, uri string) (io.ReadCloser, error) {
+	bundle, metadata, err := t.DropWithMetadata(root, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.ContentLength > 0 {
+		return sizedReadCloser{ReadCloser: bundle, size: metadata.ContentLength}, nil
+	}
+
+	return bundle, nil
+}
+
+// DropWithMetadata fetches the dependency at the given uri, reporting the
+// ETag, Last-Modified, Content-Type, and Content-Length headers of the
+// response. For locations using the file:// scheme, only the modification
+// time and size of the file are reported.
+func (t HTTPTransport) DropWithMetadata(root, uri string) (io.ReadCloser, TransportMetadata, error) {
+	if strings.HasPrefix(uri, "file://") {
+		file, err := os.Open(filepath.Join(root, strings.TrimPrefix(uri, "file://")))
+		if err != nil {
+			return nil, TransportMetadata{}, fmt.Errorf("failed to open file: %s", err)
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, TransportMetadata{}, fmt.Errorf("failed to stat file: %s", err)
+		}
+
+		return file, TransportMetadata{
+			LastModified:  info.ModTime(),
+			ContentLength: info.Size(),
+		}, nil
+	}
+
+	request, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, TransportMetadata{}, fmt.Errorf("failed to parse request uri: %s", err)
+	}
+
+	if t.acceptEncoding != "" {
+		request.Header.Set("Accept-Encoding", t.acceptEncoding)
+	}
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		return nil, TransportMetadata{}, fmt.Errorf("failed to make request: %s", err)
+	}
+
+	if response.StatusCode >= 400 {
+		response.Body.Close()
+		return nil, TransportMetadata{}, fmt.Errorf("unexpected status code %d while fetching %q", response.StatusCode, uri)
+	}
+
+	metadata := TransportMetadata{
+		ETag:        response.Header.Get("ETag"),
+		ContentType: response.Header.Get("Content-Type"),
+	}
+
+	if response.ContentLength > 0 {
+		metadata.ContentLength = response.ContentLength
+	}
+
+	if t.logWriter != nil {
+		// Credentials in the uri, such as those of a dependency mirror, are
+		// left out of the log
+		if metadata.ContentLength > 0 {
+			fmt.Fprintf(t.logWriter, "Downloading %s (%d bytes)\n", request.URL.Redacted(), metadata.ContentLength)
+		} else {
+			fmt.Fprintf(t.logWriter, "Downloading %s\n", request.URL.Redacted())
+		}
+	}
+
+	lastModified, err := http.ParseTime(response.Header.Get("Last-Modified"))
+	if err == nil {
+		metadata.LastModified = lastModified
+	}
+
+	return response.Body, metadata, nil
+}
+
+// sizedReadCloser is an io.ReadCloser that also reports the total number of
+// bytes it will yield, as given by the Content-Length of a response.
+type sizedReadCloser struct {
+	io.ReadCloser
+	size int64
+}
+
+func (r sizedReadCloser) Size() int64 {
+	return r.size
+}
+
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache is a DNSResolver that remembers the result of each successful
+// lookup until its ttl has passed.
+type dnsCache struct {
+	resolver DNSResolver
+	ttl      time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+func (c *dnsCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, ok := c.entries[host]; ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for host %q", host)
+	}
+
+	c.entries[host] = dnsCacheEntry{
+		addrs:   addrs,
+		expires: time.Now().Add(c.ttl),
+	}
+
+	return addrs, nil
+}
@@ -0,0 +1,536 @@
+package postal_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/postal/fakes"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testHTTPTransport(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		transport postal.HTTPTransport
+	)
+
+	it.Before(func() {
+		transport = postal.NewHTTPTransport()
+	})
+
+	context("Drop", func() {
+		context("when the given uri is online", func() {
+			var server *httptest.Server
+
+			it.Before(func() {
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					switch req.URL.Path {
+					case "/some-bundle":
+						fmt.Fprint(w, "some-bundle-contents")
+					default:
+						http.NotFound(w, req)
+					}
+				}))
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("downloads the file from a URI", func() {
+				bundle, err := transport.Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, err := io.ReadAll(bundle)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal("some-bundle-contents"))
+
+				Expect(bundle.Close()).To(Succeed())
+			})
+
+			context("failure cases", func() {
+				context("when the uri is malformed", func() {
+					it("returns an error", func() {
+						_, err := transport.Drop("", "%%%%")
+						Expect(err).To(MatchError(ContainSubstring("failed to parse request uri")))
+					})
+				})
+
+				context("when the request fails", func() {
+					it.Before(func() {
+						server.Close()
+					})
+
+					it("returns an error", func() {
+						_, err := transport.Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+						Expect(err).To(MatchError(ContainSubstring("failed to make request")))
+					})
+				})
+
+				context("when the http status indicates an error", func() {
+					it("returns an error", func() {
+						_, err := transport.Drop("", fmt.Sprintf("%s/some-missing-bundle", server.URL))
+						Expect(err).To(MatchError(fmt.Sprintf("unexpected status code 404 while fetching %q", fmt.Sprintf("%s/some-missing-bundle", server.URL))))
+					})
+				})
+			})
+		})
+
+		context("when the connection pool is limited", func() {
+			var (
+				server *httptest.Server
+
+				mutex    sync.Mutex
+				inFlight int
+				peak     int
+			)
+
+			it.Before(func() {
+				inFlight, peak = 0, 0
+
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					mutex.Lock()
+					inFlight++
+					if inFlight > peak {
+						peak = inFlight
+					}
+					mutex.Unlock()
+
+					time.Sleep(10 * time.Millisecond)
+					fmt.Fprint(w, "some-bundle-contents")
+
+					mutex.Lock()
+					inFlight--
+					mutex.Unlock()
+				}))
+
+				transport = postal.NewHTTPTransport(postal.WithMaxIdleConns(1), postal.WithMaxConnsPerHost(1))
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("serializes concurrent requests rather than failing", func() {
+				var wg sync.WaitGroup
+				errs := make(chan error, 5)
+
+				for i := 0; i < 5; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+
+						bundle, err := transport.Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+						if err != nil {
+							errs <- err
+							return
+						}
+						defer bundle.Close()
+
+						_, err = io.ReadAll(bundle)
+						if err != nil {
+							errs <- err
+						}
+					}()
+				}
+
+				wg.Wait()
+				close(errs)
+
+				for err := range errs {
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				Expect(peak).To(Equal(1))
+			})
+		})
+
+		context("when http compression is disabled", func() {
+			var (
+				server         *httptest.Server
+				acceptEncoding string
+			)
+
+			it.Before(func() {
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					acceptEncoding = req.Header.Get("Accept-Encoding")
+					fmt.Fprint(w, "some-bundle-contents")
+				}))
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("does not request a compressed response", func() {
+				bundle, err := postal.NewHTTPTransport().Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+				Expect(acceptEncoding).To(Equal("gzip"))
+
+				bundle, err = postal.NewHTTPTransport(postal.WithDisableHTTPCompression()).Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+				Expect(acceptEncoding).To(BeEmpty())
+			})
+		})
+
+		context("when the accepted encodings are given", func() {
+			var (
+				server         *httptest.Server
+				acceptEncoding string
+			)
+
+			it.Before(func() {
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					acceptEncoding = req.Header.Get("Accept-Encoding")
+
+					// Compress the response whenever the client accepts it
+					if strings.Contains(acceptEncoding, "gzip") {
+						w.Header().Set("Content-Encoding", "gzip")
+						zw := gzip.NewWriter(w)
+						fmt.Fprint(zw, "some-bundle-contents")
+						zw.Close()
+						return
+					}
+
+					fmt.Fprint(w, "some-bundle-contents")
+				}))
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("sets the Accept-Encoding header so the server does not compress the response", func() {
+				bundle, err := postal.NewHTTPTransport(postal.WithAcceptEncoding("identity")).Drop("", fmt.Sprintf("%s/some-bundle.tgz", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, err := io.ReadAll(bundle)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+
+				Expect(acceptEncoding).To(Equal("identity"))
+				Expect(string(contents)).To(Equal("some-bundle-contents"))
+			})
+
+			it("returns compressed responses without decompressing them", func() {
+				bundle, err := postal.NewHTTPTransport(postal.WithAcceptEncoding("gzip", "identity")).Drop("", fmt.Sprintf("%s/some-bundle.tgz", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+
+				zr, err := gzip.NewReader(bundle)
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, err := io.ReadAll(zr)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+
+				Expect(acceptEncoding).To(Equal("gzip, identity"))
+				Expect(string(contents)).To(Equal("some-bundle-contents"))
+			})
+		})
+
+		context("when the http version is configured", func() {
+			var (
+				server    *httptest.Server
+				tlsConfig *tls.Config
+				proto     string
+			)
+
+			it.Before(func() {
+				server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					proto = req.Proto
+					fmt.Fprint(w, "some-bundle-contents")
+				}))
+				server.EnableHTTP2 = true
+				server.StartTLS()
+
+				pool := x509.NewCertPool()
+				pool.AddCert(server.Certificate())
+				tlsConfig = &tls.Config{RootCAs: pool}
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("uses HTTP/2 when it is forced", func() {
+				bundle, err := postal.NewHTTPTransport(postal.WithTLSClientConfig(tlsConfig), postal.WithHTTPVersion(postal.HTTP2)).Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+				Expect(proto).To(Equal("HTTP/2.0"))
+			})
+
+			it("uses HTTP/1.1 when HTTP/2 is disabled", func() {
+				bundle, err := postal.NewHTTPTransport(postal.WithTLSClientConfig(tlsConfig), postal.WithHTTPVersion(postal.HTTP1)).Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+				Expect(proto).To(Equal("HTTP/1.1"))
+			})
+
+			it("uses HTTP/2 when the server supports it by default", func() {
+				bundle, err := postal.NewHTTPTransport(postal.WithTLSClientConfig(tlsConfig)).Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+				Expect(proto).To(Equal("HTTP/2.0"))
+			})
+		})
+
+		context("when a log writer is given", func() {
+			var (
+				server *httptest.Server
+				buffer *bytes.Buffer
+			)
+
+			it.Before(func() {
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					switch req.URL.Path {
+					case "/some-bundle":
+						w.Header().Set("Content-Length", strconv.Itoa(len("some-bundle-contents")))
+						fmt.Fprint(w, "some-bundle-contents")
+					case "/some-streamed-bundle":
+						fmt.Fprint(w, "some-bundle-contents")
+						w.(http.Flusher).Flush()
+					default:
+						http.NotFound(w, req)
+					}
+				}))
+
+				buffer = bytes.NewBuffer(nil)
+				transport = postal.NewHTTPTransport(postal.WithLogWriter(buffer))
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("logs the size of the download", func() {
+				bundle, err := transport.Drop("", fmt.Sprintf("%s/some-bundle", server.URL))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+
+				Expect(buffer.String()).To(Equal(fmt.Sprintf("Downloading %s/some-bundle (20 bytes)\n", server.URL)))
+			})
+
+			context("when the uri has credentials", func() {
+				it("leaves the password out of the log", func() {
+					uri := strings.Replace(server.URL, "http://", "http://some-user:some-password@", 1)
+
+					bundle, err := transport.Drop("", fmt.Sprintf("%s/some-bundle", uri))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(bundle.Close()).To(Succeed())
+
+					Expect(buffer.String()).NotTo(ContainSubstring("some-password"))
+					Expect(buffer.String()).To(ContainSubstring("some-user:xxxxx@"))
+				})
+			})
+
+			context("when the server does not send a Content-Length", func() {
+				it("logs the download without a size", func() {
+					bundle, err := transport.Drop("", fmt.Sprintf("%s/some-streamed-bundle", server.URL))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(bundle.Close()).To(Succeed())
+
+					Expect(buffer.String()).To(Equal(fmt.Sprintf("Downloading %s/some-streamed-bundle\n", server.URL)))
+				})
+			})
+		})
+
+		context("when the dns cache is enabled", func() {
+			var (
+				server   *httptest.Server
+				resolver *fakes.DNSResolver
+				uri      string
+			)
+
+			it.Before(func() {
+				server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					fmt.Fprint(w, "some-bundle-contents")
+				}))
+				// Every request must dial a new connection so that each one needs
+				// the address of the host
+				server.Config.SetKeepAlivesEnabled(false)
+				server.Start()
+
+				serverURL, err := url.Parse(server.URL)
+				Expect(err).NotTo(HaveOccurred())
+
+				uri = fmt.Sprintf("http://some-host:%s", serverURL.Port())
+
+				resolver = &fakes.DNSResolver{}
+				resolver.LookupHostCall.Returns.StringSlice = []string{serverURL.Hostname()}
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("looks up the host once within the ttl", func() {
+				transport = postal.NewHTTPTransport(postal.WithDNSCache(time.Minute), postal.WithDNSResolver(resolver))
+
+				for _, path := range []string{"/some-bundle", "/some-other-bundle"} {
+					bundle, err := transport.Drop("", uri+path)
+					Expect(err).NotTo(HaveOccurred())
+
+					contents, err := io.ReadAll(bundle)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(contents)).To(Equal("some-bundle-contents"))
+
+					Expect(bundle.Close()).To(Succeed())
+				}
+
+				Expect(resolver.LookupHostCall.CallCount).To(Equal(1))
+				Expect(resolver.LookupHostCall.Receives.Host).To(Equal("some-host"))
+			})
+
+			context("when the ttl has passed", func() {
+				it("looks up the host again", func() {
+					transport = postal.NewHTTPTransport(postal.WithDNSCache(time.Nanosecond), postal.WithDNSResolver(resolver))
+
+					for _, path := range []string{"/some-bundle", "/some-other-bundle"} {
+						bundle, err := transport.Drop("", uri+path)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(bundle.Close()).To(Succeed())
+
+						time.Sleep(time.Millisecond)
+					}
+
+					Expect(resolver.LookupHostCall.CallCount).To(Equal(2))
+				})
+			})
+
+			context("failure cases", func() {
+				context("when the lookup fails", func() {
+					it.Before(func() {
+						resolver.LookupHostCall.Returns.Error = errors.New("some lookup error")
+					})
+
+					it("returns an error", func() {
+						transport = postal.NewHTTPTransport(postal.WithDNSCache(time.Minute), postal.WithDNSResolver(resolver))
+
+						_, err := transport.Drop("", uri+"/some-bundle")
+						Expect(err).To(MatchError(ContainSubstring("some lookup error")))
+					})
+				})
+			})
+		})
+
+		context("when the given uri is for an offline dependency", func() {
+			var dir string
+
+			it.Before(func() {
+				var err error
+				dir, err = os.MkdirTemp("", "bundle")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(os.WriteFile(filepath.Join(dir, "some-file"), []byte("some-file-contents"), 0644)).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(dir)).To(Succeed())
+			})
+
+			it("returns the file from the given root", func() {
+				bundle, err := transport.Drop(dir, "file:///some-file")
+				Expect(err).NotTo(HaveOccurred())
+
+				contents, err := io.ReadAll(bundle)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal("some-file-contents"))
+
+				Expect(bundle.Close()).To(Succeed())
+			})
+
+			context("when the file does not exist", func() {
+				it("returns an error", func() {
+					_, err := transport.Drop(dir, "file:///no-such-file")
+					Expect(err).To(MatchError(ContainSubstring("failed to open file")))
+				})
+			})
+		})
+	})
+
+	context("DropWithMetadata", func() {
+		var server *httptest.Server
+
+		it.Before(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("ETag", `"some-etag"`)
+				w.Header().Set("Last-Modified", "Mon, 02 Jan 2023 03:04:05 GMT")
+				w.Header().Set("Content-Type", "application/gzip")
+				fmt.Fprint(w, "some-bundle-contents")
+			}))
+		})
+
+		it.After(func() {
+			server.Close()
+		})
+
+		it("returns the metadata of the response alongside its content", func() {
+			bundle, metadata, err := transport.DropWithMetadata("", fmt.Sprintf("%s/some-bundle", server.URL))
+			Expect(err).NotTo(HaveOccurred())
+
+			contents, err := io.ReadAll(bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("some-bundle-contents"))
+
+			Expect(bundle.Close()).To(Succeed())
+
+			Expect(metadata).To(Equal(postal.TransportMetadata{
+				ETag:          `"some-etag"`,
+				LastModified:  time.Date(2023, time.January, 2, 3, 4, 5, 0, time.UTC),
+				ContentType:   "application/gzip",
+				ContentLength: int64(len("some-bundle-contents")),
+			}))
+		})
+
+		context("when the given uri is for an offline dependency", func() {
+			var dir string
+
+			it.Before(func() {
+				var err error
+				dir, err = os.MkdirTemp("", "bundle")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(os.WriteFile(filepath.Join(dir, "some-file"), []byte("some-file-contents"), 0644)).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(dir)).To(Succeed())
+			})
+
+			it("returns the size and modification time of the file", func() {
+				bundle, metadata, err := transport.DropWithMetadata(dir, "file:///some-file")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bundle.Close()).To(Succeed())
+
+				info, err := os.Stat(filepath.Join(dir, "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(metadata.ContentLength).To(Equal(int64(len("some-file-contents"))))
+				Expect(metadata.LastModified).To(Equal(info.ModTime()))
+			})
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package postal
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// InTotoStatementType is the type identifier of the in-toto Statement
+	// produced by GenerateInTotoStatement.
+	InTotoStatementType = "https://in-toto.io/Statement/v1"
+
+	// InTotoPredicateType is the type identifier of the predicate included in
+	// the in-toto Statement produced by GenerateInTotoStatement.
+	InTotoPredicateType = "https://paketo.io/attestation/dependencies/v1"
+)
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     inTotoPredicate `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type inTotoPredicate struct {
+	Builder      inTotoBuilder      `json:"builder"`
+	Dependencies []inTotoDependency `json:"dependencies"`
+}
+
+type inTotoBuilder struct {
+	ID string `json:"id"`
+}
+
+type inTotoDependency struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name,omitempty"`
+	Version  string   `json:"version"`
+	Licenses []string `json:"licenses,omitempty"`
+}
+
+// GenerateInTotoStatement will generate an in-toto Statement
+// (https://github.com/in-toto/attestation/blob/main/spec/v1/statement.md)
+// describing the given collection of Dependency values. Each dependency is
+// listed as a subject identified by its ID and checksum, and the predicate
+// records the given builder ID along with the version and license information
+// for each dependency.
+func (s Service) GenerateInTotoStatement(builderID string, dependencies ...Dependency) ([]byte, error) {
+	statement := inTotoStatement{
+		Type:          InTotoStatementType,
+		Subject:       []inTotoSubject{},
+		PredicateType: InTotoPredicateType,
+		Predicate: inTotoPredicate{
+			Builder:      inTotoBuilder{ID: builderID},
+			Dependencies: []inTotoDependency{},
+		},
+	}
+
+	for _, dependency := range dependencies {
+		checksum := Checksum(dependency.SHA256)
+		if len(dependency.Checksum) > 0 {
+			checksum = Checksum(dependency.Checksum)
+		}
+
+		if checksum.Hash() == "" {
+			return nil, fmt.Errorf("failed to generate in-toto statement: dependency %q has no checksum", dependency.ID)
+		}
+
+		statement.Subject = append(statement.Subject, inTotoSubject{
+			Name:   dependency.ID,
+			Digest: map[string]string{checksum.Algorithm(): checksum.Hash()},
+		})
+
+		statement.Predicate.Dependencies = append(statement.Predicate.Dependencies, inTotoDependency{
+			ID:       dependency.ID,
+			Name:     dependency.Name,
+			Version:  dependency.Version,
+			Licenses: dependency.Licenses,
+		})
+	}
+
+	content, err := json.Marshal(statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate in-toto statement: %w", err)
+	}
+
+	return content, nil
+}
@@ -9,7 +9,18 @@ import (
 
 func TestUnitPostal(t *testing.T) {
 	suite := spec.New("packit/postal", spec.Report(report.Terminal{}))
+	suite("BindingMappingResolver", testBindingMappingResolver)
+	suite("ChecksumRegistry", testChecksumRegistry)
+	suite("Dependency", testDependency)
+	suite("DependencyRegistry", testDependencyRegistry)
+	suite("ETAReader", testETAReader)
+	suite("EnvPrefixMirrorResolver", testEnvPrefixMirrorResolver)
+	suite("HTTPTransport", testHTTPTransport)
+	suite("OCITransport", testOCITransport)
 	suite("Service", testService)
+	suite("TrailerChecksumReader", testTrailerChecksumReader)
+	suite("VersionMatrix", testVersionMatrix)
+	suite("WatchdogTransport", testWatchdogTransport)
 
 	suite.Run(t)
 }
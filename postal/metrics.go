@@ -0,0 +1,80 @@
+package postal
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+)
+
+// deliveryMetrics holds the instruments that Service records measurements of
+// its dependency deliveries on.
+type deliveryMetrics struct {
+	downloadBytes    instrument.Int64Histogram
+	downloadDuration instrument.Float64Histogram
+	deliveryErrors   instrument.Int64Counter
+}
+
+func newDeliveryMetrics(provider metric.MeterProvider) (deliveryMetrics, error) {
+	meter := provider.Meter("github.com/paketo-buildpacks/packit/v2/postal")
+
+	downloadBytes, err := meter.Int64Histogram("postal.download.bytes",
+		instrument.WithDescription("The number of bytes downloaded for a dependency"))
+	if err != nil {
+		return deliveryMetrics{}, err
+	}
+
+	downloadDuration, err := meter.Float64Histogram("postal.download.duration_ms",
+		instrument.WithDescription("The time taken to download and extract a dependency in milliseconds"))
+	if err != nil {
+		return deliveryMetrics{}, err
+	}
+
+	deliveryErrors, err := meter.Int64Counter("postal.delivery.errors",
+		instrument.WithDescription("The number of dependency deliveries that failed"))
+	if err != nil {
+		return deliveryMetrics{}, err
+	}
+
+	return deliveryMetrics{
+		downloadBytes:    downloadBytes,
+		downloadDuration: downloadDuration,
+		deliveryErrors:   deliveryErrors,
+	}, nil
+}
+
+// prometheusLabelEscaper escapes a label value for the Prometheus text
+// exposition format.
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// pushDeliveryMetrics pushes the number of bytes downloaded for the given
+// dependency and the time the delivery took to the Prometheus push gateway at
+// the given url, using the Prometheus text exposition format.
+func pushDeliveryMetrics(url string, dependency Dependency, downloadedBytes int64, duration time.Duration) error {
+	labels := fmt.Sprintf(`{id="%s",version="%s"}`,
+		prometheusLabelEscaper.Replace(dependency.ID),
+		prometheusLabelEscaper.Replace(dependency.Version),
+	)
+
+	buffer := bytes.NewBuffer(nil)
+	fmt.Fprintln(buffer, "# TYPE postal_delivery_bytes_total counter")
+	fmt.Fprintf(buffer, "postal_delivery_bytes_total%s %d\n", labels, downloadedBytes)
+	fmt.Fprintln(buffer, "# TYPE postal_delivery_duration_seconds gauge")
+	fmt.Fprintf(buffer, "postal_delivery_duration_seconds%s %g\n", labels, duration.Seconds())
+
+	response, err := http.Post(url, "text/plain; version=0.0.4", buffer)
+	if err != nil {
+		return fmt.Errorf("failed to push delivery metrics: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("failed to push delivery metrics: unexpected status %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package postal
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// EnvPrefixMirrorResolver is a MirrorResolver that substitutes the scheme and
+// host of a dependency uri with the url prefix given in the
+// $BP_DEPENDENCY_MIRROR environment variable.
+type EnvPrefixMirrorResolver struct{}
+
+// NewEnvPrefixMirrorResolver creates an instance of an
+// EnvPrefixMirrorResolver.
+func NewEnvPrefixMirrorResolver() EnvPrefixMirrorResolver {
+	return EnvPrefixMirrorResolver{}
+}
+
+// FindDependencyMirror reads $BP_DEPENDENCY_MIRROR and, if it is set, returns
+// the given uri with its scheme and host replaced by the value of that
+// variable. Any path on the mirror value is prepended to the path of the uri.
+// An empty string is returned when the variable is unset or when the uri does
+// not use the http(s):// scheme.
+func (r EnvPrefixMirrorResolver) FindDependencyMirror(uri string) (string, error) {
+	mirror, ok := os.LookupEnv("BP_DEPENDENCY_MIRROR")
+	if !ok || mirror == "" {
+		return "", nil
+	}
+
+	original, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse dependency uri: %w", err)
+	}
+
+	if original.Scheme != "http" && original.Scheme != "https" {
+		return "", nil
+	}
+
+	prefix, err := url.Parse(strings.TrimSuffix(mirror, "/"))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse BP_DEPENDENCY_MIRROR: %w", err)
+	}
+
+	if prefix.Scheme == "" || prefix.Host == "" {
+		return "", fmt.Errorf("failed to parse BP_DEPENDENCY_MIRROR: %q is not an absolute url", mirror)
+	}
+
+	original.Scheme = prefix.Scheme
+	original.Host = prefix.Host
+	original.User = prefix.User
+	original.Path = prefix.Path + original.Path
+	original.RawPath = ""
+
+	return original.String(), nil
+}
@@ -0,0 +1,243 @@
+package postal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// LayerTransport serves as the interface for Transports that can fetch only
+// some of the layers of a dependency stored as an OCI image. Service.Deliver
+// uses it in place of Drop for dependencies that declare OCILayerDigests.
+type LayerTransport interface {
+	Transport
+	DropLayers(root, uri string, digests []string) (io.ReadCloser, error)
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// OCITransport is a LayerTransport that fetches dependencies stored as
+// images in an OCI distribution registry. Dependencies are located with
+// uris of the form oci://<registry>/<repository>:<tag> or
+// oci://<registry>/<repository>@<digest>. The layers of the image are
+// combined into a single uncompressed tar stream, with the files of later
+// layers following those of earlier ones. Registries are accessed
+// anonymously.
+type OCITransport struct {
+	client *http.Client
+	scheme string
+}
+
+// NewOCITransport creates an instance of an OCITransport that connects to
+// registries over HTTPS.
+func NewOCITransport() OCITransport {
+	return OCITransport{
+		client: http.DefaultClient,
+		scheme: "https",
+	}
+}
+
+// WithPlainHTTP returns a copy of the OCITransport that connects to
+// registries over plain HTTP, such as a registry running on localhost.
+func (t OCITransport) WithPlainHTTP() OCITransport {
+	t.scheme = "http"
+	return t
+}
+
+// Drop fetches every layer of the image at the given uri.
+func (t OCITransport) Drop(root, uri string) (io.ReadCloser, error) {
+	return t.DropLayers(root, uri, nil)
+}
+
+// DropLayers fetches the layers of the image at the given uri that have the
+// given digests, in the order in which they appear in the image manifest.
+// Every layer is fetched when no digests are given. Each layer is validated
+// against its digest as it is read.
+func (t OCITransport) DropLayers(root, uri string, digests []string) (io.ReadCloser, error) {
+	registry, repository, reference, err := parseOCIReference(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := t.manifest(registry, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := manifest.Layers
+	if len(digests) > 0 {
+		found := map[string]ociDescriptor{}
+		for _, layer := range manifest.Layers {
+			found[layer.Digest] = layer
+		}
+
+		wanted := map[string]bool{}
+		for _, digest := range digests {
+			if _, ok := found[digest]; !ok {
+				return nil, fmt.Errorf("failed to find layer %s in the manifest of %q", digest, uri)
+			}
+
+			wanted[digest] = true
+		}
+
+		layers = nil
+		for _, layer := range manifest.Layers {
+			if wanted[layer.Digest] {
+				layers = append(layers, layer)
+			}
+		}
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pipeWriter)
+		for _, layer := range layers {
+			err := t.copyLayer(tw, registry, repository, layer)
+			if err != nil {
+				pipeWriter.CloseWithError(err)
+				return
+			}
+		}
+
+		pipeWriter.CloseWithError(tw.Close())
+	}()
+
+	return pipeReader, nil
+}
+
+func (t OCITransport) manifest(registry, repository, reference string) (ociManifest, error) {
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/v2/%s/manifests/%s", t.scheme, registry, repository, reference), nil)
+	if err != nil {
+		return ociManifest{}, fmt.Errorf("failed to parse request uri: %s", err)
+	}
+
+	request.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}, ", "))
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		return ociManifest{}, fmt.Errorf("failed to make request: %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return ociManifest{}, fmt.Errorf("unexpected status code %d while fetching manifest for %s/%s:%s", response.StatusCode, registry, repository, reference)
+	}
+
+	var manifest ociManifest
+	err = json.NewDecoder(response.Body).Decode(&manifest)
+	if err != nil {
+		return ociManifest{}, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// copyLayer writes each of the entries of the layer into the given tar
+// writer, validating the layer against its digest.
+func (t OCITransport) copyLayer(tw *tar.Writer, registry, repository string, layer ociDescriptor) error {
+	algorithm := strings.SplitN(layer.Digest, ":", 2)[0]
+	if algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm %q for layer %s", algorithm, layer.Digest)
+	}
+
+	response, err := t.client.Get(fmt.Sprintf("%s://%s/v2/%s/blobs/%s", t.scheme, registry, repository, layer.Digest))
+	if err != nil {
+		return fmt.Errorf("failed to make request: %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code %d while fetching layer %s", response.StatusCode, layer.Digest)
+	}
+
+	hash := sha256.New()
+	blob := io.TeeReader(response.Body, hash)
+
+	var reader io.Reader = blob
+	if strings.HasSuffix(layer.MediaType, "gzip") {
+		zr, err := gzip.NewReader(blob)
+		if err != nil {
+			return fmt.Errorf("failed to decompress layer %s: %w", layer.Digest, err)
+		}
+		defer zr.Close()
+
+		reader = zr
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read layer %s: %w", layer.Digest, err)
+		}
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tw, tr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Hash whatever follows the end of the tar archive so that the digest
+	// covers the entire blob
+	_, err = io.Copy(io.Discard, blob)
+	if err != nil {
+		return fmt.Errorf("failed to read layer %s: %w", layer.Digest, err)
+	}
+
+	if fmt.Sprintf("sha256:%s", hex.EncodeToString(hash.Sum(nil))) != layer.Digest {
+		return fmt.Errorf("failed to validate layer %s: digest does not match", layer.Digest)
+	}
+
+	return nil
+}
+
+// parseOCIReference splits a uri of the form
+// oci://<registry>/<repository>:<tag> or
+// oci://<registry>/<repository>@<digest> into its parts. The tag defaults to
+// "latest".
+func parseOCIReference(uri string) (string, string, string, error) {
+	if !strings.HasPrefix(uri, "oci://") {
+		return "", "", "", fmt.Errorf("failed to parse oci uri %q: missing oci:// scheme", uri)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(uri, "oci://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("failed to parse oci uri %q: missing repository", uri)
+	}
+
+	registry, repository := parts[0], parts[1]
+	reference := "latest"
+
+	if index := strings.Index(repository, "@"); index >= 0 {
+		repository, reference = repository[:index], repository[index+1:]
+	} else if index := strings.LastIndex(repository, ":"); index >= 0 {
+		repository, reference = repository[:index], repository[index+1:]
+	}
+
+	return registry, repository, reference, nil
+}
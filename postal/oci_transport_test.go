@@ -0,0 +1,217 @@
+package postal_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/postal/fakes"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testOCITransport(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		server    *httptest.Server
+		transport postal.OCITransport
+		uri       string
+
+		firstDigest  string
+		secondDigest string
+	)
+
+	layer := func(files map[string]string) []byte {
+		buffer := bytes.NewBuffer(nil)
+		zw := gzip.NewWriter(buffer)
+		tw := tar.NewWriter(zw)
+
+		for name, content := range files {
+			Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})).To(Succeed())
+			_, err := tw.Write([]byte(content))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(tw.Close()).To(Succeed())
+		Expect(zw.Close()).To(Succeed())
+
+		return buffer.Bytes()
+	}
+
+	digest := func(content []byte) string {
+		sum := sha256.Sum256(content)
+		return fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:]))
+	}
+
+	readFiles := func(reader io.Reader) map[string]string {
+		files := map[string]string{}
+
+		tr := tar.NewReader(reader)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+
+			content, err := io.ReadAll(tr)
+			Expect(err).NotTo(HaveOccurred())
+
+			files[header.Name] = string(content)
+		}
+
+		return files
+	}
+
+	it.Before(func() {
+		firstLayer := layer(map[string]string{"first-file": "first-content"})
+		secondLayer := layer(map[string]string{"second-file": "second-content"})
+
+		firstDigest = digest(firstLayer)
+		secondDigest = digest(secondLayer)
+
+		manifest, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+			"layers": []map[string]interface{}{
+				{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": firstDigest, "size": len(firstLayer)},
+				{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": secondDigest, "size": len(secondLayer)},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// An in-memory registry that serves a single two layer image
+		blobs := map[string][]byte{
+			"/v2/some-org/some-image/manifests/1.2.3":                     manifest,
+			fmt.Sprintf("/v2/some-org/some-image/blobs/%s", firstDigest):  firstLayer,
+			fmt.Sprintf("/v2/some-org/some-image/blobs/%s", secondDigest): secondLayer,
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			content, ok := blobs[req.URL.Path]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+
+			_, _ = w.Write(content)
+		}))
+
+		transport = postal.NewOCITransport().WithPlainHTTP()
+		uri = fmt.Sprintf("oci://%s/some-org/some-image:1.2.3", strings.TrimPrefix(server.URL, "http://"))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	context("Drop", func() {
+		it("combines every layer of the image into a single tar stream", func() {
+			bundle, err := transport.Drop("", uri)
+			Expect(err).NotTo(HaveOccurred())
+			defer bundle.Close()
+
+			Expect(readFiles(bundle)).To(Equal(map[string]string{
+				"first-file":  "first-content",
+				"second-file": "second-content",
+			}))
+		})
+	})
+
+	context("DropLayers", func() {
+		it("only fetches the layers with the given digests", func() {
+			bundle, err := transport.DropLayers("", uri, []string{secondDigest})
+			Expect(err).NotTo(HaveOccurred())
+			defer bundle.Close()
+
+			Expect(readFiles(bundle)).To(Equal(map[string]string{
+				"second-file": "second-content",
+			}))
+		})
+
+		context("failure cases", func() {
+			context("when the uri is not an oci uri", func() {
+				it("returns an error", func() {
+					_, err := transport.DropLayers("", "https://example.com/some-image", nil)
+					Expect(err).To(MatchError(`failed to parse oci uri "https://example.com/some-image": missing oci:// scheme`))
+				})
+			})
+
+			context("when the image does not exist", func() {
+				it("returns an error", func() {
+					_, err := transport.DropLayers("", strings.Replace(uri, "1.2.3", "4.5.6", 1), nil)
+					Expect(err).To(MatchError(ContainSubstring("unexpected status code 404 while fetching manifest")))
+				})
+			})
+
+			context("when a digest is not a layer of the image", func() {
+				it("returns an error", func() {
+					_, err := transport.DropLayers("", uri, []string{"sha256:some-missing-digest"})
+					Expect(err).To(MatchError(ContainSubstring("failed to find layer sha256:some-missing-digest")))
+				})
+			})
+		})
+	})
+
+	context("when used to deliver a dependency", func() {
+		var layerPath string
+
+		it.Before(func() {
+			var err error
+			layerPath, err = os.MkdirTemp("", "layer")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it.After(func() {
+			Expect(os.RemoveAll(layerPath)).To(Succeed())
+		})
+
+		it("only extracts the files of the selected layers", func() {
+			bundle, err := transport.DropLayers("", uri, []string{firstDigest})
+			Expect(err).NotTo(HaveOccurred())
+
+			content, err := io.ReadAll(bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bundle.Close()).To(Succeed())
+
+			service := postal.NewService(transport).
+				WithDependencyMappingResolver(&fakes.MappingResolver{}).
+				WithDependencyMirrorResolver(&fakes.MirrorResolver{})
+
+			err = service.Deliver(
+				postal.Dependency{
+					ID:              "some-image",
+					Stacks:          []string{"some-stack"},
+					URI:             uri,
+					Checksum:        digest(content),
+					Version:         "1.2.3",
+					OCILayerDigests: []string{firstDigest},
+				},
+				"some-cnb-path",
+				layerPath,
+				"some-platform-dir",
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			files, err := filepath.Glob(filepath.Join(layerPath, "*"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(ConsistOf([]string{
+				filepath.Join(layerPath, "first-file"),
+			}))
+		})
+	})
+}
@@ -0,0 +1,301 @@
+package postal
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+)
+
+// DeliverConfig is the set of configurable options for the Deliver function.
+type DeliverConfig struct {
+	chown bool
+	uid   int
+	gid   int
+
+	minFileCount int
+
+	checksumRetry bool
+
+	preserveTimestamps bool
+
+	xattrMapper func(header *tar.Header) map[string][]byte
+
+	createLayerDir bool
+
+	dryRun bool
+
+	filePermissions map[string]os.FileMode
+
+	deliveryManifest bool
+
+	deduplicate bool
+
+	renameRules []fileRenameRule
+
+	contentPatcher func(filePath string, r io.Reader) (io.Reader, error)
+
+	extractGlobs []string
+
+	maxSymlinkDepth int
+
+	verificationLevel string
+
+	extractWorkers int
+
+	selinuxContext string
+
+	preserveCapabilities bool
+
+	provenanceBuilderID string
+	provenanceWriter    io.Writer
+
+	prometheusPushURL string
+}
+
+type fileRenameRule struct {
+	from string
+	to   string
+}
+
+// DeliverOption declares a function signature that can be used to define
+// optional modifications to the behavior of the Deliver function.
+type DeliverOption func(config DeliverConfig) DeliverConfig
+
+// WithFileOwnership is a DeliverOption that sets the owning uid and gid of
+// every file and directory extracted during a given invocation of Deliver.
+// Symlinks are changed themselves rather than the files they point to.
+func WithFileOwnership(uid, gid int) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.chown = true
+		config.uid = uid
+		config.gid = gid
+		return config
+	}
+}
+
+// WithMinFileCount is a DeliverOption that causes Deliver to return an
+// ErrInsufficientFiles error when the delivery extracts fewer than n files into
+// the layer path. Directories, files that were already in the layer path, and
+// files written by an installer script are not included in the count.
+func WithMinFileCount(n int) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.minFileCount = n
+		return config
+	}
+}
+
+// WithChecksumRetry is a DeliverOption that causes Deliver to download and
+// verify the dependency a second time when the first download does not match
+// its checksum. The files and directories created by the first attempt are
+// removed from the layer path before retrying, while those that were in the
+// layer path before the delivery started are kept.
+func WithChecksumRetry() DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.checksumRetry = true
+		return config
+	}
+}
+
+// WithXAttrMapper is a DeliverOption that sets extended attributes on the
+// files and directories extracted during a given invocation of Deliver. The
+// mapper is called with a header describing each extracted entry, named
+// relative to the layer path, and returns the attributes to set on that
+// entry. Extended attributes are only supported on Linux.
+func WithXAttrMapper(mapper func(header *tar.Header) map[string][]byte) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.xattrMapper = mapper
+		return config
+	}
+}
+
+// WithPreserveTimestamps is a DeliverOption that applies the access and
+// modification times recorded in a tar archive to the directories and files
+// extracted from it, rather than leaving them with the time of extraction.
+func WithPreserveTimestamps() DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.preserveTimestamps = true
+		return config
+	}
+}
+
+// WithCreateLayerDir is a DeliverOption that causes Deliver to create the
+// layer path, along with any missing parent directories, with 0755
+// permissions before fetching the dependency into it.
+func WithCreateLayerDir() DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.createLayerDir = true
+		return config
+	}
+}
+
+// WithDryRun is a DeliverOption that causes Deliver to fetch the dependency,
+// validate its checksum, and verify that it can be extracted without writing
+// anything into the layer path.
+func WithDryRun() DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.dryRun = true
+		return config
+	}
+}
+
+// WithFilePermissionMap is a DeliverOption that overrides the permissions of
+// extracted files. The map is keyed by the path of each file relative to the
+// layer path, using forward slashes, and the mode is applied once extraction
+// has finished. Paths that were not extracted are ignored.
+func WithFilePermissionMap(m map[string]os.FileMode) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.filePermissions = m
+		return config
+	}
+}
+
+// WithDeliveryManifest is a DeliverOption that causes Deliver to write a
+// DeliveryManifest describing the delivered dependency to the
+// DeliveryManifestFile in the layer path.
+func WithDeliveryManifest() DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.deliveryManifest = true
+		return config
+	}
+}
+
+// WithDeduplication is a DeliverOption that causes Deliver to record the
+// checksum of the delivered dependency in the DeliveryChecksumFile of the
+// layer path. When that file already records the checksum of the dependency
+// and the layer path holds other files, Deliver returns early without
+// fetching the dependency again. Dependencies with an EphemeralURI are always
+// fetched again, with the files of the previous delivery removed first.
+func WithDeduplication() DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.deduplicate = true
+		return config
+	}
+}
+
+// WithFileRenameRule is a DeliverOption that renames extracted files whose
+// names match the from glob pattern, such as "ruby3.2" or "ruby*", to the
+// given name, keeping them in the same directory. Patterns are matched with
+// filepath.Match against the name of each file rather than its full path.
+// The option can be given more than once to apply several rules, which are
+// applied in order once extraction has finished.
+func WithFileRenameRule(from, to string) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.renameRules = append(config.renameRules, fileRenameRule{from: from, to: to})
+		return config
+	}
+}
+
+// WithContentPatch is a DeliverOption that allows the content of extracted
+// files to be modified, such as to add license headers to them. The patcher
+// is called once extraction has finished with the path of each regular file
+// relative to the layer path, using forward slashes, and a reader of its
+// content. When it returns a non-nil reader, the content of the file is
+// replaced with the content of that reader, keeping the permissions of the
+// file. Returning a nil reader leaves the file as it is.
+func WithContentPatch(patcher func(filePath string, r io.Reader) (io.Reader, error)) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.contentPatcher = patcher
+		return config
+	}
+}
+
+// WithExtractGlobs is a DeliverOption that causes Deliver to only extract the
+// entries of a tar archive, compressed or not, whose paths match one of the
+// given glob patterns, such as "bin/*". Patterns are matched with path.Match
+// against the path of each entry once any StripComponents have been removed.
+// The directories leading to matching entries are created as needed.
+func WithExtractGlobs(globs ...string) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.extractGlobs = globs
+		return config
+	}
+}
+
+// WithMaxSymlinkDepth is a DeliverOption that causes Deliver to return an
+// ErrSymlinkDepthExceeded error when following any of the extracted symlinks
+// takes more than n hops, such as a chain of links that eventually loops back
+// on itself. A symlink that points directly at a file has a depth of 1.
+func WithMaxSymlinkDepth(n int) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.maxSymlinkDepth = n
+		return config
+	}
+}
+
+// WithCheckVerification is a DeliverOption that sets the level of checksum
+// verification used for the dependency, overriding its VerificationLevel. The
+// level is one of "full", "fast" or "skip". The "fast" level requires the
+// dependency to declare a FastChecksum.
+func WithCheckVerification(level string) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.verificationLevel = level
+		return config
+	}
+}
+
+// WithParallelExtraction is a DeliverOption that causes the regular files of a
+// tar archive, compressed or not, to be written by the given number of
+// goroutines during a given invocation of Deliver. The content of each file is
+// buffered in memory as it is read from the archive, so up to the whole
+// archive may be held in memory at once. See vacation.TarArchive.WithWorkers.
+func WithParallelExtraction(workers int) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.extractWorkers = workers
+		return config
+	}
+}
+
+// WithSELinuxContext is a DeliverOption that sets the SELinux security context
+// of every file and directory extracted during a given invocation of Deliver,
+// such as "system_u:object_r:bin_t:s0", by setting their "security.selinux"
+// extended attribute. Setting this is a no-op on platforms other than Linux.
+func WithSELinuxContext(ctx string) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.selinuxContext = ctx
+		return config
+	}
+}
+
+// WithPreserveCapabilities is a DeliverOption that causes the file
+// capabilities recorded in a tar archive, compressed or not, such as
+// CAP_NET_BIND_SERVICE, to be set on the files extracted from it during a
+// given invocation of Deliver. This is only supported on Linux. Changing the
+// owner of the files with WithFileOwnership clears their capabilities. See
+// vacation.TarArchive.PreserveCapabilities.
+func WithPreserveCapabilities() DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.preserveCapabilities = true
+		return config
+	}
+}
+
+// WithSLSAProvenance is a DeliverOption that causes Deliver to write a SLSA
+// provenance attestation (https://slsa.dev/provenance/v0.2), as an in-toto
+// Statement encoded as JSON, to the given writer once the dependency has been
+// delivered. The provenance records the given builder ID, and lists the uri
+// that the dependency was downloaded from along with its checksum as the
+// materials of the build.
+func WithSLSAProvenance(builderID string, w io.Writer) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.provenanceBuilderID = builderID
+		config.provenanceWriter = w
+		return config
+	}
+}
+
+// WithPrometheusPushURL is a DeliverOption that causes Deliver to push metrics
+// about the delivery to the Prometheus push gateway at the given url once the
+// dependency has been delivered. The url should include the job that the
+// metrics are grouped under, such as
+// "http://pushgateway:9091/metrics/job/some-job". The
+// postal_delivery_bytes_total and postal_delivery_duration_seconds metrics are
+// pushed, labeled with the id and version of the dependency. The bytes are
+// those read from the Transport, as recorded by the postal.download.bytes
+// histogram. A failure to push the metrics is logged as a warning rather than
+// failing the delivery.
+func WithPrometheusPushURL(url string) DeliverOption {
+	return func(config DeliverConfig) DeliverConfig {
+		config.prometheusPushURL = url
+		return config
+	}
+}
@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package postal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// setxattr sets an extended attribute on a file, following symlinks. It is a
+// variable so that it can be replaced in tests.
+var setxattr = unix.Setxattr
+
+// setSELinuxContext sets the SELinux security context of every file and
+// directory within the layer path. Symlinks are skipped as setting the
+// attribute on them sets it on the file they point to instead.
+func setSELinuxContext(layerPath, selinuxContext string) error {
+	return filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == layerPath || info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		err = setxattr(path, "security.selinux", []byte(selinuxContext), 0)
+		if err != nil {
+			return fmt.Errorf("failed to set SELinux context on %s: %w", path, err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+//go:build linux
+// +build linux
+
+package postal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestUnitPostalSELinux(t *testing.T) {
+	spec.Run(t, "packit/postal/selinux", testSELinux, spec.Report(report.Terminal{}))
+}
+
+func testSELinux(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		layerPath string
+		calls     map[string]string
+	)
+
+	it.Before(func() {
+		var err error
+		layerPath, err = os.MkdirTemp("", "layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.MkdirAll(filepath.Join(layerPath, "bin"), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(layerPath, "bin", "some-tool"), []byte("some-tool"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(layerPath, "some-file"), []byte("some-file"), 0644)).To(Succeed())
+		Expect(os.Symlink("some-file", filepath.Join(layerPath, "some-link"))).To(Succeed())
+
+		calls = map[string]string{}
+		setxattr = func(path, attr string, data []byte, flags int) error {
+			Expect(attr).To(Equal("security.selinux"))
+			Expect(flags).To(Equal(0))
+
+			calls[path] = string(data)
+			return nil
+		}
+	})
+
+	it.After(func() {
+		setxattr = unix.Setxattr
+		Expect(os.RemoveAll(layerPath)).To(Succeed())
+	})
+
+	it("sets the context on every file and directory but not on symlinks", func() {
+		err := setSELinuxContext(layerPath, "system_u:object_r:bin_t:s0")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(calls).To(Equal(map[string]string{
+			filepath.Join(layerPath, "bin"):              "system_u:object_r:bin_t:s0",
+			filepath.Join(layerPath, "bin", "some-tool"): "system_u:object_r:bin_t:s0",
+			filepath.Join(layerPath, "some-file"):        "system_u:object_r:bin_t:s0",
+		}))
+	})
+
+	context("failure cases", func() {
+		context("when the context cannot be set", func() {
+			it.Before(func() {
+				setxattr = func(path, attr string, data []byte, flags int) error {
+					return errors.New("some-error")
+				}
+			})
+
+			it("returns an error", func() {
+				err := setSELinuxContext(layerPath, "system_u:object_r:bin_t:s0")
+				Expect(err).To(MatchError(ContainSubstring("failed to set SELinux context on")))
+				Expect(err).To(MatchError(ContainSubstring("some-error")))
+			})
+		})
+	})
+}
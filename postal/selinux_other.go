@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package postal
+
+// setSELinuxContext does nothing as SELinux is only supported on Linux.
+func setSELinuxContext(layerPath, selinuxContext string) error {
+	return nil
+}
@@ -1,13 +1,28 @@
 package postal
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
@@ -16,6 +31,11 @@ import (
 	"github.com/paketo-buildpacks/packit/v2/postal/internal"
 	"github.com/paketo-buildpacks/packit/v2/servicebindings"
 	"github.com/paketo-buildpacks/packit/v2/vacation"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 
 	//nolint Ignore SA1019, usage of deprecated package within a deprecated test case
 	"github.com/paketo-buildpacks/packit/v2/paketosbom"
@@ -29,6 +49,34 @@ type Transport interface {
 	Drop(root, uri string) (io.ReadCloser, error)
 }
 
+// TransportMetadata holds the out-of-band details that a Transport learns
+// about a dependency while fetching it. Fields that the Transport could not
+// determine are left as their zero value.
+type TransportMetadata struct {
+	// ETag is the entity tag that identifies the version of the content.
+	ETag string
+
+	// LastModified is the time at which the content was last modified.
+	LastModified time.Time
+
+	// ContentType is the media type of the content.
+	ContentType string
+
+	// ContentLength is the number of bytes in the content, or 0 when it is
+	// not known.
+	ContentLength int64
+}
+
+// MetadataTransport serves as the interface for Transports that can report
+// TransportMetadata alongside the content of a dependency. Service.Deliver
+// uses it in place of Drop when the Transport implements it.
+//
+//go:generate faux --interface MetadataTransport --output fakes/metadata_transport.go
+type MetadataTransport interface {
+	Transport
+	DropWithMetadata(root, uri string) (io.ReadCloser, TransportMetadata, error)
+}
+
 // MappingResolver serves as the interface that looks up platform binding provided
 // dependency mappings given a SHA256
 //
@@ -37,6 +85,32 @@ type MappingResolver interface {
 	FindDependencyMapping(checksum, platformDir string) (string, error)
 }
 
+// MirrorResolver serves as the interface that looks up a mirror location for
+// a dependency given its original uri
+//
+//go:generate faux --interface MirrorResolver --output fakes/mirror_resolver.go
+type MirrorResolver interface {
+	FindDependencyMirror(uri string) (string, error)
+}
+
+// DependencyAnnotator serves as the interface for types that add information,
+// such as the team that owns a dependency, to each dependency picked by
+// Service.Resolve.
+//
+//go:generate faux --interface DependencyAnnotator --output fakes/dependency_annotator.go
+type DependencyAnnotator interface {
+	Annotate(dependency *Dependency)
+}
+
+// RekorSubmitter serves as the interface for types that can sign an SBOM
+// document, such as a CycloneDX, SPDX, or Syft JSON document, and submit it to
+// a Rekor transparency log, returning the URL of the resulting log entry.
+//
+//go:generate faux --interface RekorSubmitter --output fakes/rekor_submitter.go
+type RekorSubmitter interface {
+	Submit(bomJSON []byte) (rekorURL string, err error)
+}
+
 // ErrNoDeps is a typed error indicating that no dependencies were resolved during Service.Resolve()
 //
 // errors can be tested against this type with: errors.As()
@@ -57,20 +131,246 @@ func (e *ErrNoDeps) Error() string {
 	)
 }
 
+// Suggestions returns up to n of the supported versions that are closest to
+// the version constraint that could not be satisfied, closest first. Versions
+// are compared by the Levenshtein distance between their dot-separated
+// segments, with ties keeping the order in which the versions were found.
+func (e *ErrNoDeps) Suggestions(n int) []string {
+	constraint := versionSegments(e.version)
+
+	var versions []string
+	seen := map[string]bool{}
+	for _, version := range e.supportedVersions {
+		if !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+
+	distances := map[string]int{}
+	for _, version := range versions {
+		distances[version] = levenshtein(constraint, versionSegments(version))
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return distances[versions[i]] < distances[versions[j]]
+	})
+
+	if n < len(versions) {
+		versions = versions[:n]
+	}
+
+	return versions
+}
+
+// versionSegments splits a version, or a version constraint, into its
+// dot-separated segments ignoring any leading constraint operators.
+func versionSegments(version string) []string {
+	return strings.Split(strings.TrimLeft(version, "~^<>=! "), ".")
+}
+
+// levenshtein returns the minimum number of segment insertions, deletions,
+// or substitutions needed to turn a into b.
+func levenshtein(a, b []string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+// ErrInsufficientFiles is a typed error indicating that Service.Deliver()
+// extracted fewer files than were required by the WithMinFileCount option.
+//
+// errors can be tested against this type with: errors.As()
+type ErrInsufficientFiles struct {
+	Expected int
+	Actual   int
+}
+
+// Error implements the error.Error interface
+func (e *ErrInsufficientFiles) Error() string {
+	return fmt.Sprintf("failed to validate dependency: extracted %d files, expected at least %d", e.Actual, e.Expected)
+}
+
+// ErrInsufficientDependencies is a typed error indicating that
+// Service.AssertMinDependencies found fewer dependencies than were required.
+//
+// errors can be tested against this type with: errors.As()
+type ErrInsufficientDependencies struct {
+	ID       string
+	Stack    string
+	Expected int
+	Actual   int
+}
+
+// Error implements the error.Error interface
+func (e *ErrInsufficientDependencies) Error() string {
+	return fmt.Sprintf("found %d dependencies with id %q for stack %q, expected at least %d", e.Actual, e.ID, e.Stack, e.Expected)
+}
+
+// ErrMissingRequiredFile is a typed error indicating that Service.Deliver()
+// did not extract one or more of the RequiredFiles of a dependency.
+//
+// errors can be tested against this type with: errors.As()
+type ErrMissingRequiredFile struct {
+	Paths []string
+}
+
+// Error implements the error.Error interface
+func (e *ErrMissingRequiredFile) Error() string {
+	return fmt.Sprintf("failed to validate dependency: missing required files [%s]", strings.Join(e.Paths, ", "))
+}
+
+// DeliveryChecksumFile is the name of the hidden file that Deliver writes into
+// the layer path, when given the WithDeduplication option, to record the
+// checksum of the dependency it delivered. A later call to Deliver with that
+// option for a dependency with the same checksum returns early without
+// fetching the dependency again.
+const DeliveryChecksumFile = ".postal-delivery-checksum"
+
+// DeliveryManifestFile is the name of the file that Deliver writes into the
+// layer path when given the WithDeliveryManifest option.
+const DeliveryManifestFile = ".postal-manifest.json"
+
+// DeliveryManifest is the record of a delivered dependency that Deliver
+// writes, encoded as JSON, when given the WithDeliveryManifest option.
+type DeliveryManifest struct {
+	Dependency
+
+	// DeliveredAt is the time at which the delivery finished.
+	DeliveredAt time.Time `json:"deliveredAt"`
+
+	// DownloadedFrom is the uri that the dependency was fetched from, taking
+	// dependency mappings, mirrors, and extra uris into account.
+	DownloadedFrom string `json:"downloadedFrom"`
+
+	// ValidatedChecksum is the checksum that the dependency was validated
+	// against: the SHA256 of the dependency in the sha256:<hash> form when it
+	// declares one, and its Checksum otherwise.
+	ValidatedChecksum string `json:"validatedChecksum"`
+}
+
+// ErrChecksumMismatch is returned by Service.Deliver when the fetched
+// dependency does not match the checksum declared on the Dependency.
+var ErrChecksumMismatch = errors.New("failed to validate dependency: checksum does not match")
+
+// ErrUnexpectedContentType is returned by Service.Deliver when content type
+// verification is enabled and the content type detected from the start of
+// the fetched dependency is not one of the allowed content types.
+var ErrUnexpectedContentType = errors.New("failed to verify dependency: unexpected content type")
+
+// ErrSymlinkDepthExceeded is returned by Service.Deliver when the
+// WithMaxSymlinkDepth option is given and resolving one of the extracted
+// symlinks takes more hops than it allows.
+var ErrSymlinkDepthExceeded = errors.New("failed to validate dependency: symlink depth exceeded")
+
+// ErrCapabilityMissing is returned by Service.Deliver when a capability
+// checker is configured and reports that one or more of the
+// RequiredCapabilities of the dependency are unavailable.
+var ErrCapabilityMissing = errors.New("failed to deliver dependency: missing required capabilities")
+
+// ErrIncompatibleSchema is returned by Service.Resolve when a required schema
+// version is configured and the schema-version declared in the metadata of
+// the buildpack.toml does not match it.
+var ErrIncompatibleSchema = errors.New("failed to resolve dependency: incompatible buildpack.toml schema version")
+
+// LayerType is the kind of layer that dependencies are resolved for, which
+// decides the stacks a dependency must be built for. See Service.WithLayerType.
+type LayerType string
+
+const (
+	// LayerTypeBuild resolves dependencies against their BuildStacks.
+	LayerTypeBuild LayerType = "build"
+
+	// LayerTypeRun resolves dependencies against their RunStacks.
+	LayerTypeRun LayerType = "run"
+
+	// LayerTypeBoth resolves dependencies against both their BuildStacks and
+	// their RunStacks.
+	LayerTypeBoth LayerType = "both"
+)
+
 // Service provides a mechanism for resolving and installing dependencies given
 // a Transport.
 type Service struct {
 	transport       Transport
 	mappingResolver MappingResolver
+	mirrorResolver  MirrorResolver
+	rekorSubmitter  RekorSubmitter
+	annotator       DependencyAnnotator
+	concurrency     int
+	tracer          trace.Tracer
+	slogLogger      *slog.Logger
+	warningWriter   io.Writer
+	metrics         deliveryMetrics
+	contextFilter   string
+	osFilter        string
+	layerType       LayerType
+	runtimeVersion  string
+	buildpackCache  *buildpackCache
+	resolveCache    *modTimeCache
+	tomlReloader    *tomlReloader
+	preResolveHook  func(tomlContent []byte) ([]byte, error)
+	versionScorer   func(dependency Dependency) float64
+	capabilityCheck func(capability string) bool
+	fallbackTOML    string
+	schemaVersion   string
+
+	allowedContentTypes []string
+
+	environmentConstraintPrefix string
+
+	autoGeneratePURL  bool
+	strictStackMatch  bool
+	multiCPESupport   bool
+	ignoreConstraints bool
+	normalizeVersions bool
+
+	useResolveCache bool
+
+	lastDeliveredSize *int64
 }
 
 // NewService creates an instance of a Service given a Transport.
 func NewService(transport Transport) Service {
+	// The noop meter provider never fails to create instruments
+	metrics, _ := newDeliveryMetrics(metric.NewNoopMeterProvider())
+
 	return Service{
 		transport: transport,
 		mappingResolver: internal.NewDependencyMappingResolver(
 			servicebindings.NewResolver(),
 		),
+		concurrency: runtime.NumCPU(),
+		tracer:      trace.NewNoopTracerProvider().Tracer(""),
+		metrics:     metrics,
+
+		resolveCache:      &modTimeCache{},
+		lastDeliveredSize: new(int64),
 	}
 }
 
@@ -79,200 +379,2178 @@ func (s Service) WithDependencyMappingResolver(mappingResolver MappingResolver)
 	return s
 }
 
+// WithDependencyMirrorResolver sets the MirrorResolver used by Deliver to
+// find a mirror location for dependencies that have no dependency mapping.
+// Mirrors are not looked up by default. To use the mirror configured with
+// $BP_DEPENDENCY_MIRROR, pass NewEnvPrefixMirrorResolver().
+func (s Service) WithDependencyMirrorResolver(mirrorResolver MirrorResolver) Service {
+	s.mirrorResolver = mirrorResolver
+	return s
+}
+
+// WithDependencyAnnotator sets the DependencyAnnotator that Resolve passes
+// the dependency it picks to before returning it.
+func (s Service) WithDependencyAnnotator(annotator DependencyAnnotator) Service {
+	s.annotator = annotator
+	return s
+}
+
+// WithRekorSubmitter sets the RekorSubmitter used by AttestBillOfMaterials to
+// record the SBOM generated for a layer in a Rekor transparency log.
+func (s Service) WithRekorSubmitter(submitter RekorSubmitter) Service {
+	s.rekorSubmitter = submitter
+	return s
+}
+
+// WithConcurrency sets the maximum number of dependencies that DeliverAll
+// will deliver at the same time.
+func (s Service) WithConcurrency(concurrency int) Service {
+	s.concurrency = concurrency
+	return s
+}
+
+// WithTracer sets the tracer used to create a span for each call to Resolve
+// and Deliver. The spans carry the dependency.id, dependency.version,
+// dependency.stack, and dependency.uri attributes of the dependency involved.
+func (s Service) WithTracer(tracer trace.Tracer) Service {
+	s.tracer = tracer
+	return s
+}
+
+// WithSlogLogger sets the logger that Deliver writes structured events about
+// the progress of downloads to: dependency.download.start,
+// dependency.download.complete, dependency.download.retry,
+// dependency.download.failed, and dependency.delivery.skipped. It defaults to
+// slog.Default().
+func (s Service) WithSlogLogger(logger *slog.Logger) Service {
+	s.slogLogger = logger
+	return s
+}
+
+func (s Service) logger() *slog.Logger {
+	if s.slogLogger == nil {
+		return slog.Default()
+	}
+
+	return s.slogLogger
+}
+
+// WithWarningWriter sets a writer that Resolve writes a warning to when the
+// dependency it picks has a DeprecationDate that has passed.
+func (s Service) WithWarningWriter(w io.Writer) Service {
+	s.warningWriter = w
+	return s
+}
+
+// WithMeterProvider sets the MeterProvider used to record metrics about
+// Deliver: the postal.download.bytes and postal.download.duration_ms
+// histograms, and the postal.delivery.errors counter.
+func (s Service) WithMeterProvider(provider metric.MeterProvider) Service {
+	metrics, err := newDeliveryMetrics(provider)
+	if err != nil {
+		otel.Handle(err)
+		return s
+	}
+
+	s.metrics = metrics
+	return s
+}
+
+// WithContextFilter sets the lifecycle context, either "build" or "run", that
+// dependencies must be usable in to be picked by Resolve. Dependencies with a
+// Context of "both", or with no Context, are usable in every context.
+func (s Service) WithContextFilter(context string) Service {
+	s.contextFilter = context
+	return s
+}
+
+// WithEnvironmentConstraintPrefix causes Resolve to look up an environment
+// variable named <prefix><ID>_CONSTRAINT, with the dependency ID given to
+// Resolve in upper case, and when it is set use its value in place of the
+// version constraint that was passed in. For example, with a prefix of
+// "BUILD_MATRIX_", $BUILD_MATRIX_NODE_CONSTRAINT overrides the constraint used
+// to resolve the "node" dependency.
+func (s Service) WithEnvironmentConstraintPrefix(prefix string) Service {
+	s.environmentConstraintPrefix = prefix
+	return s
+}
+
+// WithFallbackTOML sets the path of a buildpack.toml file that Resolve reads
+// dependencies from when the file at the path it is given does not exist. The
+// fallback is not used when that file exists but cannot be parsed.
+func (s Service) WithFallbackTOML(path string) Service {
+	s.fallbackTOML = path
+	return s
+}
+
+// WithVersionScorer overrides how Resolve picks between the dependencies that
+// satisfy the version constraint. Rather than picking the highest version, the
+// dependency given the highest score by the scorer is picked, with ties going
+// to the higher version.
+func (s Service) WithVersionScorer(scorer func(dependency Dependency) float64) Service {
+	s.versionScorer = scorer
+	return s
+}
+
+// WithPreResolveHook sets a hook that Resolve applies to the raw content of
+// the buildpack.toml file before parsing it, for example to substitute
+// placeholder tokens. The hook returns the content to be parsed in place of
+// the original. When the TOML cache is enabled, the content is cached after
+// the hook has been applied.
+func (s Service) WithPreResolveHook(hook func(tomlContent []byte) ([]byte, error)) Service {
+	s.preResolveHook = hook
+	return s
+}
+
+// WithTOMLCache enables caching of the buildpack.toml files parsed by Resolve
+// so that repeated calls for the same unchanged file do not read it from disk
+// again. At most maxEntries files are kept, discarding the least recently
+// used ones first. A maxEntries of zero or less disables the cache.
+func (s Service) WithTOMLCache(maxEntries int) Service {
+	s.buildpackCache = nil
+	if maxEntries > 0 {
+		s.buildpackCache = newBuildpackCache(maxEntries)
+	}
+
+	return s
+}
+
+// WithTOMLReloader causes Resolve to re-read the buildpack.toml files it is
+// given in the background at the given interval, so that a long running build
+// sees changes made to those files by earlier steps. Resolve uses the most
+// recently loaded contents of a file, which are kept when a reload fails to
+// parse it. The background reloading stops once the given context is done,
+// after which Resolve reads the files it is given on every call again. An
+// interval of zero or less disables reloading.
+func (s Service) WithTOMLReloader(ctx context.Context, interval time.Duration) Service {
+	s.tomlReloader = nil
+	if interval > 0 {
+		s.tomlReloader = newTOMLReloader(ctx, interval)
+	}
+
+	return s
+}
+
+// WithContentTypeVerification causes Deliver to detect the content type of
+// each dependency from its first 512 bytes, using http.DetectContentType,
+// before extracting it. Dependencies whose content type is not one of the
+// allowed content types, such as an HTML error page served in place of an
+// archive, fail with ErrUnexpectedContentType. Content types are compared
+// without their parameters, so "text/plain" allows "text/plain; charset=utf-8".
+func (s Service) WithContentTypeVerification(allowed []string) Service {
+	s.allowedContentTypes = allowed
+	return s
+}
+
+// WithLayerType sets the type of layer that Resolve picks dependencies for.
+// Dependencies must list the requested stack in their BuildStacks for
+// LayerTypeBuild, in their RunStacks for LayerTypeRun, and in both for
+// LayerTypeBoth. A dependency that does not declare BuildStacks or RunStacks
+// is matched against its Stacks in their place.
+func (s Service) WithLayerType(layerType LayerType) Service {
+	s.layerType = layerType
+	return s
+}
+
+// WithRuntimeVersionCheck sets the version of the runtime operating system,
+// such as "20.04", that dependencies must be compatible with to be picked by
+// Resolve. Dependencies are skipped when the runtime version is lower than
+// their MinRuntimeVersion or higher than their MaxRuntimeVersion. Versions
+// are compared as SemVer, with missing minor and patch segments treated as
+// zero.
+func (s Service) WithRuntimeVersionCheck(runtimeVersion string) Service {
+	s.runtimeVersion = runtimeVersion
+	return s
+}
+
+// WithCapabilityChecker sets a function that reports whether a Linux
+// capability is available at runtime. Deliver calls it for each of the
+// RequiredCapabilities of a dependency and returns an ErrCapabilityMissing
+// when any of them are unavailable. Capabilities are not checked by default.
+func (s Service) WithCapabilityChecker(checker func(capability string) bool) Service {
+	s.capabilityCheck = checker
+	return s
+}
+
+// WithRequiredSchemaVersion sets the schema version that the buildpack.toml
+// given to Resolve must declare with the schema-version key of its metadata.
+// A buildpack.toml that does not declare a schema version is treated as
+// version "1". Resolve returns an ErrIncompatibleSchema when the versions do
+// not match.
+func (s Service) WithRequiredSchemaVersion(version string) Service {
+	s.schemaVersion = version
+	return s
+}
+
+// WithOSFilter sets the operating system that dependencies must be built for
+// to be picked by Resolve. Dependencies with no OS are usable on every
+// operating system.
+func (s Service) WithOSFilter(os string) Service {
+	s.osFilter = os
+	return s
+}
+
+// LastDeliveredSize returns the DownloadSize of the dependency most recently
+// delivered by the Service, or 0 when the Transport did not report a size. The
+// size is shared by every copy of the Service made from the same NewService
+// call, including those returned by its With methods, so when dependencies
+// are delivered concurrently it reports whichever delivery finished last.
+func (s Service) LastDeliveredSize() int64 {
+	if s.lastDeliveredSize == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(s.lastDeliveredSize)
+}
+
+// WithStrictStackMatch causes Resolve to only pick dependencies that
+// explicitly list the requested stack, skipping those that are only
+// available through the wildcard "*" stack.
+func (s Service) WithStrictStackMatch() Service {
+	s.strictStackMatch = true
+	return s
+}
+
+// WithIgnoreConstraints causes Resolve to treat any non-empty version
+// constraint as "*", always picking the highest version of the dependency
+// available for the stack. This is useful for workflows that look for newer
+// versions of pinned dependencies.
+func (s Service) WithIgnoreConstraints() Service {
+	s.ignoreConstraints = true
+	return s
+}
+
+// WithMultiCPESupport causes GenerateBillOfMaterials to record every entry
+// of a dependency's CPEs in the BOM metadata, alongside the legacy single CPE.
+func (s Service) WithMultiCPESupport() Service {
+	s.multiCPESupport = true
+	return s
+}
+
+// WithNormalizedVersions causes GenerateBillOfMaterials to strip any build
+// metadata, such as the "+build.1" of "1.2.3+build.1", from the versions of
+// the dependencies it records, for SBOM consumers that cannot handle it.
+func (s Service) WithNormalizedVersions() Service {
+	s.normalizeVersions = true
+	return s
+}
+
+// WithAutoGeneratePURL returns a Service that fills in a PURL of the form
+// pkg:generic/<id>@<version> in GenerateBillOfMaterials for dependencies that
+// do not declare one.
+func (s Service) WithAutoGeneratePURL() Service {
+	s.autoGeneratePURL = true
+	return s
+}
+
 // Resolve will pick the best matching dependency given a path to a
 // buildpack.toml file, and the id, version, and stack value of a dependency.
 // The version value is treated as a SemVer constraint and will pick the
 // version that matches that constraint best. If the version is given as
 // "default", the default version for the dependency with the given id will be
 // used. If there is no default version for that dependency, a wildcard
-// constraint will be used.
+// constraint will be used. If the id is declared as an alias in the
+// dependency-aliases section of the buildpack.toml, the dependency for the
+// aliased target id will be resolved instead.
 func (s Service) Resolve(path, id, version, stack string) (Dependency, error) {
-	dependencies, defaultVersion, err := parseBuildpack(path, id)
+	_, span := s.tracer.Start(context.Background(), "postal.Resolve", trace.WithAttributes(
+		attribute.String("dependency.id", id),
+		attribute.String("dependency.stack", stack),
+	))
+	defer span.End()
+
+	dependency, err := s.resolve(path, id, version, stack)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return Dependency{}, err
+	}
+
+	if s.annotator != nil {
+		// The dependency is cloned so that the annotator cannot modify the
+		// contents of a cached buildpack.toml
+		dependency = dependency.Clone()
+		s.annotator.Annotate(&dependency)
+	}
+
+	span.SetAttributes(
+		attribute.String("dependency.version", dependency.Version),
+		attribute.String("dependency.uri", dependency.URI),
+	)
+
+	deprecationDate := dependency.DeprecationDate
+	if s.warningWriter != nil && !deprecationDate.IsZero() && !time.Now().Before(deprecationDate) {
+		fmt.Fprintf(s.warningWriter, "[WARNING] dependency %s %s is deprecated as of %s\n", dependency.ID, dependency.Version, deprecationDate.Format("2006-01-02"))
+	}
+
+	return dependency, nil
+}
+
+// ResolveWithCache behaves like Resolve, except that the buildpack.toml at
+// the given path is only parsed again when its modification time has changed
+// since the last call to ResolveWithCache for that path. The parsed files are
+// shared by every copy of the Service.
+func (s Service) ResolveWithCache(path, id, version, stack string) (Dependency, error) {
+	s.useResolveCache = s.resolveCache != nil
+	return s.Resolve(path, id, version, stack)
+}
+
+// ResolveMerged resolves the dependency in the same way as Resolve, but
+// returns every entry that has the version of the best matching dependency
+// rather than just one of them, in the order in which they are listed in the
+// buildpack.toml. This allows a layer to be composed from several entries that
+// share an id and version, such as a runtime and its headers, by delivering
+// each of them into the same layer path.
+func (s Service) ResolveMerged(path, id, version, stack string) ([]Dependency, error) {
+	compatibleVersions, _, err := s.compatibleVersions(path, id, version, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	best := semver.MustParse(s.bestVersion(compatibleVersions).Version)
+
+	var dependencies []Dependency
+	for _, dependency := range compatibleVersions {
+		if !semver.MustParse(dependency.Version).Equal(best) {
+			continue
+		}
+
+		if s.annotator != nil {
+			dependency = dependency.Clone()
+			s.annotator.Annotate(&dependency)
+		}
+
+		dependencies = append(dependencies, dependency)
+	}
+
+	return dependencies, nil
+}
+
+// ScoredDependency is a candidate dependency returned by
+// Service.ResolveWithScores, along with the score that it was ranked by.
+type ScoredDependency struct {
+	Dependency Dependency
+
+	// Score reflects how well the dependency matched the version constraint.
+	// It is made up of a base for the kind of match, which is highest for an
+	// exact version, then a wildcard, then a range, plus one point for each
+	// candidate that ranks below the dependency.
+	Score int
+}
+
+// ResolveWithScores resolves the dependency in the same way as Resolve, but
+// returns every candidate that satisfies the constraint rather than just the
+// best one, ordered from the highest score to the lowest. The first candidate
+// is the dependency that Resolve returns, and the difference between the
+// scores of two candidates is how far apart they were ranked.
+func (s Service) ResolveWithScores(path, id, version, stack string) ([]ScoredDependency, error) {
+	compatibleVersions, constraint, err := s.compatibleVersions(path, id, version, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := s.rankVersions(compatibleVersions)
+
+	var dependencies []ScoredDependency
+	for i, dependency := range ranked {
+		if s.annotator != nil {
+			dependency = dependency.Clone()
+			s.annotator.Annotate(&dependency)
+		}
+
+		// The base of each kind of match is larger than any number of points
+		// for ranking, so that a better kind of match always scores higher
+		base := constraintMatch(constraint, dependency.Version) * (len(ranked) + 1)
+
+		dependencies = append(dependencies, ScoredDependency{
+			Dependency: dependency,
+			Score:      base + len(ranked) - i,
+		})
+	}
+
+	return dependencies, nil
+}
+
+// constraintMatch returns the kind of match between a version constraint and
+// a version that satisfies it: 3 for an exact version, 2 for a wildcard, such
+// as "*" or "1.2.*", and 1 for any other range.
+func constraintMatch(constraint, version string) int {
+	exact, err := semver.StrictNewVersion(strings.TrimPrefix(strings.TrimSpace(constraint), "="))
+	if err == nil && exact.Equal(semver.MustParse(version)) {
+		return 3
+	}
+
+	if strings.ContainsAny(constraint, "*xX") && !strings.ContainsAny(constraint, "<>~^!=,| ") {
+		return 2
+	}
+
+	return 1
+}
+
+// AssertMinDependencies returns an ErrInsufficientDependencies error when
+// fewer than minCount dependencies with the given id are available for the
+// given stack in the buildpack.toml at the given path. Every version of the
+// dependency that passes the filters configured on the Service is counted.
+func (s Service) AssertMinDependencies(path, id, stack string, minCount int) error {
+	compatibleVersions, _, err := s.compatibleVersions(path, id, "*", stack)
+
+	// Finding no dependencies at all is counted rather than returned
+	var noDepsErr *ErrNoDeps
+	if err != nil && !errors.As(err, &noDepsErr) {
+		return err
+	}
+
+	if len(compatibleVersions) < minCount {
+		return &ErrInsufficientDependencies{ID: id, Stack: stack, Expected: minCount, Actual: len(compatibleVersions)}
+	}
+
+	return nil
+}
+
+// ResolveForBuildPlanEntry resolves the dependency named by the given build
+// plan entry using Resolve. The "version" field of the entry metadata, when
+// it is a string, is used as the version constraint. Otherwise, the latest
+// version of the dependency is resolved.
+func (s Service) ResolveForBuildPlanEntry(path string, entry packit.BuildpackPlanEntry, stack string) (Dependency, error) {
+	version, ok := entry.Metadata["version"].(string)
+	if !ok || version == "" {
+		version = "*"
+	}
+
+	return s.Resolve(path, entry.Name, version, stack)
+}
+
+func (s Service) resolve(path, id, version, stack string) (Dependency, error) {
+	compatibleVersions, _, err := s.compatibleVersions(path, id, version, stack)
 	if err != nil {
 		return Dependency{}, err
 	}
 
-	if version == "" {
-		version = "default"
-	}
+	return s.bestVersion(compatibleVersions), nil
+}
+
+// compatibleVersions returns every dependency in the buildpack.toml at the
+// given path that satisfies the id, version constraint, and stack, along with
+// the filters configured on the Service, in the order in which they are
+// listed. The version constraint that was checked, once any defaults and
+// overrides have been applied, is also returned.
+func (s Service) compatibleVersions(path, id, version, stack string) ([]Dependency, string, error) {
+	if s.fallbackTOML != "" {
+		_, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			path = s.fallbackTOML
+		}
+	}
+
+	parse := func(path string) (buildpackMetadata, error) {
+		return parseBuildpackWithHook(path, s.preResolveHook)
+	}
+
+	if s.useResolveCache {
+		uncached := parse
+		parse = func(path string) (buildpackMetadata, error) {
+			return s.resolveCache.Parse(path, uncached)
+		}
+	}
+
+	if s.buildpackCache != nil {
+		uncached := parse
+		parse = func(path string) (buildpackMetadata, error) {
+			return s.buildpackCache.Parse(path, uncached)
+		}
+	}
+
+	var buildpack buildpackMetadata
+	var err error
+	if s.tomlReloader != nil {
+		buildpack, err = s.tomlReloader.Parse(path, parse)
+	} else {
+		buildpack, err = parse(path)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if s.schemaVersion != "" {
+		schemaVersion := buildpack.SchemaVersion
+		if schemaVersion == "" {
+			schemaVersion = "1"
+		}
+
+		if schemaVersion != s.schemaVersion {
+			return nil, "", fmt.Errorf("%w: found %q, expected %q", ErrIncompatibleSchema, schemaVersion, s.schemaVersion)
+		}
+	}
+
+	if s.environmentConstraintPrefix != "" {
+		constraint, ok := os.LookupEnv(fmt.Sprintf("%s%s_CONSTRAINT", s.environmentConstraintPrefix, strings.ToUpper(id)))
+		if ok {
+			version = constraint
+		}
+	}
+
+	if s.ignoreConstraints && version != "" {
+		version = "*"
+	}
+
+	id, err = resolveAlias(buildpack.DependencyAliases, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dependencies := buildpack.Dependencies
+	defaultVersion := buildpack.DefaultVersions[id]
+
+	if version == "" {
+		version = "default"
+	}
+
+	if version == "default" {
+		version = "*"
+		if defaultVersion != "" {
+			version = defaultVersion
+		}
+	}
+
+	// Handle the pessmistic operator (~>)
+	var re = regexp.MustCompile(`~>`)
+	if re.MatchString(version) {
+		res := re.ReplaceAllString(version, "")
+		parts := strings.Split(res, ".")
+
+		// if the version contains a major, minor, and patch use "~" Tilde Range Comparison
+		// if the version contains a major and minor only, or a major version only use "^" Caret Range Comparison
+		if len(parts) == 3 {
+			version = "~" + res
+		} else {
+			version = "^" + res
+		}
+	}
+
+	var compatibleVersions []Dependency
+	versionConstraint, err := semver.NewConstraint(version)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var runtimeVersion *semver.Version
+	if s.runtimeVersion != "" {
+		runtimeVersion, err = semver.NewVersion(s.runtimeVersion)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse runtime version: %w", err)
+		}
+	}
+
+	var supportedVersions []string
+	for _, dependency := range dependencies {
+		if dependency.ID != id || !contextIncludes(dependency.Context, s.contextFilter) {
+			continue
+		}
+
+		usable := true
+		for _, stacks := range layerStacks(dependency, s.layerType) {
+			if !stacksInclude(stacks, stack) || (s.strictStackMatch && !stringSliceContains(stacks, stack)) {
+				usable = false
+			}
+		}
+		if !usable {
+			continue
+		}
+
+		if !osIncludes(dependency.OS, s.osFilter) {
+			continue
+		}
+
+		compatible, err := runtimeVersionIncludes(dependency, runtimeVersion)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if !compatible {
+			continue
+		}
+
+		sVersion, err := semver.NewVersion(dependency.Version)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if versionConstraint.Check(sVersion) {
+			compatibleVersions = append(compatibleVersions, dependency)
+		}
+
+		supportedVersions = append(supportedVersions, dependency.Version)
+	}
+
+	if len(compatibleVersions) == 0 {
+		return nil, "", &ErrNoDeps{id, version, stack, supportedVersions}
+	}
+
+	stacksForVersion := map[string][]string{}
+
+	for _, dep := range compatibleVersions {
+		stacksForVersion[dep.Version] = append(stacksForVersion[dep.Version], dep.Stacks...)
+	}
+
+	for version, stacks := range stacksForVersion {
+		count := stringSliceElementCount(stacks, "*")
+		if count > 1 {
+			return nil, "", fmt.Errorf("multiple dependencies support wildcard stack for version: %q", version)
+		}
+	}
+
+	return compatibleVersions, version, nil
+}
+
+// bestVersion picks the best of the given compatible dependencies, which is
+// the highest version unless a version scorer is configured. The given slice
+// is left in its original order.
+func (s Service) bestVersion(compatibleVersions []Dependency) Dependency {
+	return s.rankVersions(compatibleVersions)[0]
+}
+
+// rankVersions returns the given compatible dependencies ordered from the
+// best to the worst, as picked by bestVersion. The given slice is left in its
+// original order.
+func (s Service) rankVersions(compatibleVersions []Dependency) []Dependency {
+	compatibleVersions = append([]Dependency(nil), compatibleVersions...)
+
+	sort.Slice(compatibleVersions, func(i, j int) bool {
+		iDep := compatibleVersions[i]
+		jDep := compatibleVersions[j]
+
+		jVersion := semver.MustParse(jDep.Version)
+		iVersion := semver.MustParse(iDep.Version)
+
+		if !iVersion.Equal(jVersion) {
+			return iVersion.GreaterThan(jVersion)
+		}
+
+		iStacks := iDep.Stacks
+		jStacks := jDep.Stacks
+
+		// If either dependency supports the wildcard stack, it has lower
+		// priority than a dependency that only supports a more specific stack.
+		// This is true regardless of whether or not the dependency with
+		// wildcard stack support also supports other stacks
+		//
+		// If is an error to have multiple dependencies with the same version
+		// and wildcard stack support.
+		// This is tested for above, and we would not enter this sort function
+		// in this case
+
+		if stringSliceContains(iStacks, "*") {
+			return false
+		}
+
+		if stringSliceContains(jStacks, "*") {
+			return true
+		}
+
+		// As mentioned above, this isn't a valid path to encounter because
+		// only one dependency may have support for wildcard stacks for a given
+		// version. We could panic, but it is preferable to return an invalid
+		// sort order instead.
+		//
+		// This is untested as this path is not possible to encounter.
+		return true
+	})
+
+	if s.versionScorer != nil {
+		scores := make([]float64, len(compatibleVersions))
+		indexes := make([]int, len(compatibleVersions))
+		for i, dependency := range compatibleVersions {
+			scores[i] = s.versionScorer(dependency)
+			indexes[i] = i
+		}
+
+		// The candidates are already in semver order, so a stable sort on the
+		// score breaks ties in favor of the higher version
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return scores[indexes[i]] > scores[indexes[j]]
+		})
+
+		ranked := make([]Dependency, len(indexes))
+		for i, index := range indexes {
+			ranked[i] = compatibleVersions[index]
+		}
+
+		return ranked
+	}
+
+	return compatibleVersions
+}
+
+func stringSliceContains(slice []string, str string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stringSliceElementCount(slice []string, str string) int {
+	count := 0
+	for _, s := range slice {
+		if s == str {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Deliver will fetch and expand a dependency into a layer path location. The
+// location of the CNBPath is given so that dependencies that may be included
+// in a buildpack when packaged for offline consumption can be retrieved. If
+// there is a dependency mapping for the specified dependency, Deliver will use
+// the given dependency mapping URI to fetch the dependency. Otherwise, if a
+// MirrorResolver has been set with WithDependencyMirrorResolver and it finds a
+// mirror for the dependency URI, the mirror is used instead. When the
+// dependency cannot be fetched from its URI, each of its ExtraURIs is tried in
+// order. Dependencies that are split into parts have each of their URIParts
+// fetched in turn and appended to the content fetched from the URI. The
+// dependency is validated against the checksum value provided on the
+// Dependency and will error if there are inconsistencies in the fetched
+// result. Dependencies whose URI ends in .sh or .run are self-extracting
+// installer scripts, which are run with a --target flag naming the layer path
+// once they have been validated rather than being extracted. Python wheels,
+// with a URI ending in .whl, are extracted with the metadata in their
+// .dist-info directories moved under the .dist-info directory of the layer
+// path. A file:// URI that references a directory, resolved relative to the
+// CNBPath, has that directory copied into the layer path. As there is no
+// archive to validate, such dependencies must not declare a checksum.
+// Additional behavior can be configured by passing DeliverOption values.
+func (s Service) Deliver(dependency Dependency, cnbPath, layerPath, platformPath string, options ...DeliverOption) error {
+	_, err := s.DeliverWithStats(dependency, cnbPath, layerPath, platformPath, options...)
+	return err
+}
+
+// DeliveryStats describes the contents that Service.DeliverWithStats
+// extracted into a layer. Only the entries written by the delivery itself are
+// counted, not any other contents of the layer.
+type DeliveryStats struct {
+	// FilesWritten is the number of regular files extracted into the layer.
+	FilesWritten int
+
+	// BytesWritten is the total size in bytes of the regular files extracted
+	// into the layer.
+	BytesWritten int64
+
+	// DirsCreated is the number of directory entries of the dependency that
+	// were created in the layer.
+	DirsCreated int
+
+	// SymlinksCreated is the number of symlinks extracted into the layer.
+	SymlinksCreated int
+
+	// DownloadSize is the number of bytes that the Transport reported for the
+	// download of the dependency, such as the Content-Length reported by
+	// HTTPTransport, or 0 when it did not report a size.
+	DownloadSize int64
+}
+
+// DeliverWithStats delivers a dependency in the same way as Deliver, and
+// returns statistics about the contents that the delivery extracted into the
+// layer, as counted while they were written. Zero stats are returned when
+// nothing is extracted, either because the dependency was already delivered
+// into the layer or because the delivery is a dry run. The files written by an
+// installer script are not counted.
+func (s Service) DeliverWithStats(dependency Dependency, cnbPath, layerPath, platformPath string, options ...DeliverOption) (DeliveryStats, error) {
+	_, span := s.tracer.Start(context.Background(), "postal.Deliver", trace.WithAttributes(
+		attribute.String("dependency.id", dependency.ID),
+		attribute.String("dependency.version", dependency.Version),
+		attribute.String("dependency.stack", strings.Join(dependency.Stacks, ",")),
+		attribute.String("dependency.uri", dependency.URI),
+	))
+	defer span.End()
+
+	stats, err := s.deliver(dependency, cnbPath, layerPath, platformPath, options...)
+	if err != nil {
+		s.metrics.deliveryErrors.Add(context.Background(), 1, attribute.String("dependency.id", dependency.ID))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return DeliveryStats{}, err
+	}
+
+	return stats, nil
+}
+
+func (s Service) deliver(dependency Dependency, cnbPath, layerPath, platformPath string, options ...DeliverOption) (DeliveryStats, error) {
+	deliveryStart := time.Now()
+
+	var config DeliverConfig
+	for _, option := range options {
+		config = option(config)
+	}
+
+	if s.capabilityCheck != nil {
+		var missing []string
+		for _, capability := range dependency.RequiredCapabilities {
+			if !s.capabilityCheck(capability) {
+				missing = append(missing, capability)
+			}
+		}
+
+		if len(missing) > 0 {
+			return DeliveryStats{}, fmt.Errorf("%w: [%s]", ErrCapabilityMissing, strings.Join(missing, ", "))
+		}
+	}
+
+	dependencyChecksum := dependency.Checksum
+	if dependency.SHA256 != "" {
+		dependencyChecksum = fmt.Sprintf("sha256:%s", dependency.SHA256)
+	}
+
+	if config.deduplicate && !config.dryRun && alreadyDelivered(layerPath, dependencyChecksum) {
+		if !dependency.EphemeralURI {
+			s.logger().Info("dependency.delivery.skipped",
+				slog.String("dependency.id", dependency.ID),
+				slog.String("dependency.version", dependency.Version),
+			)
+			return DeliveryStats{}, nil
+		}
+
+		// Dependencies with ephemeral uris are fetched again, so the files of
+		// the previous delivery are removed first
+		err := removeContents(layerPath)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to clean layer path: %w", err)
+		}
+	}
+
+	// Mapped and mirrored uris need not keep the extension of the declared uri,
+	// which is needed to detect archive types that have no magic number
+	extension := uriExtension(dependency.URI)
+
+	dependencyMappingURI, err := s.mappingResolver.FindDependencyMapping(dependencyChecksum, platformPath)
+	if err != nil {
+		return DeliveryStats{}, fmt.Errorf("failure checking for dependency mappings: %s", err)
+	}
+
+	if dependencyMappingURI != "" {
+		dependency.URI = dependencyMappingURI
+		dependency.ExtraURIs = nil
+		dependency.URIParts = nil
+	} else if s.mirrorResolver != nil {
+		dependencyMirrorURI, err := s.mirrorResolver.FindDependencyMirror(dependency.URI)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failure checking for dependency mirrors: %s", err)
+		}
+
+		if dependencyMirrorURI != "" {
+			dependency.URI = dependencyMirrorURI
+		}
+	}
+
+	directory, isDirectory := localDirectory(cnbPath, dependency.URI)
+	if isDirectory && (dependencyChecksum != "" || len(dependency.Checksums) > 0) {
+		return DeliveryStats{}, fmt.Errorf("failed to deliver dependency: local directory %q cannot be validated against a checksum", dependency.URI)
+	}
+
+	if config.dryRun {
+		return DeliveryStats{}, s.dryRun(dependency, dependencyChecksum, extension, cnbPath, config)
+	}
+
+	if config.createLayerDir {
+		err = os.MkdirAll(layerPath, 0755)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to create layer path: %w", err)
+		}
+	}
+
+	downloadedFrom := dependency.URI
+	var downloadSize, bytesRead int64
+	var extracted vacation.Stats
+	if isDirectory {
+		// Local directories are copied into the layer as they are without going
+		// through the transport, as there is no archive to validate
+		err = copyDirectory(directory, layerPath)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to copy local dependency: %w", err)
+		}
+
+		extracted, err = directoryStats(directory)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to collect delivery stats: %w", err)
+		}
+	} else {
+		logger := s.logger().With(
+			slog.String("dependency.id", dependency.ID),
+			slog.String("dependency.version", dependency.Version),
+		)
+		logger.Info("dependency.download.start", slog.String("dependency.uri", dependency.URI))
+		start := time.Now()
+
+		download := func() (fetched, error) {
+			return s.fetch(dependency, dependencyChecksum, extension, cnbPath, layerPath, config)
+		}
+		if isInstaller(dependency.URI) {
+			download = func() (fetched, error) {
+				return s.install(dependency, dependencyChecksum, cnbPath, layerPath)
+			}
+		}
+
+		var existing map[string]bool
+		if config.checksumRetry {
+			existing, err = layerEntries(layerPath)
+			if err != nil {
+				return DeliveryStats{}, fmt.Errorf("failed to list layer path: %w", err)
+			}
+		}
+
+		var result fetched
+		result, err = download()
+		if err != nil && config.checksumRetry && isChecksumMismatch(err) {
+			logger.Warn("dependency.download.retry", slog.String("error", err.Error()))
+
+			// Remove whatever was extracted from the corrupt download so that the
+			// retry starts from the layer as it was before the first attempt
+			err = removeNewEntries(layerPath, existing)
+			if err != nil {
+				return DeliveryStats{}, fmt.Errorf("failed to clean layer path before retrying: %w", err)
+			}
+
+			result, err = download()
+		}
+		if err != nil {
+			logger.Error("dependency.download.failed", slog.String("error", err.Error()))
+			return DeliveryStats{}, err
+		}
+
+		downloadedFrom = result.uri
+		downloadSize = result.downloadSize
+		dependency.DownloadSize = downloadSize
+		bytesRead = result.bytesRead
+		extracted = result.extracted
+		if s.lastDeliveredSize != nil {
+			atomic.StoreInt64(s.lastDeliveredSize, downloadSize)
+		}
+
+		logger.Info("dependency.download.complete",
+			slog.String("dependency.uri", downloadedFrom),
+			slog.Int64("download.bytes", downloadSize),
+			slog.Float64("download.duration_ms", float64(time.Since(start))/float64(time.Millisecond)),
+		)
+	}
+
+	if uriExtension(dependency.URI) == ".whl" {
+		err = relocateDistInfo(layerPath)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to relocate wheel metadata: %w", err)
+		}
+	}
+
+	if dependency.StripSuffix != "" {
+		err = stripTopLevelDirectories(layerPath, dependency.StripSuffix)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to strip top-level directory: %w", err)
+		}
+	}
+
+	for _, rule := range config.renameRules {
+		err = renameFiles(layerPath, rule)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to rename files: %w", err)
+		}
+	}
+
+	if config.contentPatcher != nil {
+		err = patchFiles(layerPath, config.contentPatcher)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to patch files: %w", err)
+		}
+	}
+
+	if config.chown {
+		err = filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if path == layerPath {
+				return nil
+			}
+
+			return os.Lchown(path, config.uid, config.gid)
+		})
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to set file ownership: %w", err)
+		}
+	}
+
+	if config.filePermissions != nil {
+		err = filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Symlinks are skipped as changing their mode changes the file they
+			// point to instead
+			if path == layerPath || info.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+
+			rel, err := filepath.Rel(layerPath, path)
+			if err != nil {
+				return err
+			}
+
+			mode, ok := config.filePermissions[filepath.ToSlash(rel)]
+			if !ok {
+				return nil
+			}
+
+			return os.Chmod(path, mode)
+		})
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to set file permissions: %w", err)
+		}
+	}
+
+	if config.xattrMapper != nil {
+		err = filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if path == layerPath {
+				return nil
+			}
+
+			var linkname string
+			if info.Mode()&os.ModeSymlink != 0 {
+				linkname, err = os.Readlink(path)
+				if err != nil {
+					return err
+				}
+			}
+
+			header, err := tar.FileInfoHeader(info, linkname)
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(layerPath, path)
+			if err != nil {
+				return err
+			}
+
+			header.Name = filepath.ToSlash(rel)
+			if info.IsDir() {
+				header.Name += "/"
+			}
+
+			return setXAttrs(path, config.xattrMapper(header))
+		})
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to set extended attributes: %w", err)
+		}
+	}
+
+	if config.selinuxContext != "" {
+		err = setSELinuxContext(layerPath, config.selinuxContext)
+		if err != nil {
+			return DeliveryStats{}, err
+		}
+	}
+
+	if config.maxSymlinkDepth > 0 {
+		err = checkSymlinkDepth(layerPath, config.maxSymlinkDepth)
+		if err != nil {
+			return DeliveryStats{}, err
+		}
+	}
+
+	if config.minFileCount > 0 {
+		count := extracted.Files + extracted.Symlinks
+		if count < config.minFileCount {
+			return DeliveryStats{}, &ErrInsufficientFiles{Expected: config.minFileCount, Actual: count}
+		}
+	}
+
+	var missing []string
+	for _, file := range dependency.RequiredFiles {
+		_, err = os.Lstat(filepath.Join(layerPath, filepath.FromSlash(file)))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return DeliveryStats{}, fmt.Errorf("failed to check required files: %w", err)
+			}
+
+			missing = append(missing, file)
+		}
+	}
+
+	if len(missing) > 0 {
+		return DeliveryStats{}, &ErrMissingRequiredFile{Paths: missing}
+	}
+
+	stats := DeliveryStats{
+		FilesWritten:    extracted.Files,
+		BytesWritten:    extracted.Bytes,
+		DirsCreated:     extracted.Dirs,
+		SymlinksCreated: extracted.Symlinks,
+		DownloadSize:    downloadSize,
+	}
+
+	if config.deliveryManifest {
+		manifest := DeliveryManifest{
+			Dependency:        dependency,
+			DeliveredAt:       time.Now().UTC(),
+			DownloadedFrom:    downloadedFrom,
+			ValidatedChecksum: dependencyChecksum,
+		}
+
+		if dependency.EphemeralURI {
+			// Ephemeral uris are not recorded as they stop working once they
+			// expire and may grant access to whoever holds them
+			manifest.URI = ""
+			manifest.ExtraURIs = nil
+			manifest.URIParts = nil
+			manifest.DownloadedFrom = ""
+		}
+
+		content, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to write delivery manifest: %w", err)
+		}
+
+		err = os.WriteFile(filepath.Join(layerPath, DeliveryManifestFile), content, 0644)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to write delivery manifest: %w", err)
+		}
+	}
+
+	if config.provenanceWriter != nil {
+		err = writeSLSAProvenance(config.provenanceWriter, config.provenanceBuilderID, dependency, downloadedFrom, dependencyChecksum)
+		if err != nil {
+			return DeliveryStats{}, err
+		}
+	}
+
+	if config.deduplicate && dependencyChecksum != "" {
+		err = os.WriteFile(filepath.Join(layerPath, DeliveryChecksumFile), []byte(dependencyChecksum), 0644)
+		if err != nil {
+			return DeliveryStats{}, fmt.Errorf("failed to record delivery checksum: %w", err)
+		}
+	}
+
+	if config.prometheusPushURL != "" {
+		err = pushDeliveryMetrics(config.prometheusPushURL, dependency, bytesRead, time.Since(deliveryStart))
+		if err != nil {
+			s.logger().Warn("dependency.metrics.push.failed",
+				slog.String("dependency.id", dependency.ID),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	return stats, nil
+}
+
+// renameFiles renames every file below the layer path whose name matches the
+// pattern of the given rule.
+func renameFiles(layerPath string, rule fileRenameRule) error {
+	var matches []string
+	err := filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == layerPath || info.IsDir() {
+			return nil
+		}
+
+		match, err := filepath.Match(rule.from, info.Name())
+		if err != nil {
+			return err
+		}
+
+		if match {
+			matches = append(matches, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range matches {
+		err = os.Rename(path, filepath.Join(filepath.Dir(path), rule.to))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkSymlinkDepth follows every symlink below the layer path from one link
+// to the next, returning an ErrSymlinkDepthExceeded when a chain is longer
+// than the given maximum. Chains that loop back on themselves always exceed
+// the maximum.
+func checkSymlinkDepth(layerPath string, maxDepth int) error {
+	return filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		current := path
+		for depth := 1; ; depth++ {
+			if depth > maxDepth {
+				rel, err := filepath.Rel(layerPath, path)
+				if err != nil {
+					return err
+				}
+
+				return fmt.Errorf("%w: %s links more than %d times", ErrSymlinkDepthExceeded, filepath.ToSlash(rel), maxDepth)
+			}
+
+			target, err := os.Readlink(current)
+			if err != nil {
+				return err
+			}
+
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(current), target)
+			}
+
+			info, err := os.Lstat(target)
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+
+			current = target
+		}
+	})
+}
+
+// patchFiles passes the content of every regular file below the layer path
+// through the given patcher, replacing the content of the files for which it
+// returns a reader.
+func patchFiles(layerPath string, patcher func(filePath string, r io.Reader) (io.Reader, error)) error {
+	return filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(layerPath, path)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		patched, err := patcher(filepath.ToSlash(rel), file)
+		if err != nil {
+			return err
+		}
+
+		if patched == nil {
+			return nil
+		}
+
+		// The patched content is written alongside the file and then moved over
+		// it, as the patched reader may still be reading from the original
+		temp, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf(".%s-patch-*", info.Name()))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(temp.Name())
+
+		_, err = io.Copy(temp, patched)
+		if err != nil {
+			temp.Close()
+			return err
+		}
+
+		err = temp.Chmod(info.Mode().Perm())
+		if err != nil {
+			temp.Close()
+			return err
+		}
+
+		err = temp.Close()
+		if err != nil {
+			return err
+		}
+
+		return os.Rename(temp.Name(), path)
+	})
+}
+
+// directoryStats counts the files, directories, and symlinks in the given
+// directory along with the total size of its files.
+func directoryStats(dir string) (vacation.Stats, error) {
+	var stats vacation.Stats
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case path == dir:
+		case info.Mode()&os.ModeSymlink != 0:
+			stats.Symlinks++
+		case info.IsDir():
+			stats.Dirs++
+		default:
+			stats.Files++
+			stats.Bytes += info.Size()
+		}
+
+		return nil
+	})
+
+	return stats, err
+}
+
+// dryRun fetches and validates the dependency, extracting it into a scratch
+// directory that is removed afterwards to verify that the archive is
+// well-formed.
+func (s Service) dryRun(dependency Dependency, checksum, extension, cnbPath string, config DeliverConfig) error {
+	if _, ok := localDirectory(cnbPath, dependency.URI); ok {
+		return nil
+	}
+
+	scratchPath, err := os.MkdirTemp("", "postal-dry-run")
+	if err != nil {
+		return fmt.Errorf("failed to create dry run directory: %w", err)
+	}
+	defer os.RemoveAll(scratchPath)
+
+	_, err = s.fetch(dependency, checksum, extension, cnbPath, scratchPath, config)
+	return err
+}
+
+// alreadyDelivered reports whether the layer path holds the files of a
+// previous delivery of the dependency with the given checksum.
+func alreadyDelivered(layerPath, checksum string) bool {
+	if checksum == "" {
+		return false
+	}
+
+	content, err := os.ReadFile(filepath.Join(layerPath, DeliveryChecksumFile))
+	if err != nil || string(content) != checksum {
+		return false
+	}
+
+	entries, err := os.ReadDir(layerPath)
+	if err != nil {
+		return false
+	}
+
+	// The layer must hold more than the checksum file itself
+	return len(entries) > 1
+}
+
+// fetched describes a dependency that was fetched from the Transport.
+type fetched struct {
+	// uri is the uri that the dependency was fetched from.
+	uri string
+
+	// downloadSize is the size of the download reported by the Transport, or
+	// 0 when it did not report one.
+	downloadSize int64
+
+	// bytesRead is the number of bytes that were read from the Transport.
+	bytesRead int64
+
+	// extracted counts the entries that were extracted into the layer.
+	extracted vacation.Stats
+}
+
+func (s Service) fetch(dependency Dependency, checksum, extension, cnbPath, layerPath string, config DeliverConfig) (fetched, error) {
+	start := time.Now()
+
+	checksums, err := newChecksumSet(dependency.Checksums)
+	if err != nil {
+		return fetched{}, fmt.Errorf("failed to validate dependency: %s", err)
+	}
+
+	bundle, uri, metadata, err := s.drop(dependency, cnbPath)
+	if err != nil {
+		return fetched{}, err
+	}
+	defer bundle.Close()
+
+	result := fetched{uri: uri}
+
+	var reader io.Reader = bundle
+	if file, ok := bundle.(*os.File); ok {
+		reader, err = bufferNamedPipe(file)
+		if err != nil {
+			return fetched{}, fmt.Errorf("failed to read dependency: %w", err)
+		}
+	}
+
+	if len(dependency.URIParts) > 0 {
+		// The remaining parts of a split dependency are fetched one after
+		// another as the previous part is read to its end
+		parts := newPartsReader(s.transport, cnbPath, dependency.URIParts)
+		defer parts.Close()
+
+		reader = io.MultiReader(reader, parts)
+	} else {
+		result.downloadSize = downloadSize(bundle, metadata)
+	}
+
+	level := dependency.VerificationLevel
+	if config.verificationLevel != "" {
+		level = config.verificationLevel
+	}
+
+	switch level {
+	case "", "full", "fast", "skip":
+	default:
+		return fetched{}, fmt.Errorf("unsupported verification level %q: the following levels are supported [full, fast, skip]", level)
+	}
+
+	if level == "fast" && dependency.FastChecksum == "" {
+		return fetched{}, errors.New("failed to validate dependency: the fast verification level requires a fast-checksum")
+	}
+
+	// The dependency is hashed as it is read from the transport in a separate
+	// goroutine, which feeds the bytes it has hashed through a pipe to be
+	// decompressed, so that validation and extraction happen concurrently.
+	pipeReader, pipeWriter := io.Pipe()
+	validated := make(chan error, 1)
+	var bytesRead int64
+	go func() {
+		var (
+			n   int64
+			err error
+		)
+
+		switch level {
+		case "skip":
+			n, err = io.Copy(pipeWriter, reader)
+		case "fast":
+			partial := &headTailChecksum{}
+			n, err = io.Copy(pipeWriter, io.TeeReader(reader, partial))
+			if err == nil {
+				err = partial.Validate(Checksum(dependency.FastChecksum))
+			}
+		default:
+			n, err = io.Copy(pipeWriter, cargo.NewValidatedReader(io.TeeReader(reader, checksums), checksum))
+			if err == nil {
+				err = checksums.Validate()
+			}
+		}
+
+		attr := attribute.String("dependency.id", dependency.ID)
+		s.metrics.downloadBytes.Record(context.Background(), n, attr)
+		s.metrics.downloadDuration.Record(context.Background(), float64(time.Since(start))/float64(time.Millisecond), attr)
+
+		bytesRead = n
+		pipeWriter.CloseWithError(err)
+		validated <- err
+	}()
+
+	name := dependency.Name
+	if name == "" {
+		// Single files that are only gzip compressed are written out under the
+		// name of the file without its compression suffix
+		name = strings.TrimSuffix(filepath.Base(dependency.URI), ".gz")
+	}
+
+	var source io.Reader = pipeReader
+	if len(s.allowedContentTypes) > 0 {
+		buffered := bufio.NewReaderSize(pipeReader, 512)
+		err = s.verifyContentType(buffered)
+		if err != nil {
+			pipeReader.CloseWithError(err)
+			return fetched{}, err
+		}
+
+		source = buffered
+	}
+
+	archive := vacation.NewArchive(source).WithName(name).WithExtension(extension).StripComponents(dependency.StripComponents).WithGlobs(config.extractGlobs...).WithWorkers(config.extractWorkers).WithStats(&result.extracted)
+	if config.preserveTimestamps {
+		archive = archive.PreserveTimestamps()
+	}
+	if config.preserveCapabilities {
+		archive = archive.PreserveCapabilities()
+	}
+
+	err = archive.Decompress(layerPath)
+	if err != nil {
+		// Unblock the hashing goroutine, which may be waiting on a write that
+		// will never be read
+		pipeReader.CloseWithError(err)
+
+		if isChecksumMismatch(err) {
+			return fetched{}, ErrChecksumMismatch
+		}
+
+		return fetched{}, err
+	}
+
+	// Read whatever the archive did not consume so that the entire dependency
+	// is hashed. Any error from reading the pipe is the error the hashing
+	// goroutine closed it with, which is also what it reports below.
+	_, _ = io.Copy(io.Discard, pipeReader)
+
+	err = <-validated
+	if errors.Is(err, ErrChecksumMismatch) {
+		return fetched{}, err
+	}
+
+	if isChecksumMismatch(err) {
+		return fetched{}, ErrChecksumMismatch
+	}
+
+	if err != nil {
+		return fetched{}, fmt.Errorf("failed to validate dependency: %s", err)
+	}
+
+	result.bytesRead = bytesRead
+
+	return result, nil
+}
+
+// downloadSize returns the size of the given content fetched from the
+// Transport, as reported in the metadata or by the content itself, or 0 when
+// neither reports a size.
+func downloadSize(bundle io.ReadCloser, metadata TransportMetadata) int64 {
+	if metadata.ContentLength > 0 {
+		return metadata.ContentLength
+	}
+
+	if sized, ok := bundle.(interface{ Size() int64 }); ok {
+		return sized.Size()
+	}
+
+	return 0
+}
+
+// drop fetches the dependency from its URI, falling back to each of its
+// ExtraURIs in turn, and returns the content along with the uri that it was
+// fetched from and any metadata that the Transport reported about it.
+func (s Service) drop(dependency Dependency, cnbPath string) (io.ReadCloser, string, TransportMetadata, error) {
+	uris := append([]string{dependency.URI}, dependency.ExtraURIs...)
+
+	drop := func(root, uri string) (io.ReadCloser, TransportMetadata, error) {
+		bundle, err := s.transport.Drop(root, uri)
+		return bundle, TransportMetadata{}, err
+	}
+
+	if metadataTransport, ok := s.transport.(MetadataTransport); ok {
+		drop = metadataTransport.DropWithMetadata
+	}
+
+	if len(dependency.OCILayerDigests) > 0 {
+		layerTransport, ok := s.transport.(LayerTransport)
+		if !ok {
+			return nil, "", TransportMetadata{}, errors.New("failed to fetch dependency: transport does not support selecting oci layers")
+		}
+
+		drop = func(root, uri string) (io.ReadCloser, TransportMetadata, error) {
+			bundle, err := layerTransport.DropLayers(root, uri, dependency.OCILayerDigests)
+			return bundle, TransportMetadata{}, err
+		}
+	}
+
+	var errs []error
+	for _, uri := range uris {
+		bundle, metadata, err := drop(cnbPath, uri)
+		if err == nil {
+			return bundle, uri, metadata, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, "", TransportMetadata{}, fmt.Errorf("failed to fetch dependency: %s", errors.Join(errs...))
+}
+
+// install fetches a self-extracting installer script, validates it against
+// the checksum, and runs it with a --target flag pointing at the layer path.
+func (s Service) install(dependency Dependency, checksum, cnbPath, layerPath string) (fetched, error) {
+	bundle, uri, metadata, err := s.drop(dependency, cnbPath)
+	if err != nil {
+		return fetched{}, err
+	}
+	defer bundle.Close()
+
+	scratchPath, err := os.MkdirTemp("", "postal-installer")
+	if err != nil {
+		return fetched{}, fmt.Errorf("failed to create installer directory: %w", err)
+	}
+	defer os.RemoveAll(scratchPath)
+
+	script := filepath.Join(scratchPath, filepath.Base(dependency.URI))
+	file, err := os.OpenFile(script, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fetched{}, fmt.Errorf("failed to create installer: %w", err)
+	}
+
+	bytesRead, err := io.Copy(file, cargo.NewValidatedReader(bundle, checksum))
+	if err != nil {
+		file.Close()
+		if isChecksumMismatch(err) {
+			return fetched{}, ErrChecksumMismatch
+		}
+
+		return fetched{}, fmt.Errorf("failed to validate dependency: %s", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fetched{}, fmt.Errorf("failed to create installer: %w", err)
+	}
+
+	// The script is only executed once it is known to match the checksum
+	output, err := exec.Command(script, "--target", layerPath).CombinedOutput()
+	if err != nil {
+		return fetched{}, fmt.Errorf("failed to run installer: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return fetched{uri: uri, downloadSize: downloadSize(bundle, metadata), bytesRead: bytesRead}, nil
+}
+
+// uriExtension returns the file name extension of the path of the uri,
+// ignoring any query string.
+func uriExtension(uri string) string {
+	return path.Ext(strings.SplitN(uri, "?", 2)[0])
+}
+
+// isInstaller reports whether the uri locates a self-extracting installer
+// script, such as one built with makeself, rather than an archive.
+func isInstaller(uri string) bool {
+	ext := uriExtension(uri)
+	return ext == ".sh" || ext == ".run"
+}
+
+// relocateDistInfo moves the top-level .dist-info directories extracted from
+// a Python wheel into a .dist-info directory in the layer path, so that only
+// the source files of the wheel are left at the top of the layer.
+func relocateDistInfo(layerPath string) error {
+	directories, err := filepath.Glob(filepath.Join(layerPath, "*.dist-info"))
+	if err != nil {
+		return err
+	}
+
+	if len(directories) == 0 {
+		return nil
+	}
+
+	distInfoPath := filepath.Join(layerPath, ".dist-info")
+	err = os.MkdirAll(distInfoPath, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, directory := range directories {
+		err = os.Rename(directory, filepath.Join(distInfoPath, filepath.Base(directory)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyContentType detects the content type of the start of the given
+// reader without consuming it and checks it against the allowed content
+// types.
+func (s Service) verifyContentType(reader *bufio.Reader) error {
+	header, err := reader.Peek(512)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	detected := http.DetectContentType(header)
+	mediaType, _, err := mime.ParseMediaType(detected)
+	if err != nil {
+		mediaType = detected
+	}
+
+	for _, allowed := range s.allowedContentTypes {
+		if allowed == detected || allowed == mediaType {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnexpectedContentType, detected)
+}
+
+// bufferNamedPipe reads the first 512 bytes of the given file up front when
+// it is a named pipe, returning a reader that yields them followed by the
+// rest of the pipe. A single read from a pipe only returns what the writer has
+// produced so far, so this ensures the start of the archive is available in
+// full when its format is detected. Other files are returned as they are.
+func bufferNamedPipe(file *os.File) (io.Reader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return file, nil
+	}
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return io.MultiReader(bytes.NewReader(header[:n]), file), nil
+}
+
+// isChecksumMismatch reports whether the given error was caused by the
+// fetched dependency not matching its checksum, either while it was being
+// decompressed or once it had been read in full.
+func isChecksumMismatch(err error) bool {
+	return errors.Is(err, ErrChecksumMismatch) || errors.Is(err, cargo.ChecksumValidationError)
+}
+
+// partsReader reads the content of each of the given uris in order, fetching
+// each one from the transport only once the previous one has been read in
+// full. It may be closed while a read is in progress.
+type partsReader struct {
+	transport Transport
+	root      string
+	uris      []string
+
+	mutex   sync.Mutex
+	current io.ReadCloser
+	closed  bool
+}
+
+func newPartsReader(transport Transport, root string, uris []string) *partsReader {
+	return &partsReader{
+		transport: transport,
+		root:      root,
+		uris:      uris,
+	}
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		current, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := current.Read(p)
+		if err == io.EOF {
+			r.mutex.Lock()
+			r.current = nil
+			r.mutex.Unlock()
+
+			err = current.Close()
+			if err != nil {
+				return n, err
+			}
+
+			if n == 0 {
+				continue
+			}
+		}
+
+		return n, err
+	}
+}
+
+// next returns the part currently being read, fetching the next part when
+// the previous one has been read in full.
+func (r *partsReader) next() (io.ReadCloser, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil, errors.New("failed to read dependency parts: reader is closed")
+	}
+
+	if r.current != nil {
+		return r.current, nil
+	}
+
+	if len(r.uris) == 0 {
+		return nil, io.EOF
+	}
+
+	part, err := r.transport.Drop(r.root, r.uris[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch dependency part %q: %s", r.uris[0], err)
+	}
+
+	r.current = part
+	r.uris = r.uris[1:]
+
+	return part, nil
+}
+
+func (r *partsReader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	if r.current == nil {
+		return nil
+	}
+
+	return r.current.Close()
+}
+
+// localDirectory returns the path of the directory referenced by the given
+// uri when it uses the file:// scheme and points at an existing directory.
+// The path of the uri is resolved relative to the CNB path, in the same way
+// that the Transport resolves file:// uris.
+func localDirectory(cnbPath, uri string) (string, bool) {
+	if !strings.HasPrefix(uri, "file://") {
+		return "", false
+	}
+
+	path := filepath.Join(cnbPath, strings.TrimPrefix(uri, "file://"))
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+
+	return path, true
+}
+
+// copyDirectory copies the files, directories, and symlinks within source
+// into destination, preserving their permissions.
+func copyDirectory(source, destination string) error {
+	return mirrorDirectory(source, destination, copyFile)
+}
+
+// linkDirectory recreates the directory tree at source in destination, hard
+// linking each file rather than copying it. Files that cannot be linked, for
+// example because destination is on another device, are copied instead.
+func linkDirectory(source, destination string) error {
+	return mirrorDirectory(source, destination, func(source, destination string, mode fs.FileMode) error {
+		err := os.Link(source, destination)
+		if err == nil {
+			return nil
+		}
+
+		return copyFile(source, destination, mode)
+	})
+}
+
+// mirrorDirectory recreates the directories and symlinks of the directory
+// tree at source in destination, placing each file with the given function.
+func mirrorDirectory(source, destination string, placeFile func(source, destination string, mode fs.FileMode) error) error {
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destination, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(link, target)
 
-	if version == "default" {
-		version = "*"
-		if defaultVersion != "" {
-			version = defaultVersion
+		default:
+			return placeFile(path, target, info.Mode().Perm())
 		}
+	})
+}
+
+func copyFile(source, destination string, mode fs.FileMode) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
 	}
+	defer sourceFile.Close()
 
-	// Handle the pessmistic operator (~>)
-	var re = regexp.MustCompile(`~>`)
-	if re.MatchString(version) {
-		res := re.ReplaceAllString(version, "")
-		parts := strings.Split(res, ".")
+	destinationFile, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer destinationFile.Close()
 
-		// if the version contains a major, minor, and patch use "~" Tilde Range Comparison
-		// if the version contains a major and minor only, or a major version only use "^" Caret Range Comparison
-		if len(parts) == 3 {
-			version = "~" + res
-		} else {
-			version = "^" + res
-		}
+	_, err = io.Copy(destinationFile, sourceFile)
+	if err != nil {
+		return err
 	}
 
-	var compatibleVersions []Dependency
-	versionConstraint, err := semver.NewConstraint(version)
+	return destinationFile.Close()
+}
+
+// stripTopLevelDirectories moves the contents of every directory directly
+// inside of dir whose name matches the given glob pattern up into dir and then
+// removes the emptied directory.
+func stripTopLevelDirectories(dir, pattern string) error {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return Dependency{}, err
+		return err
 	}
 
-	var supportedVersions []string
-	for _, dependency := range dependencies {
-		if dependency.ID != id || !stacksInclude(dependency.Stacks, stack) {
+	for _, entry := range entries {
+		match, err := filepath.Match(pattern, entry.Name())
+		if err != nil {
+			return err
+		}
+
+		if !match || !entry.IsDir() {
 			continue
 		}
 
-		sVersion, err := semver.NewVersion(dependency.Version)
+		top := filepath.Join(dir, entry.Name())
+		children, err := os.ReadDir(top)
 		if err != nil {
-			return Dependency{}, err
+			return err
 		}
 
-		if versionConstraint.Check(sVersion) {
-			compatibleVersions = append(compatibleVersions, dependency)
-		}
+		for _, child := range children {
+			destination := filepath.Join(dir, child.Name())
+			if _, err := os.Lstat(destination); err == nil {
+				return fmt.Errorf("%q already exists", destination)
+			}
 
-		supportedVersions = append(supportedVersions, dependency.Version)
-	}
+			err = os.Rename(filepath.Join(top, child.Name()), destination)
+			if err != nil {
+				return err
+			}
+		}
 
-	if len(compatibleVersions) == 0 {
-		return Dependency{}, &ErrNoDeps{id, version, stack, supportedVersions}
+		err = os.Remove(top)
+		if err != nil {
+			return err
+		}
 	}
 
-	stacksForVersion := map[string][]string{}
+	return nil
+}
 
-	for _, dep := range compatibleVersions {
-		stacksForVersion[dep.Version] = append(stacksForVersion[dep.Version], dep.Stacks...)
+func removeContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
 	}
 
-	for version, stacks := range stacksForVersion {
-		count := stringSliceElementCount(stacks, "*")
-		if count > 1 {
-			return Dependency{}, fmt.Errorf("multiple dependencies support wildcard stack for version: %q", version)
+	for _, entry := range entries {
+		err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
 		}
 	}
 
-	sort.Slice(compatibleVersions, func(i, j int) bool {
-		iDep := compatibleVersions[i]
-		jDep := compatibleVersions[j]
-
-		jVersion := semver.MustParse(jDep.Version)
-		iVersion := semver.MustParse(iDep.Version)
+	return nil
+}
 
-		if !iVersion.Equal(jVersion) {
-			return iVersion.GreaterThan(jVersion)
+// layerEntries returns the set of paths below the layer path. A layer path
+// that does not exist yet has no entries.
+func layerEntries(layerPath string) (map[string]bool, error) {
+	entries := map[string]bool{}
+	err := filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
 
-		iStacks := iDep.Stacks
-		jStacks := jDep.Stacks
+		entries[path] = true
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
 
-		// If either dependency supports the wildcard stack, it has lower
-		// priority than a dependency that only supports a more specific stack.
-		// This is true regardless of whether or not the dependency with
-		// wildcard stack support also supports other stacks
-		//
-		// If is an error to have multiple dependencies with the same version
-		// and wildcard stack support.
-		// This is tested for above, and we would not enter this sort function
-		// in this case
+	return entries, nil
+}
 
-		if stringSliceContains(iStacks, "*") {
-			return false
+// removeNewEntries removes every path below the layer path that is not one
+// of the given existing entries, leaving the existing entries in place.
+func removeNewEntries(layerPath string, existing map[string]bool) error {
+	err := filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
 
-		if stringSliceContains(jStacks, "*") {
-			return true
+		if path == layerPath || existing[path] {
+			return nil
 		}
 
-		// As mentioned above, this isn't a valid path to encounter because
-		// only one dependency may have support for wildcard stacks for a given
-		// version. We could panic, but it is preferable to return an invalid
-		// sort order instead.
-		//
-		// This is untested as this path is not possible to encounter.
-		return true
+		err = os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
 	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
 
-	return compatibleVersions[0], nil
+	return nil
 }
 
-func stringSliceContains(slice []string, str string) bool {
-	for _, s := range slice {
-		if s == str {
-			return true
+// Delivery pairs a Dependency with the layer path it should be delivered
+// into by Service.DeliverAll.
+type Delivery struct {
+	Dependency Dependency
+	LayerPath  string
+}
+
+// DeliverAll will deliver each of the given deliveries as described by
+// Deliver, fetching several of them at once. Deliveries are scheduled in
+// order of their Dependency.DownloadPriority, highest first, with ties
+// resolved by the alphabetical order of the dependency ID. Every delivery is
+// attempted and any failures are returned together.
+func (s Service) DeliverAll(deliveries []Delivery, cnbPath, platformPath string, options ...DeliverOption) error {
+	scheduled := make([]Delivery, len(deliveries))
+	copy(scheduled, deliveries)
+
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		iDep := scheduled[i].Dependency
+		jDep := scheduled[j].Dependency
+
+		if iDep.DownloadPriority != jDep.DownloadPriority {
+			return iDep.DownloadPriority > jDep.DownloadPriority
 		}
+
+		return iDep.ID < jDep.ID
+	})
+
+	concurrency := s.concurrency
+	if concurrency < 1 {
+		concurrency = 1
 	}
 
-	return false
-}
+	queue := make(chan int)
+	errs := make([]error, len(scheduled))
 
-func stringSliceElementCount(slice []string, str string) int {
-	count := 0
-	for _, s := range slice {
-		if s == str {
-			count++
-		}
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range queue {
+				delivery := scheduled[index]
+
+				err := s.Deliver(delivery.Dependency, cnbPath, delivery.LayerPath, platformPath, options...)
+				if err != nil {
+					errs[index] = fmt.Errorf("failed to deliver %q: %w", delivery.Dependency.ID, err)
+				}
+			}
+		}()
 	}
 
-	return count
+	for index := range scheduled {
+		queue <- index
+	}
+	close(queue)
+
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
 
-// Deliver will fetch and expand a dependency into a layer path location. The
-// location of the CNBPath is given so that dependencies that may be included
-// in a buildpack when packaged for offline consumption can be retrieved. If
-// there is a dependency mapping for the specified dependency, Deliver will use
-// the given dependency mapping URI to fetch the dependency. The dependency is
-// validated against the checksum value provided on the Dependency and will
-// error if there are inconsistencies in the fetched result.
-func (s Service) Deliver(dependency Dependency, cnbPath, layerPath, platformPath string) error {
-	dependencyChecksum := dependency.Checksum
-	if dependency.SHA256 != "" {
-		dependencyChecksum = fmt.Sprintf("sha256:%s", dependency.SHA256)
+// MultiDeliver delivers the dependency into the first of the given layer
+// paths with Deliver, and then hard links every file it extracted into each
+// of the remaining layer paths, copying files that cannot be linked. As
+// linked files share their contents, modifying a file in one layer path
+// modifies it in every layer path it was linked into. The returned errors
+// are indexed by layer path, with a nil entry for each successful delivery.
+// When the first delivery fails, its error is reported for every layer path.
+func (s Service) MultiDeliver(dependency Dependency, cnbPath, platformPath string, layerPaths ...string) []error {
+	errs := make([]error, len(layerPaths))
+	if len(layerPaths) == 0 {
+		return errs
 	}
 
-	dependencyMappingURI, err := s.mappingResolver.FindDependencyMapping(dependencyChecksum, platformPath)
+	err := s.Deliver(dependency, cnbPath, layerPaths[0], platformPath)
 	if err != nil {
-		return fmt.Errorf("failure checking for dependency mappings: %s", err)
-	}
+		for i := range errs {
+			errs[i] = err
+		}
 
-	if dependencyMappingURI != "" {
-		dependency.URI = dependencyMappingURI
+		return errs
 	}
 
-	bundle, err := s.transport.Drop(cnbPath, dependency.URI)
-	if err != nil {
-		return fmt.Errorf("failed to fetch dependency: %s", err)
+	for i, layerPath := range layerPaths[1:] {
+		err = linkDirectory(layerPaths[0], layerPath)
+		if err != nil {
+			errs[i+1] = fmt.Errorf("failed to link dependency into %s: %w", layerPath, err)
+		}
 	}
-	defer bundle.Close()
 
-	validatedReader := cargo.NewValidatedReader(bundle, dependencyChecksum)
+	return errs
+}
 
-	name := dependency.Name
-	if name == "" {
-		name = filepath.Base(dependency.URI)
-	}
-	err = vacation.NewArchive(validatedReader).WithName(name).StripComponents(dependency.StripComponents).Decompress(layerPath)
-	if err != nil {
-		return err
+// ValidateNoConflicts returns an error listing every pair of the given
+// dependencies where one declares the ID of the other in its ConflictsWith
+// field. If there are no conflicts, nil is returned.
+func (s Service) ValidateNoConflicts(deps []Dependency) error {
+	ids := map[string]bool{}
+	for _, dependency := range deps {
+		ids[dependency.ID] = true
 	}
 
-	ok, err := validatedReader.Valid()
-	if err != nil {
-		return fmt.Errorf("failed to validate dependency: %s", err)
+	seen := map[[2]string]bool{}
+	var pairs []string
+	for _, dependency := range deps {
+		for _, conflict := range dependency.ConflictsWith {
+			if !ids[conflict] || conflict == dependency.ID {
+				continue
+			}
+
+			pair := [2]string{dependency.ID, conflict}
+			if pair[0] > pair[1] {
+				pair[0], pair[1] = pair[1], pair[0]
+			}
+
+			if seen[pair] {
+				continue
+			}
+			seen[pair] = true
+
+			pairs = append(pairs, fmt.Sprintf("%q conflicts with %q", pair[0], pair[1]))
+		}
 	}
 
-	if !ok {
-		return errors.New("failed to validate dependency: checksum does not match")
+	if len(pairs) == 0 {
+		return nil
 	}
 
-	return nil
+	sort.Strings(pairs)
+
+	return fmt.Errorf("found conflicting dependencies: %s", strings.Join(pairs, ", "))
 }
 
 // GenerateBillOfMaterials will generate a list of BOMEntry values given a
@@ -309,13 +2587,18 @@ func (s Service) GenerateBillOfMaterials(dependencies ...Dependency) []packit.BO
 			sourceHash = ""
 		}
 
+		version := dependency.Version
+		if s.normalizeVersions {
+			version = strings.SplitN(version, "+", 2)[0]
+		}
+
 		paketoBomMetadata := paketosbom.BOMMetadata{
 			Checksum: paketosbom.BOMChecksum{
 				Algorithm: paketoSbomAlgorithm,
 				Hash:      hash,
 			},
 			URI:     dependency.URI,
-			Version: dependency.Version,
+			Version: version,
 			Source: paketosbom.BOMSource{
 				Checksum: paketosbom.BOMChecksum{
 					Algorithm: paketoSbomSrcAlgorithm,
@@ -325,20 +2608,34 @@ func (s Service) GenerateBillOfMaterials(dependencies ...Dependency) []packit.BO
 			},
 		}
 
+		if dependency.BuildFlags != nil {
+			paketoBomMetadata.BuildFlags = dependency.BuildFlags
+		}
+
 		if dependency.CPE != "" {
 			paketoBomMetadata.CPE = dependency.CPE
 		}
 
+		if s.multiCPESupport && dependency.CPEs != nil {
+			paketoBomMetadata.CPEs = dependency.CPEs
+		}
+
 		if (dependency.DeprecationDate != time.Time{}) {
 			paketoBomMetadata.DeprecationDate = dependency.DeprecationDate
 		}
 
+		if dependency.Homepage != "" {
+			paketoBomMetadata.Homepage = dependency.Homepage
+		}
+
 		if dependency.Licenses != nil {
 			paketoBomMetadata.Licenses = dependency.Licenses
 		}
 
 		if dependency.PURL != "" {
 			paketoBomMetadata.PURL = dependency.PURL
+		} else if s.autoGeneratePURL && dependency.ID != "" && dependency.Name != "" && version != "" {
+			paketoBomMetadata.PURL = fmt.Sprintf("pkg:generic/%s@%s", dependency.ID, version)
 		}
 
 		entry := packit.BOMEntry{
@@ -351,3 +2648,202 @@ func (s Service) GenerateBillOfMaterials(dependencies ...Dependency) []packit.BO
 
 	return entries
 }
+
+// GenerateBuildPlanProvisions returns a build plan entry for each of the
+// entries listed in the Provides of the given dependencies. Entries provided
+// by more than one dependency are only returned once, in the order in which
+// they are first found.
+func (s Service) GenerateBuildPlanProvisions(dependencies ...Dependency) []packit.BuildpackPlanEntry {
+	var entries []packit.BuildpackPlanEntry
+	seen := map[string]bool{}
+	for _, dependency := range dependencies {
+		for _, name := range dependency.Provides {
+			if seen[name] {
+				continue
+			}
+
+			seen[name] = true
+			entries = append(entries, packit.BuildpackPlanEntry{Name: name})
+		}
+	}
+
+	return entries
+}
+
+// AttestBillOfMaterials submits the SBOM of the given layer to the
+// RekorSubmitter of the Service, and records the URL of the resulting log
+// entry in the metadata of the layer under "rekor-entry". It is called once
+// the SBOM of the layer has been generated, such as with
+// sbom.GenerateFromDependency and SBOM.InFormats, and submits the first of the
+// formats of that SBOM as it is encoded in that format. As the content of each
+// format is read to submit it, the SBOM of the returned layer holds a copy of
+// that content. The layer is returned unchanged when the Service has no
+// RekorSubmitter.
+func (s Service) AttestBillOfMaterials(layer packit.Layer) (packit.Layer, error) {
+	if s.rekorSubmitter == nil {
+		return layer, nil
+	}
+
+	if layer.SBOM == nil || len(layer.SBOM.Formats()) == 0 {
+		return packit.Layer{}, fmt.Errorf("failed to attest bill of materials: layer %q has no SBOM", layer.Name)
+	}
+
+	var (
+		formats  packit.SBOMFormats
+		document []byte
+	)
+	for i, format := range layer.SBOM.Formats() {
+		content, err := io.ReadAll(format.Content)
+		if err != nil {
+			return packit.Layer{}, fmt.Errorf("failed to encode bill of materials: %w", err)
+		}
+
+		if i == 0 {
+			document = content
+		}
+
+		formats = append(formats, packit.SBOMFormat{
+			Extension: format.Extension,
+			Content:   bytes.NewReader(content),
+		})
+	}
+
+	rekorURL, err := s.rekorSubmitter.Submit(document)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to submit bill of materials to rekor: %w", err)
+	}
+
+	layer.SBOM = formats
+	if layer.Metadata == nil {
+		layer.Metadata = map[string]interface{}{}
+	}
+	layer.Metadata["rekor-entry"] = rekorURL
+
+	return layer, nil
+}
+
+// FetchSBOM returns the content of the pre-built SBOM document in the given
+// format, which is a key of the SBOMURIs of the dependency, fetched using the
+// given Transport. The Transport of the Service is used when the given
+// Transport is nil.
+func (s Service) FetchSBOM(dependency Dependency, format string, transport Transport) ([]byte, error) {
+	uri, ok := dependency.SBOMURIs[format]
+	if !ok {
+		return nil, fmt.Errorf("failed to fetch sbom: dependency %q has no %q sbom", dependency.ID, format)
+	}
+
+	if transport == nil {
+		transport = s.transport
+	}
+
+	bundle, err := transport.Drop("", uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sbom: %w", err)
+	}
+	defer bundle.Close()
+
+	content, err := io.ReadAll(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sbom: %w", err)
+	}
+
+	return content, nil
+}
+
+// ComputedChecksum fetches the dependency using the Transport of the Service
+// and returns the checksum of its content, in the algorithm:hash form, using
+// the given algorithm, which is either "sha256" or "sha512". This allows a
+// checksum to be found for a dependency whose entry predates the Checksum
+// field. The content is not validated against any existing checksum.
+func (s Service) ComputedChecksum(dependency Dependency, algorithm string) (string, error) {
+	return s.computeChecksum(dependency, "", algorithm)
+}
+
+// PopulateChecksums computes the checksum of every dependency in the
+// buildpack.toml at the given path that has neither a Checksum nor a SHA256,
+// using the given algorithm as in ComputedChecksum, and writes them into the
+// file. Dependency uris that use the file scheme are resolved relative to the
+// directory of the buildpack.toml. Only a checksum key is added to each of
+// those dependencies; the rest of the file, including its comments and
+// formatting, is kept.
+func (s Service) PopulateChecksums(path, algorithm string) error {
+	buildpack, err := parseBuildpack(path)
+	if err != nil {
+		return err
+	}
+
+	checksums := map[int]string{}
+	for i, dependency := range buildpack.Dependencies {
+		if dependency.Checksum != "" || dependency.SHA256 != "" {
+			continue
+		}
+
+		checksum, err := s.computeChecksum(dependency, filepath.Dir(path), algorithm)
+		if err != nil {
+			return fmt.Errorf("failed to compute checksum of %s %s: %w", dependency.ID, dependency.Version, err)
+		}
+
+		checksums[i] = checksum
+	}
+
+	if len(checksums) == 0 {
+		return nil
+	}
+
+	return writeDependencyChecksums(path, checksums)
+}
+
+func (s Service) computeChecksum(dependency Dependency, cnbPath, algorithm string) (string, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	bundle, _, _, err := s.drop(dependency, cnbPath)
+	if err != nil {
+		return "", err
+	}
+	defer bundle.Close()
+
+	var reader io.Reader = bundle
+	if len(dependency.URIParts) > 0 {
+		parts := newPartsReader(s.transport, cnbPath, dependency.URIParts)
+		defer parts.Close()
+
+		reader = io.MultiReader(bundle, parts)
+	}
+
+	_, err = io.Copy(h, reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read dependency: %w", err)
+	}
+
+	return fmt.Sprintf("%s:%x", algorithm, h.Sum(nil)), nil
+}
+
+// FetchChangelog returns the content of the changelog at the ChangelogURI of
+// the dependency, fetched using the given Transport. The Transport of the
+// Service is used when the given Transport is nil. An empty string is returned
+// when the dependency has no ChangelogURI.
+func (s Service) FetchChangelog(dependency Dependency, transport Transport) (string, error) {
+	if dependency.ChangelogURI == "" {
+		return "", nil
+	}
+
+	if transport == nil {
+		transport = s.transport
+	}
+
+	bundle, err := transport.Drop("", dependency.ChangelogURI)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch changelog: %w", err)
+	}
+	defer bundle.Close()
+
+	content, err := io.ReadAll(bundle)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch changelog: %w", err)
+	}
+
+	return string(content), nil
+}
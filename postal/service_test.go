@@ -2,23 +2,41 @@ package postal_test
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	gocontext "context"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/paketo-buildpacks/packit/v2"
 	"github.com/paketo-buildpacks/packit/v2/postal"
 	"github.com/paketo-buildpacks/packit/v2/postal/fakes"
+	"github.com/pierrec/lz4/v4"
 	"github.com/sclevine/spec"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	//nolint Ignore SA1019, usage of deprecated package within a deprecated test case
 	"github.com/paketo-buildpacks/packit/v2/paketosbom"
@@ -28,12 +46,15 @@ import (
 
 func testService(t *testing.T, context spec.G, it spec.S) {
 	var (
-		Expect = NewWithT(t).Expect
+		Expect       = NewWithT(t).Expect
+		Eventually   = NewWithT(t).Eventually
+		Consistently = NewWithT(t).Consistently
 
 		path string
 
 		transport       *fakes.Transport
 		mappingResolver *fakes.MappingResolver
+		mirrorResolver  *fakes.MirrorResolver
 
 		service postal.Service
 	)
@@ -109,7 +130,11 @@ strip-components = 1
 
 		mappingResolver = &fakes.MappingResolver{}
 
-		service = postal.NewService(transport).WithDependencyMappingResolver(mappingResolver)
+		mirrorResolver = &fakes.MirrorResolver{}
+
+		service = postal.NewService(transport).
+			WithDependencyMappingResolver(mappingResolver).
+			WithDependencyMirrorResolver(mirrorResolver)
 	})
 
 	context("Resolve", func() {
@@ -131,6 +156,17 @@ strip-components = 1
 			}))
 		})
 
+		context("when no dependency satisfies the constraint", func() {
+			it("suggests the closest supported versions", func() {
+				_, err := service.Resolve(path, "some-entry", "1.3.0", "some-stack")
+
+				var errNoDeps *postal.ErrNoDeps
+				Expect(errors.As(err, &errNoDeps)).To(BeTrue())
+				Expect(errNoDeps.Suggestions(1)).To(Equal([]string{"1.2.3"}))
+				Expect(errNoDeps.Suggestions(5)).To(Equal([]string{"1.2.3", "4.5.6"}))
+			})
+		})
+
 		context("when the dependency has a wildcard stack", func() {
 			it("is compatible with all stack ids", func() {
 				dependency, err := service.Resolve(path, "some-other-entry", "", "random-stack")
@@ -354,369 +390,3954 @@ version = "1.2.3"
 			})
 		})
 
-		context("failure cases", func() {
-			context("when the buildpack.toml is malformed", func() {
-				it.Before(func() {
-					err := os.WriteFile(path, []byte("this is not toml"), 0600)
+		context("when the dependency id is an alias", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependency-aliases]]
+alias = "old-entry"
+target = "some-entry"
+
+[[metadata.dependency-aliases]]
+alias = "older-entry"
+target = "old-entry"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+`), 0600)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("resolves the dependency for the alias target", func() {
+				dependency, err := service.Resolve(path, "old-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency).To(Equal(postal.Dependency{
+					ID:      "some-entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "some-uri",
+					SHA256:  "some-sha",
+					Version: "1.2.3",
+				}))
+			})
+
+			context("when the alias target is itself an alias", func() {
+				it("follows every alias to the final target", func() {
+					dependency, err := service.Resolve(path, "older-entry", "1.2.*", "some-stack")
 					Expect(err).NotTo(HaveOccurred())
+					Expect(dependency.ID).To(Equal("some-entry"))
+					Expect(dependency.Version).To(Equal("1.2.3"))
 				})
+			})
+		})
 
-				it("returns an error", func() {
-					_, err := service.Resolve(path, "some-entry", "1.2.3", "some-stack")
-					Expect(err).To(MatchError(ContainSubstring("failed to parse buildpack.toml")))
-				})
+		context("when a context filter is given", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-build-sha"
+stacks = ["some-stack"]
+uri = "some-build-uri"
+version = "1.2.3"
+context = "build"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-run-sha"
+stacks = ["some-stack"]
+uri = "some-run-uri"
+version = "1.2.2"
+context = "run"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-both-sha"
+stacks = ["some-stack"]
+uri = "some-both-uri"
+version = "1.2.1"
+context = "both"
+`), 0600)
+				Expect(err).NotTo(HaveOccurred())
 			})
 
-			context("when the entry version constraint is not valid", func() {
+			it("selects the build dependency with the build filter", func() {
+				dependency, err := service.WithContextFilter("build").Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-build-uri"))
+				Expect(dependency.Context).To(Equal("build"))
+			})
+
+			it("selects the run dependency with the run filter", func() {
+				dependency, err := service.WithContextFilter("run").Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-run-uri"))
+				Expect(dependency.Context).To(Equal("run"))
+			})
+
+			it("considers dependencies for both contexts with either filter", func() {
+				dependency, err := service.WithContextFilter("run").Resolve(path, "some-entry", "1.2.1", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-both-uri"))
+			})
+
+			it("considers every dependency without a filter", func() {
+				dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-build-uri"))
+			})
+
+			context("when no dependency matches the filter", func() {
 				it("returns an error", func() {
-					_, err := service.Resolve(path, "some-entry", "this-is-not-semver", "some-stack")
-					Expect(err).To(MatchError(ContainSubstring("improper constraint")))
+					_, err := service.WithContextFilter("build").Resolve(path, "some-entry", "1.2.2", "some-stack")
+					Expect(err).To(MatchError(ContainSubstring(`failed to satisfy "some-entry" dependency version constraint "1.2.2"`)))
 				})
 			})
+		})
 
-			context("when the dependency version is not valid", func() {
+		context("when an environment constraint prefix is given", func() {
+			it.Before(func() {
+				service = service.WithEnvironmentConstraintPrefix("BUILD_MATRIX_")
+			})
+
+			context("when the constraint environment variable is set", func() {
 				it.Before(func() {
-					err := os.WriteFile(path, []byte(`
+					t.Setenv("BUILD_MATRIX_SOME-ENTRY_CONSTRAINT", "4.5.*")
+				})
+
+				it("uses the constraint from the environment", func() {
+					dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dependency.Version).To(Equal("4.5.6"))
+				})
+			})
+
+			context("when the constraint environment variable is not set", func() {
+				it("uses the given constraint", func() {
+					dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dependency.Version).To(Equal("1.2.3"))
+				})
+			})
+		})
+
+		context("when a fallback TOML is given", func() {
+			var fallbackPath string
+
+			it.Before(func() {
+				file, err := os.CreateTemp("", "fallback-buildpack.toml")
+				Expect(err).NotTo(HaveOccurred())
+
+				fallbackPath = file.Name()
+				_, err = file.WriteString(`
 [[metadata.dependencies]]
 id = "some-entry"
-sha256 = "some-sha"
+sha256 = "some-fallback-sha"
 stacks = ["some-stack"]
-uri = "some-uri"
-version = "this is super not semver"
-`), 0600)
+uri = "some-fallback-uri"
+version = "1.2.3"
+`)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(file.Close()).To(Succeed())
+
+				service = service.WithFallbackTOML(fallbackPath)
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(fallbackPath)).To(Succeed())
+			})
+
+			it("resolves from the primary TOML when it exists", func() {
+				dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-uri"))
+			})
+
+			context("when the primary TOML does not exist", func() {
+				it.Before(func() {
+					Expect(os.Remove(path)).To(Succeed())
+				})
+
+				it("resolves from the fallback TOML", func() {
+					dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
 					Expect(err).NotTo(HaveOccurred())
+					Expect(dependency.URI).To(Equal("some-fallback-uri"))
+				})
+			})
+
+			context("when the primary TOML is malformed", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(path, []byte("this is not toml"), 0600)).To(Succeed())
 				})
 
 				it("returns an error", func() {
-					_, err := service.Resolve(path, "some-entry", "1.2.3", "some-stack")
-					Expect(err).To(MatchError(ContainSubstring("Invalid Semantic Version")))
+					_, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).To(MatchError(ContainSubstring("failed to parse buildpack.toml")))
 				})
 			})
+		})
 
-			context("when multiple dependencies have a wildcard stack for the same version", func() {
-				it.Before(func() {
-					err := os.WriteFile(path, []byte(`
+		context("when a version scorer is given", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
 [[metadata.dependencies]]
 id = "some-entry"
-sha256 = "some-sha-A"
-stacks = ["some-stack","*"]
-uri = "some-uri-A"
-version = "1.2.3"
+name = "Some Entry"
+sha256 = "some-nightly-sha"
+stacks = ["some-stack"]
+uri = "some-nightly-uri"
+version = "1.2.4"
 
 [[metadata.dependencies]]
 id = "some-entry"
-sha256 = "some-sha-B"
-stacks = ["some-stack","some-other-stack","*"]
-uri = "some-uri-B"
+name = "Some Entry LTS"
+sha256 = "some-lts-sha"
+stacks = ["some-stack"]
+uri = "some-lts-uri"
 version = "1.2.3"
 `), 0600)
-					Expect(err).NotTo(HaveOccurred())
-				})
+				Expect(err).NotTo(HaveOccurred())
 
-				it("returns an error", func() {
-					_, err := service.Resolve(path, "some-entry", "1.2.3", "some-stack")
-					Expect(err).To(MatchError(ContainSubstring(`multiple dependencies support wildcard stack for version: "1.2.3"`)))
+				service = service.WithVersionScorer(func(dependency postal.Dependency) float64 {
+					if strings.Contains(dependency.Name, "LTS") {
+						return 1
+					}
+
+					return 0
 				})
 			})
 
-			context("when the entry version constraint cannot be satisfied", func() {
-				it("returns a typed error with all the supported versions listed", func() {
-					expectedErr := &postal.ErrNoDeps{}
-					_, err := service.Resolve(path, "some-entry", "9.9.9", "some-stack")
-					Expect(errors.As(err, &expectedErr)).To(BeTrue())
-					Expect(err).To(MatchError(ContainSubstring("failed to satisfy \"some-entry\" dependency version constraint \"9.9.9\": no compatible versions on \"some-stack\" stack. Supported versions are: [1.2.3, 4.5.6]")))
+			it("picks the dependency with the highest score", func() {
+				dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.Name).To(Equal("Some Entry LTS"))
+				Expect(dependency.Version).To(Equal("1.2.3"))
+			})
+
+			context("when the scores are tied", func() {
+				it.Before(func() {
+					service = service.WithVersionScorer(func(postal.Dependency) float64 {
+						return 0
+					})
+				})
+
+				it("picks the highest version", func() {
+					dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dependency.Version).To(Equal("1.2.4"))
 				})
 			})
 		})
-	})
 
-	context("Deliver", func() {
-		var (
-			dependencyHash string
-			hash512        string
-			layerPath      string
-			deliver        func() error
-		)
+		context("when a pre-resolve hook is given", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "https://${REGISTRY}/some-entry.tgz"
+version = "1.2.3"
+`), 0600)
+				Expect(err).NotTo(HaveOccurred())
 
-		it.Before(func() {
-			var err error
-			layerPath, err = os.MkdirTemp("", "layer")
-			Expect(err).NotTo(HaveOccurred())
+				service = service.WithPreResolveHook(func(content []byte) ([]byte, error) {
+					return bytes.ReplaceAll(content, []byte("${REGISTRY}"), []byte("registry.example.com")), nil
+				})
+			})
 
-			buffer := bytes.NewBuffer(nil)
-			zw := gzip.NewWriter(buffer)
-			tw := tar.NewWriter(zw)
+			it("parses the content returned by the hook", func() {
+				dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("https://registry.example.com/some-entry.tgz"))
+			})
 
-			Expect(tw.WriteHeader(&tar.Header{Name: "./some-dir", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
-			_, err = tw.Write(nil)
-			Expect(err).NotTo(HaveOccurred())
+			context("when the hook fails", func() {
+				it.Before(func() {
+					service = service.WithPreResolveHook(func([]byte) ([]byte, error) {
+						return nil, errors.New("failed to substitute")
+					})
+				})
 
-			nestedFile := "./some-dir/some-file"
-			Expect(tw.WriteHeader(&tar.Header{Name: nestedFile, Mode: 0755, Size: int64(len(nestedFile))})).To(Succeed())
-			_, err = tw.Write([]byte(nestedFile))
-			Expect(err).NotTo(HaveOccurred())
+				it("returns an error", func() {
+					_, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).To(MatchError("failed to apply pre-resolve hook: failed to substitute"))
+				})
+			})
+		})
 
-			for _, file := range []string{"./first", "./second", "./third"} {
-				Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
-				_, err = tw.Write([]byte(file))
+		context("when the TOML cache is enabled", func() {
+			it.Before(func() {
+				service = service.WithTOMLCache(10)
+			})
+
+			it("only reads the buildpack.toml once for an unchanged file", func() {
+				info, err := os.Stat(path)
 				Expect(err).NotTo(HaveOccurred())
-			}
 
-			linkName := "./symlink"
-			linkDest := "./first"
-			Expect(tw.WriteHeader(&tar.Header{Name: linkName, Mode: 0777, Size: int64(0), Typeflag: tar.TypeSymlink, Linkname: linkDest})).To(Succeed())
-			_, err = tw.Write([]byte{})
-			Expect(err).NotTo(HaveOccurred())
+				dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-uri"))
 
-			Expect(tw.Close()).To(Succeed())
-			Expect(zw.Close()).To(Succeed())
+				// Rewrite the file without changing its modification time, a second
+				// read of the file would no longer find the dependency
+				Expect(os.WriteFile(path, []byte("this is not toml"), 0600)).To(Succeed())
+				Expect(os.Chtimes(path, info.ModTime(), info.ModTime())).To(Succeed())
 
-			sum := sha256.Sum256(buffer.Bytes())
-			dependencyHash = hex.EncodeToString(sum[:])
+				dependency, err = service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-uri"))
+			})
 
-			sum512 := sha512.Sum512(buffer.Bytes())
-			hash512 = hex.EncodeToString(sum512[:])
+			context("when the buildpack.toml is modified", func() {
+				it("reads the file again", func() {
+					_, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
 
-			transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+					Expect(os.WriteFile(path, []byte("this is not toml"), 0600)).To(Succeed())
+					later := time.Now().Add(time.Hour)
+					Expect(os.Chtimes(path, later, later)).To(Succeed())
 
-			deliver = func() error {
-				return service.Deliver(
-					postal.Dependency{
-						ID:      "some-entry",
-						Stacks:  []string{"some-stack"},
-						URI:     "some-entry.tgz",
-						SHA256:  dependencyHash,
-						Version: "1.2.3",
-					},
-					"some-cnb-path",
-					layerPath,
-					"some-platform-dir",
-				)
-			}
+					_, err = service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).To(MatchError(ContainSubstring("failed to parse buildpack.toml")))
+				})
+			})
 		})
 
-		it.After(func() {
-			Expect(os.RemoveAll(layerPath)).To(Succeed())
+		context("when a dependency annotator is given", func() {
+			var annotator *fakes.DependencyAnnotator
+
+			it.Before(func() {
+				annotator = &fakes.DependencyAnnotator{}
+				annotator.AnnotateCall.Stub = func(dependency *postal.Dependency) {
+					if dependency.Metadata == nil {
+						dependency.Metadata = map[string]string{}
+					}
+					dependency.Metadata["team-owner"] = "some-team"
+				}
+
+				service = service.WithDependencyAnnotator(annotator)
+			})
+
+			it("annotates the resolved dependency", func() {
+				dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(annotator.AnnotateCall.CallCount).To(Equal(1))
+				Expect(dependency.Version).To(Equal("1.2.3"))
+				Expect(dependency.Metadata).To(Equal(map[string]string{"team-owner": "some-team"}))
+			})
 		})
 
-		it("downloads the dependency and unpackages it into the path", func() {
-			err := deliver()
+		context("when a warning writer is given", func() {
+			var buffer *bytes.Buffer
 
-			Expect(err).NotTo(HaveOccurred())
+			it.Before(func() {
+				buffer = bytes.NewBuffer(nil)
+				service = service.WithWarningWriter(buffer)
+			})
 
-			Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
-			Expect(transport.DropCall.Receives.Uri).To(Equal("some-entry.tgz"))
-			Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
+			it("warns when the resolved dependency is deprecated", func() {
+				dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.Version).To(Equal("1.2.3"))
 
-			files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
-			Expect(err).NotTo(HaveOccurred())
-			Expect(files).To(ConsistOf([]string{
-				filepath.Join(layerPath, "first"),
-				filepath.Join(layerPath, "second"),
-				filepath.Join(layerPath, "third"),
-				filepath.Join(layerPath, "some-dir"),
-				filepath.Join(layerPath, "symlink"),
-			}))
+				Expect(buffer.String()).To(Equal("[WARNING] dependency some-entry 1.2.3 is deprecated as of 2022-04-01\n"))
+			})
 
-			info, err := os.Stat(filepath.Join(layerPath, "first"))
-			Expect(err).NotTo(HaveOccurred())
-			Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+			it("does not warn when the resolved dependency is not deprecated", func() {
+				_, err := service.Resolve(path, "some-entry", "4.5.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buffer.String()).To(BeEmpty())
+			})
 		})
 
-		context("when using the checksum field", func() {
+		context("when the TOML reloader is enabled", func() {
+			var cancel func()
+
 			it.Before(func() {
-				deliver = func() error {
-					return service.Deliver(
-						postal.Dependency{
-							ID:       "some-entry",
-							Stacks:   []string{"some-stack"},
-							URI:      "some-entry.tgz",
-							Checksum: fmt.Sprintf("sha512:%s", hash512),
-							Version:  "1.2.3",
-						},
-						"some-cnb-path",
-						layerPath,
-						"some-platform-dir",
-					)
-				}
+				var ctx gocontext.Context
+				ctx, cancel = gocontext.WithCancel(gocontext.Background())
+
+				service = service.WithTOMLReloader(ctx, 10*time.Millisecond)
 			})
 
-			it("downloads the dependency and unpackages it into the path", func() {
-				err := deliver()
+			it.After(func() {
+				cancel()
+			})
 
+			it("resolves dependencies from the most recently loaded buildpack.toml", func() {
+				dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
 				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-uri"))
 
-				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
-				Expect(transport.DropCall.Receives.Uri).To(Equal("some-entry.tgz"))
-				Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
+				Expect(os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-new-sha"
+stacks = ["some-stack"]
+uri = "some-new-uri"
+version = "1.2.4"
+`), 0600)).To(Succeed())
 
-				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
-				Expect(err).NotTo(HaveOccurred())
-				Expect(files).To(ConsistOf([]string{
-					filepath.Join(layerPath, "first"),
-					filepath.Join(layerPath, "second"),
-					filepath.Join(layerPath, "third"),
-					filepath.Join(layerPath, "some-dir"),
-					filepath.Join(layerPath, "symlink"),
-				}))
+				Eventually(func() string {
+					dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+					return dependency.URI
+				}).Should(Equal("some-new-uri"))
+			})
 
-				info, err := os.Stat(filepath.Join(layerPath, "first"))
-				Expect(err).NotTo(HaveOccurred())
-				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+			context("when a reload fails to parse the buildpack.toml", func() {
+				it("keeps resolving from the previously loaded contents", func() {
+					_, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
 
-			})
-		})
+					Expect(os.WriteFile(path, []byte("this is not toml"), 0600)).To(Succeed())
 
-		context("when the dependency has a strip-components value set", func() {
-			it.Before(func() {
-				var err error
-				layerPath, err = os.MkdirTemp("", "path")
-				Expect(err).NotTo(HaveOccurred())
+					Consistently(func() string {
+						dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+						Expect(err).NotTo(HaveOccurred())
+						return dependency.URI
+					}, 100*time.Millisecond).Should(Equal("some-uri"))
+				})
+			})
 
-				buffer := bytes.NewBuffer(nil)
-				zw := gzip.NewWriter(buffer)
-				tw := tar.NewWriter(zw)
+			context("when the context is done", func() {
+				it("reads the buildpack.toml on every call to Resolve", func() {
+					_, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
 
-				Expect(tw.WriteHeader(&tar.Header{Name: "some-dir", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
-				_, err = tw.Write(nil)
-				Expect(err).NotTo(HaveOccurred())
+					cancel()
 
-				nestedFile := "some-dir/some-file"
-				Expect(tw.WriteHeader(&tar.Header{Name: nestedFile, Mode: 0755, Size: int64(len(nestedFile))})).To(Succeed())
-				_, err = tw.Write([]byte(nestedFile))
-				Expect(err).NotTo(HaveOccurred())
+					Expect(os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-new-sha"
+stacks = ["some-stack"]
+uri = "some-new-uri"
+version = "1.2.4"
+`), 0600)).To(Succeed())
 
-				for _, file := range []string{"some-dir/first", "some-dir/second", "some-dir/third"} {
-					Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
-					_, err = tw.Write([]byte(file))
+					dependency, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
 					Expect(err).NotTo(HaveOccurred())
-				}
+					Expect(dependency.URI).To(Equal("some-new-uri"))
+				})
+			})
+		})
 
-				linkName := "some-dir/symlink"
-				linkDest := "./first"
-				Expect(tw.WriteHeader(&tar.Header{Name: linkName, Mode: 0777, Size: int64(0), Typeflag: tar.TypeSymlink, Linkname: linkDest})).To(Succeed())
-				_, err = tw.Write([]byte{})
-				Expect(err).NotTo(HaveOccurred())
+		context("when an OS filter is given", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-windows-sha"
+stacks = ["some-stack"]
+uri = "some-windows-uri"
+version = "1.2.3"
+os = "windows"
 
-				Expect(tw.Close()).To(Succeed())
-				Expect(zw.Close()).To(Succeed())
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-linux-sha"
+stacks = ["some-stack"]
+uri = "some-linux-uri"
+version = "1.2.2"
+os = "linux"
 
-				sum := sha256.Sum256(buffer.Bytes())
-				dependencyHash = hex.EncodeToString(sum[:])
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-any-sha"
+stacks = ["some-stack"]
+uri = "some-any-uri"
+version = "1.2.1"
+`), 0600)
+				Expect(err).NotTo(HaveOccurred())
+			})
 
-				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+			it("only considers dependencies for that OS", func() {
+				dependency, err := service.WithOSFilter("linux").Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-linux-uri"))
+				Expect(dependency.OS).To(Equal("linux"))
+			})
 
-				deliver = func() error {
-					return service.Deliver(
-						postal.Dependency{
-							ID:              "some-entry",
-							Stacks:          []string{"some-stack"},
-							URI:             "some-entry.tgz",
-							SHA256:          dependencyHash,
-							Version:         "1.2.3",
-							StripComponents: 1,
-						},
-						"some-cnb-path",
-						layerPath,
-						"",
-					)
-				}
+			it("considers dependencies without an OS", func() {
+				dependency, err := service.WithOSFilter("linux").Resolve(path, "some-entry", "1.2.1", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-any-uri"))
 			})
 
-			it.After(func() {
-				Expect(os.RemoveAll(layerPath)).To(Succeed())
+			context("when no dependency matches the filter", func() {
+				it("returns an error", func() {
+					_, err := service.WithOSFilter("linux").Resolve(path, "some-entry", "1.2.3", "some-stack")
+					Expect(err).To(MatchError(ContainSubstring(`failed to satisfy "some-entry" dependency version constraint "1.2.3"`)))
+				})
 			})
+		})
 
-			it("downloads the dependency, strips given number of componenets and unpackages it into the path", func() {
-				err := deliver()
+		context("when a layer type is given", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-build-sha"
+build-stacks = ["some-build-stack"]
+uri = "some-build-uri"
+version = "1.2.3"
 
-				Expect(err).NotTo(HaveOccurred())
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-run-sha"
+run-stacks = ["some-run-stack"]
+uri = "some-run-uri"
+version = "1.2.2"
 
-				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
-				Expect(transport.DropCall.Receives.Uri).To(Equal("some-entry.tgz"))
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-both-sha"
+build-stacks = ["some-build-stack"]
+run-stacks = ["some-run-stack"]
+uri = "some-both-uri"
+version = "1.2.1"
 
-				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-build-stack"]
+uri = "some-uri"
+version = "1.2.0"
+`), 0600)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(files).To(ConsistOf([]string{
-					filepath.Join(layerPath, "first"),
-					filepath.Join(layerPath, "second"),
-					filepath.Join(layerPath, "third"),
-					filepath.Join(layerPath, "symlink"),
-					filepath.Join(layerPath, "some-file"),
-				}))
+			})
 
-				info, err := os.Stat(filepath.Join(layerPath, "first"))
+			it("picks build-only dependencies for build layers", func() {
+				dependency, err := service.WithLayerType(postal.LayerTypeBuild).Resolve(path, "some-entry", "1.2.*", "some-build-stack")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+				Expect(dependency.URI).To(Equal("some-build-uri"))
+				Expect(dependency.BuildStacks).To(Equal([]string{"some-build-stack"}))
 			})
-		})
 
-		context("when the dependency should be a named file", func() {
-			it.Before(func() {
-				var err error
-				layerPath, err = os.MkdirTemp("", "path")
+			it("picks run-only dependencies for run layers", func() {
+				dependency, err := service.WithLayerType(postal.LayerTypeRun).Resolve(path, "some-entry", "1.2.*", "some-run-stack")
 				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-run-uri"))
+				Expect(dependency.RunStacks).To(Equal([]string{"some-run-stack"}))
+			})
 
-				buffer := bytes.NewBuffer(nil)
-				buffer.WriteString("some-file-contents")
+			it("falls back to the stacks when the typed stacks are absent", func() {
+				dependency, err := service.WithLayerType(postal.LayerTypeRun).Resolve(path, "some-entry", "1.2.0", "some-build-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-uri"))
+			})
 
-				sum := sha256.Sum256(buffer.Bytes())
-				dependencyHash = hex.EncodeToString(sum[:])
+			context("when the layer type is both", func() {
+				it("requires both the build and run stacks to match", func() {
+					err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-build-sha"
+build-stacks = ["some-stack"]
+run-stacks = ["other-stack"]
+uri = "some-build-uri"
+version = "1.2.3"
 
-				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-both-sha"
+build-stacks = ["some-stack"]
+run-stacks = ["some-stack"]
+uri = "some-both-uri"
+version = "1.2.2"
+`), 0600)
+					Expect(err).NotTo(HaveOccurred())
 
-				deliver = func() error {
-					return service.Deliver(
-						postal.Dependency{
-							ID:      "some-entry",
-							Stacks:  []string{"some-stack"},
-							URI:     "https://dependencies.example.com/dependencies/some-file-name.txt",
-							SHA256:  dependencyHash,
-							Version: "1.2.3",
-						},
-						"some-cnb-path",
-						layerPath,
-						"some-platform-dir",
-					)
-				}
+					dependency, err := service.WithLayerType(postal.LayerTypeBoth).Resolve(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dependency.URI).To(Equal("some-both-uri"))
+				})
 			})
 
-			it.After(func() {
-				Expect(os.RemoveAll(layerPath)).To(Succeed())
+			context("when no dependency is built for the stack of the layer", func() {
+				it("returns an error", func() {
+					_, err := service.WithLayerType(postal.LayerTypeBuild).Resolve(path, "some-entry", "1.2.2", "some-build-stack")
+					Expect(err).To(MatchError(ContainSubstring(`failed to satisfy "some-entry" dependency version constraint "1.2.2"`)))
+				})
 			})
+		})
 
-			it("downloads the dependency and copies it into the path with the given name", func() {
-				err := deliver()
-				Expect(err).NotTo(HaveOccurred())
-
-				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
-				Expect(transport.DropCall.Receives.Uri).To(Equal("https://dependencies.example.com/dependencies/some-file-name.txt"))
+		context("when a runtime version check is given", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-focal-sha"
+stacks = ["some-stack"]
+uri = "some-focal-uri"
+version = "1.2.3"
+min-runtime-version = "20.04"
 
-				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-bionic-sha"
+stacks = ["some-stack"]
+uri = "some-bionic-uri"
+version = "1.2.2"
+max-runtime-version = "18.04"
+`), 0600)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(files).To(ConsistOf([]string{filepath.Join(layerPath, "some-file-name.txt")}))
+			})
 
-				content, err := os.ReadFile(filepath.Join(layerPath, "some-file-name.txt"))
+			it("picks dependencies compatible with the runtime version", func() {
+				dependency, err := service.WithRuntimeVersionCheck("20.04").Resolve(path, "some-entry", "1.2.*", "some-stack")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(string(content)).To(Equal("some-file-contents"))
+				Expect(dependency.URI).To(Equal("some-focal-uri"))
+				Expect(dependency.MinRuntimeVersion).To(Equal("20.04"))
 			})
-		})
 
-		context("when there is a dependency mapping via binding", func() {
-			it.Before(func() {
-				mappingResolver.FindDependencyMappingCall.Returns.String = "dependency-mapping-entry.tgz"
-			})
+			it("skips dependencies that need a newer runtime version", func() {
+				dependency, err := service.WithRuntimeVersionCheck("18.04").Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-bionic-uri"))
 
-			context("the dependency has a checksum field", func() {
-				it("looks up the dependency from the platform binding and downloads that instead", func() {
-					err := deliver()
+				_, err = service.WithRuntimeVersionCheck("18.04").Resolve(path, "some-entry", "1.2.3", "some-stack")
+				Expect(err).To(MatchError(ContainSubstring(`failed to satisfy "some-entry" dependency version constraint "1.2.3"`)))
+			})
 
-					Expect(err).NotTo(HaveOccurred())
+			context("failure cases", func() {
+				context("when the runtime version is not valid", func() {
+					it("returns an error", func() {
+						_, err := service.WithRuntimeVersionCheck("not-a-version").Resolve(path, "some-entry", "1.2.*", "some-stack")
+						Expect(err).To(MatchError(ContainSubstring("failed to parse runtime version")))
+					})
+				})
 
-					Expect(mappingResolver.FindDependencyMappingCall.Receives.Checksum).To(Equal("sha256:" + dependencyHash))
-					Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
-					Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
-					Expect(transport.DropCall.Receives.Uri).To(Equal("dependency-mapping-entry.tgz"))
+				context("when a dependency has an invalid runtime version range", func() {
+					it.Before(func() {
+						err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+min-runtime-version = "not-a-version"
+`), 0600)
+						Expect(err).NotTo(HaveOccurred())
+					})
+
+					it("returns an error", func() {
+						_, err := service.WithRuntimeVersionCheck("20.04").Resolve(path, "some-entry", "1.2.*", "some-stack")
+						Expect(err).To(MatchError(ContainSubstring(`failed to parse min-runtime-version of "some-entry"`)))
+					})
+				})
+			})
+		})
+
+		context("ResolveMerged", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-runtime-sha"
+stacks = ["some-stack"]
+uri = "some-runtime-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-headers-sha"
+stacks = ["some-stack"]
+uri = "some-headers-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-older-sha"
+stacks = ["some-stack"]
+uri = "some-older-uri"
+version = "1.1.0"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-other-stack-sha"
+stacks = ["other-stack"]
+uri = "some-other-stack-uri"
+version = "1.2.3"
+`), 0600)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("returns every entry with the best matching version", func() {
+				dependencies, err := service.ResolveMerged(path, "some-entry", "1.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependencies).To(Equal([]postal.Dependency{
+					{
+						ID:      "some-entry",
+						SHA256:  "some-runtime-sha",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-runtime-uri",
+						Version: "1.2.3",
+					},
+					{
+						ID:      "some-entry",
+						SHA256:  "some-headers-sha",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-headers-uri",
+						Version: "1.2.3",
+					},
+				}))
+			})
+
+			context("failure cases", func() {
+				context("when no entry satisfies the constraint", func() {
+					it("returns an error", func() {
+						_, err := service.ResolveMerged(path, "some-entry", "2.*", "some-stack")
+						Expect(err).To(BeAssignableToTypeOf(&postal.ErrNoDeps{}))
+					})
+				})
+			})
+		})
+
+		context("ResolveWithScores", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.1.0"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.10.0"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "2.0.0"
+`), 0600)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("returns every candidate ranked by score with the winner first", func() {
+				candidates, err := service.ResolveWithScores(path, "some-entry", "1.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(candidates).To(HaveLen(3))
+
+				Expect(candidates[0].Dependency.Version).To(Equal("1.10.0"))
+				Expect(candidates[0].Score).To(Equal(11))
+				Expect(candidates[1].Dependency.Version).To(Equal("1.2.3"))
+				Expect(candidates[1].Score).To(Equal(10))
+				Expect(candidates[2].Dependency.Version).To(Equal("1.1.0"))
+				Expect(candidates[2].Score).To(Equal(9))
+
+				dependency, err := service.Resolve(path, "some-entry", "1.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(candidates[0].Dependency).To(Equal(dependency))
+			})
+
+			it("scores an exact version higher than a wildcard or a range", func() {
+				exact, err := service.ResolveWithScores(path, "some-entry", "1.2.3", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(exact).To(HaveLen(1))
+				Expect(exact[0].Score).To(Equal(7))
+
+				wildcard, err := service.ResolveWithScores(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(wildcard).To(HaveLen(1))
+				Expect(wildcard[0].Score).To(Equal(5))
+
+				ranged, err := service.ResolveWithScores(path, "some-entry", ">= 1.2.0, < 1.3.0", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ranged).To(HaveLen(1))
+				Expect(ranged[0].Score).To(Equal(3))
+			})
+
+			context("when a version scorer is configured", func() {
+				it.Before(func() {
+					service = service.WithVersionScorer(func(dependency postal.Dependency) float64 {
+						if dependency.Version == "1.2.3" {
+							return 1
+						}
+
+						return 0
+					})
+				})
+
+				it("ranks the candidates by the version scorer", func() {
+					candidates, err := service.ResolveWithScores(path, "some-entry", "1.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(candidates).To(HaveLen(3))
+
+					Expect(candidates[0].Dependency.Version).To(Equal("1.2.3"))
+					Expect(candidates[1].Dependency.Version).To(Equal("1.10.0"))
+					Expect(candidates[2].Dependency.Version).To(Equal("1.1.0"))
+					Expect(candidates[0].Score).To(BeNumerically(">", candidates[1].Score))
+				})
+			})
+
+			context("failure cases", func() {
+				context("when no entry satisfies the constraint", func() {
+					it("returns an error", func() {
+						_, err := service.ResolveWithScores(path, "some-entry", "3.*", "some-stack")
+						Expect(err).To(BeAssignableToTypeOf(&postal.ErrNoDeps{}))
+					})
+				})
+			})
+		})
+
+		context("AssertMinDependencies", func() {
+			it("succeeds when there are at least the minimum number of dependencies", func() {
+				Expect(service.AssertMinDependencies(path, "some-entry", "some-stack", 2)).To(Succeed())
+			})
+
+			context("when there are fewer dependencies than the minimum", func() {
+				it("returns a typed error with the actual and expected counts", func() {
+					err := service.AssertMinDependencies(path, "some-entry", "some-stack", 3)
+
+					var insufficientDependenciesErr *postal.ErrInsufficientDependencies
+					Expect(errors.As(err, &insufficientDependenciesErr)).To(BeTrue())
+					Expect(insufficientDependenciesErr.Actual).To(Equal(2))
+					Expect(insufficientDependenciesErr.Expected).To(Equal(3))
+					Expect(err).To(MatchError(`found 2 dependencies with id "some-entry" for stack "some-stack", expected at least 3`))
+				})
+			})
+
+			context("when there are no matching dependencies", func() {
+				it("returns a typed error with an actual count of zero", func() {
+					err := service.AssertMinDependencies(path, "missing-entry", "some-stack", 1)
+
+					var insufficientDependenciesErr *postal.ErrInsufficientDependencies
+					Expect(errors.As(err, &insufficientDependenciesErr)).To(BeTrue())
+					Expect(insufficientDependenciesErr.Actual).To(Equal(0))
+				})
+			})
+
+			context("failure cases", func() {
+				context("when the buildpack.toml cannot be parsed", func() {
+					it.Before(func() {
+						Expect(os.WriteFile(path, []byte("%%%"), 0600)).To(Succeed())
+					})
+
+					it("returns an error", func() {
+						err := service.AssertMinDependencies(path, "some-entry", "some-stack", 1)
+						Expect(err).To(MatchError(ContainSubstring("failed to parse buildpack.toml")))
+					})
+				})
+			})
+		})
+
+		context("ResolveWithCache", func() {
+			var parses int
+
+			it.Before(func() {
+				parses = 0
+				service = service.WithPreResolveHook(func(content []byte) ([]byte, error) {
+					parses++
+					return content, nil
+				})
+			})
+
+			it("only parses a buildpack.toml that has not changed once", func() {
+				dependency, err := service.ResolveWithCache(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.Version).To(Equal("1.2.3"))
+
+				dependency, err = service.ResolveWithCache(path, "some-entry", "4.5.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.Version).To(Equal("4.5.6"))
+
+				Expect(parses).To(Equal(1))
+			})
+
+			context("when the buildpack.toml changes between calls", func() {
+				it("parses the file again", func() {
+					_, err := service.ResolveWithCache(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+
+					modTime := time.Now().Add(time.Hour)
+					Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+
+					_, err = service.ResolveWithCache(path, "some-entry", "1.2.*", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(parses).To(Equal(2))
+				})
+			})
+		})
+
+		context("when a schema version is required", func() {
+			it("treats a buildpack.toml without a schema version as version 1", func() {
+				dependency, err := service.WithRequiredSchemaVersion("1").Resolve(path, "some-entry", "1.2.*", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.Version).To(Equal("1.2.3"))
+			})
+
+			context("when the buildpack.toml declares a different schema version", func() {
+				it.Before(func() {
+					err := os.WriteFile(path, []byte(`
+[metadata]
+schema-version = "2"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+`), 0600)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				it("returns an error", func() {
+					_, err := service.WithRequiredSchemaVersion("1").Resolve(path, "some-entry", "1.2.3", "some-stack")
+					Expect(err).To(MatchError(postal.ErrIncompatibleSchema))
+					Expect(err).To(MatchError(ContainSubstring(`found "2", expected "1"`)))
+				})
+
+				it("resolves the dependency when the schema version matches", func() {
+					dependency, err := service.WithRequiredSchemaVersion("2").Resolve(path, "some-entry", "1.2.3", "some-stack")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dependency.Version).To(Equal("1.2.3"))
+				})
+			})
+		})
+
+		context("when version constraints are ignored", func() {
+			it("picks the highest version of the dependency", func() {
+				dependency, err := service.WithIgnoreConstraints().Resolve(path, "some-entry", "1.2.3", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.Version).To(Equal("4.5.6"))
+				Expect(dependency.StripComponents).To(Equal(1))
+			})
+		})
+
+		context("when strict stack matching is enabled", func() {
+			it.Before(func() {
+				err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-wildcard-sha"
+stacks = ["*"]
+uri = "some-wildcard-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-specific-sha"
+stacks = ["some-stack"]
+uri = "some-specific-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "other-entry"
+sha256 = "other-wildcard-sha"
+stacks = ["*"]
+uri = "other-wildcard-uri"
+version = "4.5.6"
+`), 0600)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("picks the dependency that explicitly lists the stack", func() {
+				dependency, err := service.WithStrictStackMatch().Resolve(path, "some-entry", "1.2.3", "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.URI).To(Equal("some-specific-uri"))
+				Expect(dependency.Stacks).To(Equal([]string{"some-stack"}))
+			})
+
+			context("when only a wildcard stack dependency exists", func() {
+				it("returns an error", func() {
+					_, err := service.WithStrictStackMatch().Resolve(path, "other-entry", "4.5.6", "some-stack")
+					Expect(err).To(BeAssignableToTypeOf(&postal.ErrNoDeps{}))
+					Expect(err).To(MatchError(ContainSubstring(`failed to satisfy "other-entry" dependency version constraint "4.5.6": no compatible versions on "some-stack" stack`)))
+				})
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the buildpack.toml is malformed", func() {
+				it.Before(func() {
+					err := os.WriteFile(path, []byte("this is not toml"), 0600)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				it("returns an error", func() {
+					_, err := service.Resolve(path, "some-entry", "1.2.3", "some-stack")
+					Expect(err).To(MatchError(ContainSubstring("failed to parse buildpack.toml")))
+				})
+			})
+
+			context("when the entry version constraint is not valid", func() {
+				it("returns an error", func() {
+					_, err := service.Resolve(path, "some-entry", "this-is-not-semver", "some-stack")
+					Expect(err).To(MatchError(ContainSubstring("improper constraint")))
+				})
+			})
+
+			context("when the dependency version is not valid", func() {
+				it.Before(func() {
+					err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "this is super not semver"
+`), 0600)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				it("returns an error", func() {
+					_, err := service.Resolve(path, "some-entry", "1.2.3", "some-stack")
+					Expect(err).To(MatchError(ContainSubstring("Invalid Semantic Version")))
+				})
+			})
+
+			context("when multiple dependencies have a wildcard stack for the same version", func() {
+				it.Before(func() {
+					err := os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha-A"
+stacks = ["some-stack","*"]
+uri = "some-uri-A"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha-B"
+stacks = ["some-stack","some-other-stack","*"]
+uri = "some-uri-B"
+version = "1.2.3"
+`), 0600)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				it("returns an error", func() {
+					_, err := service.Resolve(path, "some-entry", "1.2.3", "some-stack")
+					Expect(err).To(MatchError(ContainSubstring(`multiple dependencies support wildcard stack for version: "1.2.3"`)))
+				})
+			})
+
+			context("when the dependency aliases are circular", func() {
+				it.Before(func() {
+					err := os.WriteFile(path, []byte(`
+[[metadata.dependency-aliases]]
+alias = "some-entry"
+target = "other-entry"
+
+[[metadata.dependency-aliases]]
+alias = "other-entry"
+target = "another-entry"
+
+[[metadata.dependency-aliases]]
+alias = "another-entry"
+target = "some-entry"
+`), 0600)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				it("returns an error", func() {
+					_, err := service.Resolve(path, "some-entry", "1.2.3", "some-stack")
+					Expect(err).To(MatchError("circular dependency alias detected: some-entry -> other-entry -> another-entry -> some-entry"))
+				})
+			})
+
+			context("when the entry version constraint cannot be satisfied", func() {
+				it("returns a typed error with all the supported versions listed", func() {
+					expectedErr := &postal.ErrNoDeps{}
+					_, err := service.Resolve(path, "some-entry", "9.9.9", "some-stack")
+					Expect(errors.As(err, &expectedErr)).To(BeTrue())
+					Expect(err).To(MatchError(ContainSubstring("failed to satisfy \"some-entry\" dependency version constraint \"9.9.9\": no compatible versions on \"some-stack\" stack. Supported versions are: [1.2.3, 4.5.6]")))
+				})
+			})
+		})
+	})
+
+	context("ResolveForBuildPlanEntry", func() {
+		it("uses the version in the entry metadata as the version constraint", func() {
+			dependency, err := service.ResolveForBuildPlanEntry(path, packit.BuildpackPlanEntry{
+				Name:     "some-entry",
+				Metadata: map[string]interface{}{"version": "1.2.*"},
+			}, "some-stack")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dependency.ID).To(Equal("some-entry"))
+			Expect(dependency.Version).To(Equal("1.2.3"))
+		})
+
+		context("when the entry has no version", func() {
+			it("resolves the latest version", func() {
+				dependency, err := service.ResolveForBuildPlanEntry(path, packit.BuildpackPlanEntry{
+					Name: "some-entry",
+				}, "some-stack")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dependency.Version).To(Equal("4.5.6"))
+			})
+		})
+
+		context("when no dependency satisfies the version", func() {
+			it("returns an error", func() {
+				_, err := service.ResolveForBuildPlanEntry(path, packit.BuildpackPlanEntry{
+					Name:     "some-entry",
+					Metadata: map[string]interface{}{"version": "9.9.9"},
+				}, "some-stack")
+				Expect(err).To(MatchError(ContainSubstring(`failed to satisfy "some-entry" dependency version constraint "9.9.9"`)))
+			})
+		})
+	})
+
+	context("Deliver", func() {
+		var (
+			dependencyHash string
+			hash512        string
+			layerPath      string
+			deliver        func() error
+		)
+
+		it.Before(func() {
+			var err error
+			layerPath, err = os.MkdirTemp("", "layer")
+			Expect(err).NotTo(HaveOccurred())
+
+			buffer := bytes.NewBuffer(nil)
+			zw := gzip.NewWriter(buffer)
+			tw := tar.NewWriter(zw)
+
+			Expect(tw.WriteHeader(&tar.Header{Name: "./some-dir", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+			_, err = tw.Write(nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			nestedFile := "./some-dir/some-file"
+			Expect(tw.WriteHeader(&tar.Header{Name: nestedFile, Mode: 0755, Size: int64(len(nestedFile))})).To(Succeed())
+			_, err = tw.Write([]byte(nestedFile))
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, file := range []string{"./first", "./second", "./third"} {
+				Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+				_, err = tw.Write([]byte(file))
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			linkName := "./symlink"
+			linkDest := "./first"
+			Expect(tw.WriteHeader(&tar.Header{Name: linkName, Mode: 0777, Size: int64(0), Typeflag: tar.TypeSymlink, Linkname: linkDest})).To(Succeed())
+			_, err = tw.Write([]byte{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tw.Close()).To(Succeed())
+			Expect(zw.Close()).To(Succeed())
+
+			sum := sha256.Sum256(buffer.Bytes())
+			dependencyHash = hex.EncodeToString(sum[:])
+
+			sum512 := sha512.Sum512(buffer.Bytes())
+			hash512 = hex.EncodeToString(sum512[:])
+
+			transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+			deliver = func() error {
+				return service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+			}
+		})
+
+		it.After(func() {
+			Expect(os.RemoveAll(layerPath)).To(Succeed())
+		})
+
+		it("downloads the dependency and unpackages it into the path", func() {
+			err := deliver()
+
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+			Expect(transport.DropCall.Receives.Uri).To(Equal("some-entry.tgz"))
+			Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
+
+			files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(ConsistOf([]string{
+				filepath.Join(layerPath, "first"),
+				filepath.Join(layerPath, "second"),
+				filepath.Join(layerPath, "third"),
+				filepath.Join(layerPath, "some-dir"),
+				filepath.Join(layerPath, "symlink"),
+			}))
+
+			info, err := os.Stat(filepath.Join(layerPath, "first"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+		})
+
+		context("when using the checksum field", func() {
+			it.Before(func() {
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:       "some-entry",
+							Stacks:   []string{"some-stack"},
+							URI:      "some-entry.tgz",
+							Checksum: fmt.Sprintf("sha512:%s", hash512),
+							Version:  "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+				}
+			})
+
+			it("downloads the dependency and unpackages it into the path", func() {
+				err := deliver()
+
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+				Expect(transport.DropCall.Receives.Uri).To(Equal("some-entry.tgz"))
+				Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "first"),
+					filepath.Join(layerPath, "second"),
+					filepath.Join(layerPath, "third"),
+					filepath.Join(layerPath, "some-dir"),
+					filepath.Join(layerPath, "symlink"),
+				}))
+
+				info, err := os.Stat(filepath.Join(layerPath, "first"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+
+			})
+		})
+
+		context("when the transport reports the size of the download", func() {
+			var (
+				server *httptest.Server
+				size   int
+			)
+
+			it.Before(func() {
+				content, err := io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+				size = len(content)
+
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					_, _ = w.Write(content)
+				}))
+
+				service = postal.NewService(postal.NewHTTPTransport()).
+					WithDependencyMappingResolver(mappingResolver).
+					WithDependencyMirrorResolver(mirrorResolver)
+
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     fmt.Sprintf("%s/some-entry.tgz", server.URL),
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+				}
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("records the size of the delivered dependency", func() {
+				Expect(service.LastDeliveredSize()).To(Equal(int64(0)))
+
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				info, err := os.Stat(filepath.Join(layerPath, "first"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+
+				Expect(service.LastDeliveredSize()).To(Equal(int64(size)))
+			})
+
+			it("returns the size of the download in the delivery stats", func() {
+				stats, err := service.DeliverWithStats(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     fmt.Sprintf("%s/some-entry.tgz", server.URL),
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stats.DownloadSize).To(Equal(int64(size)))
+			})
+
+			it("sets the size on the dependency recorded in the delivery manifest", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     fmt.Sprintf("%s/some-entry.tgz", server.URL),
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithDeliveryManifest(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, postal.DeliveryManifestFile))
+				Expect(err).NotTo(HaveOccurred())
+
+				var manifest postal.DeliveryManifest
+				Expect(json.Unmarshal(content, &manifest)).To(Succeed())
+				Expect(manifest.DownloadSize).To(Equal(int64(size)))
+			})
+		})
+
+		context("when the transport reports metadata about the download", func() {
+			var metadataTransport *fakes.MetadataTransport
+
+			it.Before(func() {
+				metadataTransport = &fakes.MetadataTransport{}
+				metadataTransport.DropWithMetadataCall.Returns.ReadCloser = transport.DropCall.Returns.ReadCloser
+				metadataTransport.DropWithMetadataCall.Returns.TransportMetadata = postal.TransportMetadata{
+					ETag:          `"some-etag"`,
+					LastModified:  time.Date(2023, time.January, 2, 3, 4, 5, 0, time.UTC),
+					ContentType:   "application/gzip",
+					ContentLength: 1234,
+				}
+
+				service = postal.NewService(metadataTransport).
+					WithDependencyMappingResolver(mappingResolver).
+					WithDependencyMirrorResolver(mirrorResolver)
+			})
+
+			it("fetches the dependency with its metadata and records the reported size", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(metadataTransport.DropCall.CallCount).To(Equal(0))
+				Expect(metadataTransport.DropWithMetadataCall.CallCount).To(Equal(1))
+				Expect(metadataTransport.DropWithMetadataCall.Receives.Root).To(Equal("some-cnb-path"))
+				Expect(metadataTransport.DropWithMetadataCall.Receives.Uri).To(Equal("some-entry.tgz"))
+
+				Expect(filepath.Join(layerPath, "first")).To(BeARegularFile())
+				Expect(service.LastDeliveredSize()).To(Equal(int64(1234)))
+			})
+		})
+
+		context("when the transport returns a named pipe", func() {
+			var pipeDir string
+
+			it.Before(func() {
+				content, err := io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+
+				pipeDir, err = os.MkdirTemp("", "pipe")
+				Expect(err).NotTo(HaveOccurred())
+
+				pipePath := filepath.Join(pipeDir, "some-pipe")
+				Expect(syscall.Mkfifo(pipePath, 0600)).To(Succeed())
+
+				go func() {
+					pipe, err := os.OpenFile(pipePath, os.O_WRONLY, 0600)
+					if err != nil {
+						return
+					}
+					defer pipe.Close()
+
+					// Write the archive in small chunks so that reads from the pipe
+					// return less than the full header
+					for len(content) > 0 {
+						n := 64
+						if n > len(content) {
+							n = len(content)
+						}
+
+						_, err = pipe.Write(content[:n])
+						if err != nil {
+							return
+						}
+
+						content = content[n:]
+					}
+				}()
+
+				transport.DropCall.Stub = func(string, string) (io.ReadCloser, error) {
+					return os.Open(pipePath)
+				}
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(pipeDir)).To(Succeed())
+			})
+
+			it("extracts the dependency from the pipe", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "first"),
+					filepath.Join(layerPath, "second"),
+					filepath.Join(layerPath, "third"),
+					filepath.Join(layerPath, "some-dir"),
+					filepath.Join(layerPath, "symlink"),
+				}))
+			})
+		})
+
+		context("when the transport blocks partway through the download", func() {
+			var gate chan struct{}
+
+			it.Before(func() {
+				content, err := io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+
+				gate = make(chan struct{})
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(io.MultiReader(
+					bytes.NewReader(content[:len(content)/2]),
+					gatedReader{gate: gate, reader: bytes.NewReader(content[len(content)/2:])},
+				))
+			})
+
+			it("finishes the delivery once the transport resumes", func() {
+				done := make(chan error)
+				go func() {
+					done <- deliver()
+				}()
+
+				Consistently(done, "100ms").ShouldNot(Receive())
+
+				close(gate)
+
+				Eventually(done, "5s").Should(Receive(BeNil()))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "first"),
+					filepath.Join(layerPath, "second"),
+					filepath.Join(layerPath, "third"),
+					filepath.Join(layerPath, "some-dir"),
+					filepath.Join(layerPath, "symlink"),
+				}))
+			})
+		})
+
+		context("when the dependency uri references a local directory", func() {
+			var cnbDir string
+
+			it.Before(func() {
+				var err error
+				cnbDir, err = os.MkdirTemp("", "cnb")
+				Expect(err).NotTo(HaveOccurred())
+
+				sourceDir := filepath.Join(cnbDir, "some-source")
+				Expect(os.MkdirAll(filepath.Join(sourceDir, "some-dir"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(sourceDir, "some-file"), []byte("some-contents"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(sourceDir, "some-dir", "some-nested-file"), []byte("some-nested-contents"), 0644)).To(Succeed())
+				Expect(os.Symlink("some-file", filepath.Join(sourceDir, "some-symlink"))).To(Succeed())
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(cnbDir)).To(Succeed())
+			})
+
+			it("copies the directory into the path without using the transport", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "file:///some-source",
+						Version: "1.2.3",
+					},
+					cnbDir,
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.CallCount).To(Equal(0))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "some-dir"),
+					filepath.Join(layerPath, "some-file"),
+					filepath.Join(layerPath, "some-symlink"),
+				}))
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-contents"))
+
+				info, err := os.Stat(filepath.Join(layerPath, "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+
+				content, err = os.ReadFile(filepath.Join(layerPath, "some-dir", "some-nested-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-nested-contents"))
+
+				link, err := os.Readlink(filepath.Join(layerPath, "some-symlink"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link).To(Equal("some-file"))
+			})
+
+			context("failure cases", func() {
+				context("when the dependency declares a checksum", func() {
+					it("returns an error without copying the directory", func() {
+						err := service.Deliver(
+							postal.Dependency{
+								ID:      "some-entry",
+								Stacks:  []string{"some-stack"},
+								URI:     "file:///some-source",
+								SHA256:  "some-sha",
+								Version: "1.2.3",
+							},
+							cnbDir,
+							layerPath,
+							"some-platform-dir",
+						)
+						Expect(err).To(MatchError(`failed to deliver dependency: local directory "file:///some-source" cannot be validated against a checksum`))
+
+						Expect(filepath.Join(layerPath, "some-file")).NotTo(BeAnExistingFile())
+					})
+				})
+			})
+		})
+
+		context("when the dependency is split into parts", func() {
+			var parts map[string][]byte
+
+			it.Before(func() {
+				content, err := io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+
+				parts = map[string][]byte{
+					"some-entry.tgz.part1": content[:len(content)/2],
+					"some-entry.tgz.part2": content[len(content)/2:],
+				}
+
+				transport.DropCall.Stub = func(root, uri string) (io.ReadCloser, error) {
+					part, ok := parts[uri]
+					if !ok {
+						return nil, fmt.Errorf("unknown uri %q", uri)
+					}
+
+					return io.NopCloser(bytes.NewReader(part)), nil
+				}
+			})
+
+			it("downloads every part and unpackages them into the path", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:       "some-entry",
+						Stacks:   []string{"some-stack"},
+						URI:      "some-entry.tgz.part1",
+						URIParts: []string{"some-entry.tgz.part2"},
+						SHA256:   dependencyHash,
+						Version:  "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.CallCount).To(Equal(2))
+				Expect(transport.DropCall.Receives.Uri).To(Equal("some-entry.tgz.part2"))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "first"),
+					filepath.Join(layerPath, "second"),
+					filepath.Join(layerPath, "third"),
+					filepath.Join(layerPath, "some-dir"),
+					filepath.Join(layerPath, "symlink"),
+				}))
+			})
+
+			context("when the first part is a named pipe", func() {
+				var pipeDir string
+
+				it.Before(func() {
+					var err error
+					pipeDir, err = os.MkdirTemp("", "pipe")
+					Expect(err).NotTo(HaveOccurred())
+
+					pipePath := filepath.Join(pipeDir, "some-pipe")
+					Expect(syscall.Mkfifo(pipePath, 0600)).To(Succeed())
+
+					go func() {
+						pipe, err := os.OpenFile(pipePath, os.O_WRONLY, 0600)
+						if err != nil {
+							return
+						}
+						defer pipe.Close()
+
+						_, _ = pipe.Write(parts["some-entry.tgz.part1"])
+					}()
+
+					transport.DropCall.Stub = func(root, uri string) (io.ReadCloser, error) {
+						if uri == "some-entry.tgz.part1" {
+							return os.Open(pipePath)
+						}
+
+						return io.NopCloser(bytes.NewReader(parts[uri])), nil
+					}
+				})
+
+				it.After(func() {
+					Expect(os.RemoveAll(pipeDir)).To(Succeed())
+				})
+
+				it("keeps the bytes read from the pipe and unpackages every part into the path", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:       "some-entry",
+							Stacks:   []string{"some-stack"},
+							URI:      "some-entry.tgz.part1",
+							URIParts: []string{"some-entry.tgz.part2"},
+							SHA256:   dependencyHash,
+							Version:  "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(files).To(ConsistOf([]string{
+						filepath.Join(layerPath, "first"),
+						filepath.Join(layerPath, "second"),
+						filepath.Join(layerPath, "third"),
+						filepath.Join(layerPath, "some-dir"),
+						filepath.Join(layerPath, "symlink"),
+					}))
+				})
+			})
+
+			context("when a part cannot be fetched", func() {
+				it("returns an error", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:       "some-entry",
+							Stacks:   []string{"some-stack"},
+							URI:      "some-entry.tgz.part1",
+							URIParts: []string{"some-entry.tgz.part3"},
+							SHA256:   dependencyHash,
+							Version:  "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+					Expect(err).To(MatchError(ContainSubstring(`failed to fetch dependency part "some-entry.tgz.part3": unknown uri "some-entry.tgz.part3"`)))
+				})
+			})
+		})
+
+		context("when the layer path does not exist", func() {
+			var missingLayerPath string
+
+			it.Before(func() {
+				missingLayerPath = filepath.Join(layerPath, "some-parent", "some-layer")
+			})
+
+			context("when the create layer dir option is given", func() {
+				it("creates the layer path before unpackaging into it", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						missingLayerPath,
+						"some-platform-dir",
+						postal.WithCreateLayerDir(),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					info, err := os.Stat(missingLayerPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.IsDir()).To(BeTrue())
+					Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+					Expect(filepath.Join(missingLayerPath, "first")).To(BeARegularFile())
+					Expect(filepath.Join(missingLayerPath, "some-dir", "some-file")).To(BeARegularFile())
+				})
+			})
+		})
+
+		context("when the deduplication option is given", func() {
+			var deliverDeduplicated func() error
+
+			it.Before(func() {
+				content, err := io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+
+				transport.DropCall.Stub = func(string, string) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(content)), nil
+				}
+
+				deliverDeduplicated = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+						postal.WithDeduplication(),
+					)
+				}
+			})
+
+			it("does not fetch the dependency again once it has been delivered to the layer", func() {
+				Expect(deliverDeduplicated()).To(Succeed())
+				Expect(transport.DropCall.CallCount).To(Equal(1))
+
+				content, err := os.ReadFile(filepath.Join(layerPath, postal.DeliveryChecksumFile))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal(fmt.Sprintf("sha256:%s", dependencyHash)))
+
+				Expect(deliverDeduplicated()).To(Succeed())
+				Expect(transport.DropCall.CallCount).To(Equal(1))
+
+				Expect(filepath.Join(layerPath, "first")).To(BeARegularFile())
+			})
+
+			context("when the layer only holds the checksum file", func() {
+				it.Before(func() {
+					err := os.WriteFile(filepath.Join(layerPath, postal.DeliveryChecksumFile), []byte(fmt.Sprintf("sha256:%s", dependencyHash)), 0644)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				it("fetches the dependency", func() {
+					Expect(deliverDeduplicated()).To(Succeed())
+					Expect(transport.DropCall.CallCount).To(Equal(1))
+					Expect(filepath.Join(layerPath, "first")).To(BeARegularFile())
+				})
+			})
+
+			context("when the option is not given", func() {
+				it("fetches the dependency without writing the checksum file", func() {
+					Expect(deliver()).To(Succeed())
+					Expect(transport.DropCall.CallCount).To(Equal(1))
+
+					Expect(filepath.Join(layerPath, postal.DeliveryChecksumFile)).NotTo(BeAnExistingFile())
+				})
+			})
+		})
+
+		context("when the dependency has an ephemeral uri", func() {
+			var dependency postal.Dependency
+
+			it.Before(func() {
+				content, err := io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+
+				transport.DropCall.Stub = func(string, string) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(content)), nil
+				}
+
+				dependency = postal.Dependency{
+					ID:           "some-entry",
+					Stacks:       []string{"some-stack"},
+					URI:          "some-entry.tgz?signature=some-signature",
+					EphemeralURI: true,
+					SHA256:       dependencyHash,
+					Version:      "1.2.3",
+				}
+			})
+
+			it("fetches the dependency from the transport every time", func() {
+				Expect(service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithDeduplication())).To(Succeed())
+				Expect(service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithDeduplication())).To(Succeed())
+
+				Expect(transport.DropCall.CallCount).To(Equal(2))
+				Expect(filepath.Join(layerPath, "first")).To(BeARegularFile())
+			})
+
+			it("does not record the uri in the delivery manifest", func() {
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithDeliveryManifest())
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, postal.DeliveryManifestFile))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).NotTo(ContainSubstring("some-signature"))
+
+				var manifest postal.DeliveryManifest
+				Expect(json.Unmarshal(content, &manifest)).To(Succeed())
+				Expect(manifest.URI).To(BeEmpty())
+				Expect(manifest.DownloadedFrom).To(BeEmpty())
+				Expect(manifest.ValidatedChecksum).To(Equal(fmt.Sprintf("sha256:%s", dependencyHash)))
+			})
+		})
+
+		context("when the dry run option is given", func() {
+			it("validates the dependency without writing to the layer path", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithDryRun(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.CallCount).To(Equal(1))
+
+				entries, err := os.ReadDir(layerPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(BeEmpty())
+			})
+
+			context("when the archive is corrupt", func() {
+				it.Before(func() {
+					content, err := io.ReadAll(transport.DropCall.Returns.ReadCloser)
+					Expect(err).NotTo(HaveOccurred())
+
+					corrupt := content[:len(content)/2]
+					transport.DropCall.Returns.ReadCloser = io.NopCloser(bytes.NewReader(corrupt))
+
+					sum := sha256.Sum256(corrupt)
+					dependencyHash = hex.EncodeToString(sum[:])
+				})
+
+				it("returns an error", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+						postal.WithDryRun(),
+					)
+					Expect(err).To(HaveOccurred())
+
+					entries, err := os.ReadDir(layerPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(entries).To(BeEmpty())
+				})
+			})
+		})
+
+		context("when the dependency has additional checksums", func() {
+			var checksums map[string]string
+
+			it.Before(func() {
+				checksums = map[string]string{
+					"sha256": dependencyHash,
+					"sha512": hash512,
+				}
+
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:        "some-entry",
+							Stacks:    []string{"some-stack"},
+							URI:       "some-entry.tgz",
+							Checksum:  fmt.Sprintf("sha256:%s", dependencyHash),
+							Checksums: checksums,
+							Version:   "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+				}
+			})
+
+			it("validates every checksum", func() {
+				Expect(deliver()).To(Succeed())
+				Expect(filepath.Join(layerPath, "first")).To(BeARegularFile())
+			})
+
+			context("when one of the additional checksums does not match", func() {
+				it.Before(func() {
+					checksums["sha512"] = strings.Repeat("0", 128)
+				})
+
+				it("returns an error", func() {
+					err := deliver()
+					Expect(err).To(MatchError(ContainSubstring("checksum does not match")))
+					Expect(errors.Is(err, postal.ErrChecksumMismatch)).To(BeTrue())
+				})
+			})
+
+			context("when an additional checksum uses an unsupported algorithm", func() {
+				it.Before(func() {
+					checksums["md5"] = "some-md5"
+				})
+
+				it("returns an error", func() {
+					err := deliver()
+					Expect(err).To(MatchError(`failed to validate dependency: unsupported algorithm "md5": the following algorithms are supported [sha256, sha512]`))
+				})
+			})
+		})
+
+		context("when a file permission map is given", func() {
+			it("applies the permissions to the mapped files", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithFilePermissionMap(map[string]os.FileMode{
+						"some-dir/some-file": 0600,
+						"some-missing-file":  0700,
+					}),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				info, err := os.Stat(filepath.Join(layerPath, "some-dir", "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0600)))
+
+				info, err = os.Stat(filepath.Join(layerPath, "first"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+			})
+		})
+
+		context("when the dependency is an installer script", func() {
+			var (
+				script     []byte
+				scriptHash string
+			)
+
+			it.Before(func() {
+				script = []byte(`#!/bin/sh
+set -e
+[ "$1" = "--target" ]
+echo "some-content" > "$2/some-installed-file"
+`)
+				sum := sha256.Sum256(script)
+				scriptHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(bytes.NewBuffer(script))
+			})
+
+			it("runs the installer targeting the layer path", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-sdk",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-sdk-installer.run",
+						SHA256:  scriptHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "some-installed-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-content\n"))
+			})
+
+			context("when the installer does not match the checksum", func() {
+				it("does not run the installer", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-sdk",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-sdk-installer.sh",
+							SHA256:  strings.Repeat("0", 64),
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+					Expect(err).To(MatchError(postal.ErrChecksumMismatch))
+
+					Expect(filepath.Join(layerPath, "some-installed-file")).NotTo(BeAnExistingFile())
+				})
+			})
+
+			context("when the installer fails", func() {
+				it.Before(func() {
+					script = []byte("#!/bin/sh\necho 'some-installer-error'\nexit 1\n")
+					sum := sha256.Sum256(script)
+					scriptHash = hex.EncodeToString(sum[:])
+
+					transport.DropCall.Returns.ReadCloser = io.NopCloser(bytes.NewBuffer(script))
+				})
+
+				it("returns an error", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-sdk",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-sdk-installer.sh",
+							SHA256:  scriptHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+					Expect(err).To(MatchError("failed to run installer: exit status 1: some-installer-error"))
+				})
+			})
+		})
+
+		context("when the dependency is a python wheel", func() {
+			var wheelHash string
+
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := zip.NewWriter(buffer)
+
+				for _, file := range []string{
+					"some_package/__init__.py",
+					"some_package/some_module.py",
+					"some_package-1.2.3.dist-info/METADATA",
+					"some_package-1.2.3.dist-info/RECORD",
+				} {
+					w, err := zw.Create(file)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = w.Write([]byte(file))
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				wheelHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+			})
+
+			it("extracts the source files and moves the metadata under .dist-info", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-package",
+						Stacks:  []string{"some-stack"},
+						URI:     "some_package-1.2.3-py3-none-any.whl",
+						SHA256:  wheelHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(filepath.Join(layerPath, "*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, ".dist-info"),
+					filepath.Join(layerPath, "some_package"),
+				}))
+
+				Expect(filepath.Join(layerPath, "some_package", "__init__.py")).To(BeARegularFile())
+				Expect(filepath.Join(layerPath, "some_package", "some_module.py")).To(BeARegularFile())
+				Expect(filepath.Join(layerPath, ".dist-info", "some_package-1.2.3.dist-info", "METADATA")).To(BeARegularFile())
+				Expect(filepath.Join(layerPath, ".dist-info", "some_package-1.2.3.dist-info", "RECORD")).To(BeARegularFile())
+			})
+		})
+
+		context("when file rename rules are given", func() {
+			var archiveHash string
+
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "./bin", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+
+				for _, file := range []string{"./bin/ruby3.2", "./bin/gem3.2", "./bin/irb"} {
+					Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+					_, err := tw.Write([]byte(file))
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				archiveHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+			})
+
+			it("renames the matching files", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "ruby",
+						Stacks:  []string{"some-stack"},
+						URI:     "ruby.tgz",
+						SHA256:  archiveHash,
+						Version: "3.2.0",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithFileRenameRule("ruby3.2", "ruby"),
+					postal.WithFileRenameRule("gem*", "gem"),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(filepath.Join(layerPath, "bin", "*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "bin", "ruby"),
+					filepath.Join(layerPath, "bin", "gem"),
+					filepath.Join(layerPath, "bin", "irb"),
+				}))
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "bin", "ruby"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("./bin/ruby3.2"))
+			})
+
+			context("when a rule has a malformed pattern", func() {
+				it("returns an error", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "ruby",
+							Stacks:  []string{"some-stack"},
+							URI:     "ruby.tgz",
+							SHA256:  archiveHash,
+							Version: "3.2.0",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+						postal.WithFileRenameRule("[", "ruby"),
+					)
+					Expect(err).To(MatchError(ContainSubstring("failed to rename files: syntax error in pattern")))
+				})
+			})
+		})
+
+		context("when a slog logger is given", func() {
+			var (
+				buffer *bytes.Buffer
+				logger *slog.Logger
+			)
+
+			it.Before(func() {
+				buffer = bytes.NewBuffer(nil)
+				logger = slog.New(slog.NewJSONHandler(buffer, nil))
+			})
+
+			it("logs structured events for the download", func() {
+				service = service.WithSlogLogger(logger)
+				Expect(deliver()).To(Succeed())
+
+				var records []map[string]interface{}
+				decoder := json.NewDecoder(buffer)
+				for decoder.More() {
+					var record map[string]interface{}
+					Expect(decoder.Decode(&record)).To(Succeed())
+					records = append(records, record)
+				}
+
+				Expect(records).To(HaveLen(2))
+
+				Expect(records[0]).To(HaveKeyWithValue("level", "INFO"))
+				Expect(records[0]).To(HaveKeyWithValue("msg", "dependency.download.start"))
+				Expect(records[0]).To(HaveKeyWithValue("dependency.id", "some-entry"))
+				Expect(records[0]).To(HaveKeyWithValue("dependency.version", "1.2.3"))
+				Expect(records[0]).To(HaveKeyWithValue("dependency.uri", "some-entry.tgz"))
+
+				Expect(records[1]).To(HaveKeyWithValue("level", "INFO"))
+				Expect(records[1]).To(HaveKeyWithValue("msg", "dependency.download.complete"))
+				Expect(records[1]).To(HaveKeyWithValue("dependency.id", "some-entry"))
+				Expect(records[1]).To(HaveKeyWithValue("dependency.uri", "some-entry.tgz"))
+				Expect(records[1]).To(HaveKey("download.bytes"))
+				Expect(records[1]).To(HaveKey("download.duration_ms"))
+			})
+
+			context("when the dependency cannot be fetched", func() {
+				it.Before(func() {
+					transport.DropCall.Returns.Error = errors.New("some-error")
+				})
+
+				it("logs a failure event", func() {
+					service = service.WithSlogLogger(logger)
+					Expect(deliver()).NotTo(Succeed())
+
+					Expect(buffer.String()).To(ContainSubstring(`"level":"ERROR","msg":"dependency.download.failed"`))
+					Expect(buffer.String()).To(ContainSubstring(`"error":"failed to fetch dependency: some-error"`))
+				})
+			})
+		})
+
+		context("DeliverWithStats", func() {
+			it("returns statistics about the extracted contents", func() {
+				stats, err := service.DeliverWithStats(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stats).To(Equal(postal.DeliveryStats{
+					FilesWritten:    4,
+					BytesWritten:    int64(len("./some-dir/some-file") + len("./first") + len("./second") + len("./third")),
+					DirsCreated:     1,
+					SymlinksCreated: 1,
+				}))
+			})
+
+			context("when the layer already holds other contents", func() {
+				it.Before(func() {
+					Expect(os.MkdirAll(filepath.Join(layerPath, "some-other-dir"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(layerPath, "some-other-dir", "some-other-file"), []byte("some-other-content"), 0644)).To(Succeed())
+				})
+
+				it("only counts the contents extracted by the delivery", func() {
+					stats, err := service.DeliverWithStats(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(stats).To(Equal(postal.DeliveryStats{
+						FilesWritten:    4,
+						BytesWritten:    int64(len("./some-dir/some-file") + len("./first") + len("./second") + len("./third")),
+						DirsCreated:     1,
+						SymlinksCreated: 1,
+					}))
+				})
+			})
+		})
+
+		context("when content type verification is enabled", func() {
+			it.Before(func() {
+				service = service.WithContentTypeVerification([]string{"application/x-gzip"})
+			})
+
+			it("delivers dependencies with an allowed content type", func() {
+				Expect(deliver()).To(Succeed())
+
+				Expect(filepath.Join(layerPath, "first")).To(BeARegularFile())
+			})
+
+			context("when the dependency has a content type that is not allowed", func() {
+				var page []byte
+
+				it.Before(func() {
+					page = []byte("<!DOCTYPE html><html><body>Service Unavailable</body></html>")
+					transport.DropCall.Returns.ReadCloser = io.NopCloser(bytes.NewBuffer(page))
+				})
+
+				it("returns an error", func() {
+					sum := sha256.Sum256(page)
+
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  hex.EncodeToString(sum[:]),
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+					Expect(err).To(MatchError(postal.ErrUnexpectedContentType))
+					Expect(err).To(MatchError(ContainSubstring("text/html; charset=utf-8")))
+
+					files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(files).To(BeEmpty())
+				})
+			})
+		})
+
+		context("when the delivery manifest option is given", func() {
+			it("writes a manifest describing the delivery", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Name:    "Some Entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithDeliveryManifest(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, postal.DeliveryManifestFile))
+				Expect(err).NotTo(HaveOccurred())
+
+				var fields map[string]interface{}
+				Expect(json.Unmarshal(content, &fields)).To(Succeed())
+				Expect(fields).To(HaveKey("deliveredAt"))
+				Expect(fields).To(HaveKeyWithValue("downloadedFrom", "some-entry.tgz"))
+				Expect(fields).To(HaveKeyWithValue("validatedChecksum", fmt.Sprintf("sha256:%s", dependencyHash)))
+				Expect(fields).NotTo(HaveKey("checksum"))
+
+				var manifest postal.DeliveryManifest
+				Expect(json.Unmarshal(content, &manifest)).To(Succeed())
+				Expect(manifest.Dependency).To(Equal(postal.Dependency{
+					ID:      "some-entry",
+					Name:    "Some Entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "some-entry.tgz",
+					SHA256:  dependencyHash,
+					Version: "1.2.3",
+				}))
+				Expect(manifest.DownloadedFrom).To(Equal("some-entry.tgz"))
+				Expect(manifest.ValidatedChecksum).To(Equal(fmt.Sprintf("sha256:%s", dependencyHash)))
+				Expect(manifest.DeliveredAt).To(BeTemporally("~", time.Now(), time.Minute))
+			})
+		})
+
+		context("when the dependency has a strip-components value set", func() {
+			it.Before(func() {
+				var err error
+				layerPath, err = os.MkdirTemp("", "path")
+				Expect(err).NotTo(HaveOccurred())
+
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-dir", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+				_, err = tw.Write(nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				nestedFile := "some-dir/some-file"
+				Expect(tw.WriteHeader(&tar.Header{Name: nestedFile, Mode: 0755, Size: int64(len(nestedFile))})).To(Succeed())
+				_, err = tw.Write([]byte(nestedFile))
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, file := range []string{"some-dir/first", "some-dir/second", "some-dir/third"} {
+					Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+					_, err = tw.Write([]byte(file))
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				linkName := "some-dir/symlink"
+				linkDest := "./first"
+				Expect(tw.WriteHeader(&tar.Header{Name: linkName, Mode: 0777, Size: int64(0), Typeflag: tar.TypeSymlink, Linkname: linkDest})).To(Succeed())
+				_, err = tw.Write([]byte{})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				dependencyHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:              "some-entry",
+							Stacks:          []string{"some-stack"},
+							URI:             "some-entry.tgz",
+							SHA256:          dependencyHash,
+							Version:         "1.2.3",
+							StripComponents: 1,
+						},
+						"some-cnb-path",
+						layerPath,
+						"",
+					)
+				}
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(layerPath)).To(Succeed())
+			})
+
+			it("downloads the dependency, strips given number of componenets and unpackages it into the path", func() {
+				err := deliver()
+
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+				Expect(transport.DropCall.Receives.Uri).To(Equal("some-entry.tgz"))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "first"),
+					filepath.Join(layerPath, "second"),
+					filepath.Join(layerPath, "third"),
+					filepath.Join(layerPath, "symlink"),
+					filepath.Join(layerPath, "some-file"),
+				}))
+
+				info, err := os.Stat(filepath.Join(layerPath, "first"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+			})
+		})
+
+		context("when the dependency should be a named file", func() {
+			it.Before(func() {
+				var err error
+				layerPath, err = os.MkdirTemp("", "path")
+				Expect(err).NotTo(HaveOccurred())
+
+				buffer := bytes.NewBuffer(nil)
+				buffer.WriteString("some-file-contents")
+
+				sum := sha256.Sum256(buffer.Bytes())
+				dependencyHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "https://dependencies.example.com/dependencies/some-file-name.txt",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+				}
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(layerPath)).To(Succeed())
+			})
+
+			it("downloads the dependency and copies it into the path with the given name", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+				Expect(transport.DropCall.Receives.Uri).To(Equal("https://dependencies.example.com/dependencies/some-file-name.txt"))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{filepath.Join(layerPath, "some-file-name.txt")}))
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "some-file-name.txt"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-file-contents"))
+			})
+		})
+
+		context("when the dependency is a single gzip compressed file", func() {
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+
+				_, err := zw.Write([]byte("some-tool-contents"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				dependencyHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "https://dependencies.example.com/dependencies/some-tool.gz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+				}
+			})
+
+			it("decompresses the file into the path without the .gz suffix", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{filepath.Join(layerPath, "some-tool")}))
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "some-tool"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-tool-contents"))
+			})
+		})
+
+		context("when the dependency is an rpm package", func() {
+			it.Before(func() {
+				cpio := bytes.NewBuffer(nil)
+				for i, entry := range []struct{ name, content string }{
+					{"./usr/bin/some-tool\x00", "some-tool-contents"},
+					{"TRAILER!!!\x00", ""},
+				} {
+					fmt.Fprintf(cpio, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+						i+1, 0100755, 0, 0, 1, 0, len(entry.content), 0, 0, 0, 0, len(entry.name), 0)
+					cpio.WriteString(entry.name)
+					cpio.Write(make([]byte, (4-(110+len(entry.name))%4)%4))
+					cpio.WriteString(entry.content)
+					cpio.Write(make([]byte, (4-len(entry.content)%4)%4))
+				}
+
+				buffer := bytes.NewBuffer(nil)
+				lead := make([]byte, 96)
+				copy(lead, []byte{0xed, 0xab, 0xee, 0xdb})
+				buffer.Write(lead)
+				for i := 0; i < 2; i++ {
+					buffer.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+				}
+
+				zw := gzip.NewWriter(buffer)
+				_, err := zw.Write(cpio.Bytes())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				dependencyHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "https://dependencies.example.com/dependencies/some-tool.rpm",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+				}
+			})
+
+			it("extracts the payload of the package into the layer path", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "usr", "bin", "some-tool"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-tool-contents"))
+			})
+		})
+
+		context("when the dependency is a deb package", func() {
+			it.Before(func() {
+				data := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(data)
+				tw := tar.NewWriter(zw)
+
+				file := "./usr/bin/some-tool"
+				Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+				_, err := tw.Write([]byte(file))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				buffer := bytes.NewBufferString("!<arch>\n")
+				for _, member := range []struct {
+					name    string
+					content []byte
+				}{
+					{"debian-binary", []byte("2.0\n")},
+					{"control.tar.gz", []byte("some-control")},
+					{"data.tar.gz", data.Bytes()},
+				} {
+					fmt.Fprintf(buffer, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name+"/", 0, 0, 0, "100644", len(member.content))
+					buffer.Write(member.content)
+					if len(member.content)%2 != 0 {
+						buffer.WriteString("\n")
+					}
+				}
+
+				sum := sha256.Sum256(buffer.Bytes())
+				dependencyHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "https://dependencies.example.com/dependencies/some-tool.deb",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+				}
+			})
+
+			it("extracts the data member of the package into the layer path", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "usr", "bin", "some-tool"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("./usr/bin/some-tool"))
+			})
+		})
+
+		context("when the file ownership option is given", func() {
+			var uid, gid int
+
+			it.Before(func() {
+				uid, gid = 1234, 5678
+
+				// Changing ownership to an arbitrary user requires root, so fall back
+				// to the current user when running unprivileged
+				if os.Getuid() != 0 {
+					uid, gid = os.Getuid(), os.Getgid()
+				}
+			})
+
+			it("changes the ownership of every extracted file, directory, and symlink", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithFileOwnership(uid, gid),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, name := range []string{"first", "second", "third", "some-dir", "some-dir/some-file", "symlink"} {
+					info, err := os.Lstat(filepath.Join(layerPath, name))
+					Expect(err).NotTo(HaveOccurred())
+
+					stat, ok := info.Sys().(*syscall.Stat_t)
+					Expect(ok).To(BeTrue())
+					Expect(int(stat.Uid)).To(Equal(uid), name)
+					Expect(int(stat.Gid)).To(Equal(gid), name)
+				}
+			})
+		})
+
+		context("when the minimum file count option is given", func() {
+			var deliverWithOptions func(...postal.DeliverOption) error
+
+			it.Before(func() {
+				deliverWithOptions = func(options ...postal.DeliverOption) error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+						options...,
+					)
+				}
+			})
+
+			context("when enough files are extracted", func() {
+				it("delivers the dependency", func() {
+					Expect(deliverWithOptions(postal.WithMinFileCount(5))).To(Succeed())
+				})
+			})
+
+			context("when too few files are extracted", func() {
+				it("returns a typed error with the actual and expected counts", func() {
+					err := deliverWithOptions(postal.WithMinFileCount(6))
+
+					var insufficientFilesErr *postal.ErrInsufficientFiles
+					Expect(errors.As(err, &insufficientFilesErr)).To(BeTrue())
+					Expect(insufficientFilesErr.Actual).To(Equal(5))
+					Expect(insufficientFilesErr.Expected).To(Equal(6))
+					Expect(err).To(MatchError("failed to validate dependency: extracted 5 files, expected at least 6"))
+				})
+			})
+
+			context("when the layer already holds other files", func() {
+				it.Before(func() {
+					for _, name := range []string{"some-other-file", "another-file", "yet-another-file"} {
+						Expect(os.WriteFile(filepath.Join(layerPath, name), []byte("some-content"), 0644)).To(Succeed())
+					}
+				})
+
+				it("only counts the files that were extracted", func() {
+					err := deliverWithOptions(postal.WithMinFileCount(6))
+
+					var insufficientFilesErr *postal.ErrInsufficientFiles
+					Expect(errors.As(err, &insufficientFilesErr)).To(BeTrue())
+					Expect(insufficientFilesErr.Actual).To(Equal(5))
+				})
+			})
+		})
+
+		context("when the dependency declares required files", func() {
+			it("delivers the dependency when every required file is extracted", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:            "some-entry",
+						Stacks:        []string{"some-stack"},
+						URI:           "some-entry.tgz",
+						SHA256:        dependencyHash,
+						Version:       "1.2.3",
+						RequiredFiles: []string{"first", "some-dir/some-file"},
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			context("when a required file is missing", func() {
+				it("returns a typed error naming the missing files", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:            "some-entry",
+							Stacks:        []string{"some-stack"},
+							URI:           "some-entry.tgz",
+							SHA256:        dependencyHash,
+							Version:       "1.2.3",
+							RequiredFiles: []string{"some-dir/some-file", "some-dir/some-missing-file"},
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+
+					var missingRequiredFileErr *postal.ErrMissingRequiredFile
+					Expect(errors.As(err, &missingRequiredFileErr)).To(BeTrue())
+					Expect(missingRequiredFileErr.Paths).To(Equal([]string{"some-dir/some-missing-file"}))
+					Expect(err).To(MatchError("failed to validate dependency: missing required files [some-dir/some-missing-file]"))
+				})
+			})
+		})
+
+		context("when the dependency declares required capabilities", func() {
+			var checked []string
+
+			it.Before(func() {
+				checked = nil
+				service = service.WithCapabilityChecker(func(capability string) bool {
+					checked = append(checked, capability)
+					return false
+				})
+			})
+
+			it("returns an error without fetching the dependency", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:                   "some-entry",
+						Stacks:               []string{"some-stack"},
+						URI:                  "some-entry.tgz",
+						SHA256:               dependencyHash,
+						Version:              "1.2.3",
+						RequiredCapabilities: []string{"CAP_NET_ADMIN", "CAP_SYS_PTRACE"},
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).To(MatchError(postal.ErrCapabilityMissing))
+				Expect(err).To(MatchError(ContainSubstring("[CAP_NET_ADMIN, CAP_SYS_PTRACE]")))
+
+				Expect(checked).To(Equal([]string{"CAP_NET_ADMIN", "CAP_SYS_PTRACE"}))
+				Expect(transport.DropCall.CallCount).To(Equal(0))
+			})
+
+			context("when the dependency does not declare any capabilities", func() {
+				it("delivers the dependency", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(checked).To(BeEmpty())
+				})
+			})
+		})
+
+		context("when the preserve timestamps option is given", func() {
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				file := "some-file"
+				Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file)), ModTime: time.Unix(0, 0)})).To(Succeed())
+				_, err := tw.Write([]byte(file))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				dependencyHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+			})
+
+			it("sets the modification time of the extracted files from the archive", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithPreserveTimestamps(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				info, err := os.Stat(filepath.Join(layerPath, "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.ModTime().Unix()).To(Equal(int64(0)))
+			})
+		})
+
+		context("when the dependency is a lz4 compressed tarball", func() {
+			var dependency postal.Dependency
+
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				lz4w := lz4.NewWriter(buffer)
+				tw := tar.NewWriter(lz4w)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-dir", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+
+				for _, file := range []string{"some-dir/some-file", "some-dir/other-file"} {
+					Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+					_, err := tw.Write([]byte(file))
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(lz4w.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				dependency = postal.Dependency{
+					ID:      "some-entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "some-entry.tar.lz4",
+					SHA256:  hex.EncodeToString(sum[:]),
+					Version: "1.2.3",
+				}
+			})
+
+			it("decompresses the dependency into the layer path", func() {
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "some-dir", "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-dir/some-file"))
+				Expect(filepath.Join(layerPath, "some-dir", "other-file")).To(BeARegularFile())
+			})
+
+			it("strips the given number of components", func() {
+				dependency.StripComponents = 1
+
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(filepath.Join(layerPath, "some-file")).To(BeARegularFile())
+				Expect(filepath.Join(layerPath, "other-file")).To(BeARegularFile())
+				Expect(filepath.Join(layerPath, "some-dir")).NotTo(BeAnExistingFile())
+			})
+
+			context("when the dependency does not match its checksum", func() {
+				it("returns an error", func() {
+					dependency.SHA256 = strings.Repeat("0", 64)
+
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+					Expect(err).To(MatchError(postal.ErrChecksumMismatch))
+				})
+			})
+		})
+
+		context("when the max symlink depth option is given", func() {
+			var dependency postal.Dependency
+
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0755, Size: int64(len("some-file"))})).To(Succeed())
+				_, err := tw.Write([]byte("some-file"))
+				Expect(err).NotTo(HaveOccurred())
+
+				// A chain of 5 symlinks: link-5 -> link-4 -> ... -> link-1 -> some-file
+				target := "some-file"
+				for i := 1; i <= 5; i++ {
+					name := fmt.Sprintf("link-%d", i)
+					Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: target})).To(Succeed())
+					target = name
+				}
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				dependency = postal.Dependency{
+					ID:      "some-entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "some-entry.tgz",
+					SHA256:  hex.EncodeToString(sum[:]),
+					Version: "1.2.3",
+				}
+			})
+
+			it("delivers the dependency when the symlinks are within the depth", func() {
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithMaxSymlinkDepth(5))
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "link-5"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-file"))
+			})
+
+			context("when a symlink chain is deeper than the max depth", func() {
+				it("returns an error", func() {
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithMaxSymlinkDepth(4))
+					Expect(err).To(MatchError(postal.ErrSymlinkDepthExceeded))
+					Expect(err).To(MatchError(ContainSubstring("link-5 links more than 4 times")))
+				})
+			})
+		})
+
+		context("when the check verification option is given", func() {
+			var (
+				dependency postal.Dependency
+				content    []byte
+			)
+
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				tw := tar.NewWriter(buffer)
+
+				// The archive is larger than the 64 KiB at each end that is
+				// hashed by fast verification
+				fileContent := bytes.Repeat([]byte("some-content"), 20000)
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0644, Size: int64(len(fileContent))})).To(Succeed())
+				_, err := tw.Write(fileContent)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+
+				content = buffer.Bytes()
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				sum := sha256.Sum256(content)
+				dependency = postal.Dependency{
+					ID:      "some-entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "some-entry.tar",
+					SHA256:  hex.EncodeToString(sum[:]),
+					Version: "1.2.3",
+				}
+			})
+
+			context("when the level is fast", func() {
+				it.Before(func() {
+					head := content[:64*1024]
+					tail := content[len(content)-64*1024:]
+					sum := sha256.Sum256(append(append([]byte{}, head...), tail...))
+					dependency.FastChecksum = fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:]))
+				})
+
+				it("validates the head and tail of the dependency against its fast checksum", func() {
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithCheckVerification("fast"))
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(filepath.Join(layerPath, "some-file")).To(BeARegularFile())
+				})
+
+				it("hashes only the first and last 64 KiB of the dependency", func() {
+					// Corrupt a byte of the file content between the head and tail
+					corrupt := append([]byte{}, content...)
+					corrupt[len(corrupt)/2] = '!'
+					transport.DropCall.Returns.ReadCloser = io.NopCloser(bytes.NewReader(corrupt))
+
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithCheckVerification("fast"))
+					Expect(err).NotTo(HaveOccurred())
+
+					fileContent, err := os.ReadFile(filepath.Join(layerPath, "some-file"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(fileContent)).To(ContainSubstring("!"))
+				})
+
+				context("when the head or tail does not match the fast checksum", func() {
+					it("returns an error", func() {
+						dependency.FastChecksum = fmt.Sprintf("sha256:%s", strings.Repeat("0", 64))
+
+						err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithCheckVerification("fast"))
+						Expect(err).To(MatchError(postal.ErrChecksumMismatch))
+					})
+				})
+
+				context("when the dependency does not declare a fast checksum", func() {
+					it("returns an error", func() {
+						dependency.FastChecksum = ""
+
+						err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithCheckVerification("fast"))
+						Expect(err).To(MatchError("failed to validate dependency: the fast verification level requires a fast-checksum"))
+					})
+				})
+			})
+
+			context("when the level is skip", func() {
+				it("does not validate the dependency", func() {
+					dependency.SHA256 = strings.Repeat("0", 64)
+
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithCheckVerification("skip"))
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(filepath.Join(layerPath, "some-file")).To(BeARegularFile())
+				})
+			})
+
+			context("when the level is set on the dependency", func() {
+				it("uses the level of the dependency", func() {
+					dependency.SHA256 = strings.Repeat("0", 64)
+					dependency.VerificationLevel = "skip"
+
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				it("is overridden by the option", func() {
+					dependency.SHA256 = strings.Repeat("0", 64)
+					dependency.VerificationLevel = "skip"
+
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithCheckVerification("full"))
+					Expect(err).To(MatchError(postal.ErrChecksumMismatch))
+				})
+			})
+
+			context("when the level is not supported", func() {
+				it("returns an error", func() {
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithCheckVerification("some-level"))
+					Expect(err).To(MatchError(`unsupported verification level "some-level": the following levels are supported [full, fast, skip]`))
+				})
+			})
+		})
+
+		context("when the dependency is a brotli compressed tar archive", func() {
+			var dependency postal.Dependency
+
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				bw := brotli.NewWriter(buffer)
+				tw := tar.NewWriter(bw)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-dir", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+
+				nestedFile := "some-dir/some-file"
+				Expect(tw.WriteHeader(&tar.Header{Name: nestedFile, Mode: 0755, Size: int64(len(nestedFile))})).To(Succeed())
+				_, err := tw.Write([]byte(nestedFile))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(bw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				dependency = postal.Dependency{
+					ID:      "some-entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "some-entry.tar.br",
+					SHA256:  hex.EncodeToString(sum[:]),
+					Version: "1.2.3",
+				}
+			})
+
+			it("decompresses and extracts the dependency", func() {
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "some-dir", "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-dir/some-file"))
+			})
+
+			context("when the dependency has a name", func() {
+				it.Before(func() {
+					dependency.Name = "some-entry"
+				})
+
+				it("decompresses and extracts the dependency", func() {
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+					Expect(err).NotTo(HaveOccurred())
+
+					content, err := os.ReadFile(filepath.Join(layerPath, "some-dir", "some-file"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal("some-dir/some-file"))
+				})
+			})
+
+			context("when the dependency is mapped to a uri without a .br extension", func() {
+				it.Before(func() {
+					mappingResolver.FindDependencyMappingCall.Returns.String = "dependency-mapping-entry"
+				})
+
+				it("decompresses and extracts the dependency", func() {
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(transport.DropCall.Receives.Uri).To(Equal("dependency-mapping-entry"))
+
+					content, err := os.ReadFile(filepath.Join(layerPath, "some-dir", "some-file"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal("some-dir/some-file"))
+				})
+			})
+
+			context("when the dependency does not match its checksum", func() {
+				it("returns an error", func() {
+					dependency.SHA256 = strings.Repeat("0", 64)
+
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+					Expect(err).To(MatchError(postal.ErrChecksumMismatch))
+				})
+			})
+		})
+
+		context("when the prometheus push url option is given", func() {
+			var (
+				server         *httptest.Server
+				requests       chan *http.Request
+				bodies         chan string
+				dependencySize int
+			)
+
+			it.Before(func() {
+				requests = make(chan *http.Request, 1)
+				bodies = make(chan string, 1)
+
+				content, err := io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+
+				dependencySize = len(content)
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(bytes.NewReader(content))
+
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					body, err := io.ReadAll(req.Body)
+					Expect(err).NotTo(HaveOccurred())
+
+					requests <- req
+					bodies <- string(body)
+				}))
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("pushes the delivery metrics to the push gateway", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithPrometheusPushURL(server.URL+"/metrics/job/some-job"),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				var req *http.Request
+				Eventually(requests).Should(Receive(&req))
+				Expect(req.Method).To(Equal("POST"))
+				Expect(req.URL.Path).To(Equal("/metrics/job/some-job"))
+				Expect(req.Header.Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+
+				var body string
+				Eventually(bodies).Should(Receive(&body))
+				Expect(body).To(ContainSubstring("# TYPE postal_delivery_bytes_total counter\n"))
+				Expect(body).To(ContainSubstring(fmt.Sprintf(`postal_delivery_bytes_total{id="some-entry",version="1.2.3"} %d`, dependencySize) + "\n"))
+				Expect(body).To(ContainSubstring("# TYPE postal_delivery_duration_seconds gauge\n"))
+				Expect(body).To(MatchRegexp(`postal_delivery_duration_seconds\{id="some-entry",version="1\.2\.3"\} \S+\n`))
+			})
+
+			context("when the push gateway cannot be reached", func() {
+				it("delivers the dependency anyway", func() {
+					server.Close()
+
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+						postal.WithPrometheusPushURL(server.URL+"/metrics/job/some-job"),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(filepath.Join(layerPath, "first")).To(BeARegularFile())
+				})
+			})
+		})
+
+		context("when the slsa provenance option is given", func() {
+			it("writes the provenance of the delivery", func() {
+				buffer := bytes.NewBuffer(nil)
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithSLSAProvenance("https://example.com/some-builder", buffer),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				var provenance struct {
+					Type          string `json:"_type"`
+					PredicateType string `json:"predicateType"`
+					Subject       []struct {
+						Name   string            `json:"name"`
+						Digest map[string]string `json:"digest"`
+					} `json:"subject"`
+					Predicate struct {
+						BuildType string `json:"buildType"`
+						Builder   struct {
+							ID string `json:"id"`
+						} `json:"builder"`
+						Invocation struct {
+							Parameters map[string]string `json:"parameters"`
+						} `json:"invocation"`
+						Metadata struct {
+							BuildFinishedOn time.Time `json:"buildFinishedOn"`
+						} `json:"metadata"`
+						Materials []struct {
+							URI    string            `json:"uri"`
+							Digest map[string]string `json:"digest"`
+						} `json:"materials"`
+					} `json:"predicate"`
+				}
+				Expect(json.Unmarshal(buffer.Bytes(), &provenance)).To(Succeed())
+
+				Expect(provenance.Type).To(Equal(postal.InTotoStatementType))
+				Expect(provenance.PredicateType).To(Equal(postal.SLSAProvenancePredicateType))
+				Expect(provenance.Subject).To(HaveLen(1))
+				Expect(provenance.Subject[0].Name).To(Equal("some-entry"))
+				Expect(provenance.Subject[0].Digest).To(Equal(map[string]string{"sha256": dependencyHash}))
+
+				Expect(provenance.Predicate.BuildType).To(Equal(postal.SLSAProvenanceBuildType))
+				Expect(provenance.Predicate.Builder.ID).To(Equal("https://example.com/some-builder"))
+				Expect(provenance.Predicate.Invocation.Parameters).To(Equal(map[string]string{
+					"id":      "some-entry",
+					"version": "1.2.3",
+				}))
+				Expect(provenance.Predicate.Metadata.BuildFinishedOn).To(BeTemporally("~", time.Now(), time.Minute))
+
+				Expect(provenance.Predicate.Materials).To(HaveLen(1))
+				Expect(provenance.Predicate.Materials[0].URI).To(Equal("some-entry.tgz"))
+				Expect(provenance.Predicate.Materials[0].Digest).To(Equal(map[string]string{"sha256": dependencyHash}))
+			})
+		})
+
+		context("when the parallel extraction option is given", func() {
+			var dependency postal.Dependency
+
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				for _, dir := range []string{"some-dir", "other-dir"} {
+					Expect(tw.WriteHeader(&tar.Header{Name: dir, Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+				}
+
+				for i := 0; i < 50; i++ {
+					name := fmt.Sprintf("some-dir/file-%d", i)
+					if i%2 == 1 {
+						name = fmt.Sprintf("other-dir/file-%d", i)
+					}
+
+					Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name))})).To(Succeed())
+					_, err := tw.Write([]byte(name))
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				dependency = postal.Dependency{
+					ID:      "some-entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "some-entry.tgz",
+					SHA256:  hex.EncodeToString(sum[:]),
+					Version: "1.2.3",
+				}
+			})
+
+			it("extracts every file of the dependency", func() {
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithParallelExtraction(4))
+				Expect(err).NotTo(HaveOccurred())
+
+				for i := 0; i < 50; i++ {
+					name := fmt.Sprintf("some-dir/file-%d", i)
+					if i%2 == 1 {
+						name = fmt.Sprintf("other-dir/file-%d", i)
+					}
+
+					content, err := os.ReadFile(filepath.Join(layerPath, name))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal(name))
+				}
+			})
+		})
+
+		context("when the extract globs option is given", func() {
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				for _, dir := range []string{"jdk", "jdk/bin", "jdk/lib", "jdk/src"} {
+					Expect(tw.WriteHeader(&tar.Header{Name: dir, Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+				}
+
+				for _, file := range []string{
+					"jdk/bin/java",
+					"jdk/bin/javac",
+					"jdk/bin/jar",
+					"jdk/lib/libjvm.so",
+					"jdk/lib/modules",
+					"jdk/src/Object.java",
+					"jdk/src/String.java",
+					"jdk/src/System.java",
+					"jdk/LICENSE",
+					"jdk/release",
+				} {
+					Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+					_, err := tw.Write([]byte(file))
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				dependencyHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+			})
+
+			it("only extracts the files that match the globs", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:              "some-entry",
+						Stacks:          []string{"some-stack"},
+						URI:             "some-entry.tgz",
+						SHA256:          dependencyHash,
+						Version:         "1.2.3",
+						StripComponents: 1,
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithExtractGlobs("bin/*"),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				var files []string
+				err = filepath.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+
+					if info.Mode().IsRegular() {
+						rel, err := filepath.Rel(layerPath, path)
+						if err != nil {
+							return err
+						}
+
+						files = append(files, rel)
+					}
+
+					return nil
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join("bin", "java"),
+					filepath.Join("bin", "javac"),
+					filepath.Join("bin", "jar"),
+				}))
+			})
+		})
+
+		context("when the content patch option is given", func() {
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "bin", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+
+				for name, content := range map[string]string{
+					"bin/some-script.sh": "#!/bin/sh\necho some-script\n",
+					"some-file":          "some-file-content",
+				} {
+					Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content))})).To(Succeed())
+					_, err := tw.Write([]byte(content))
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+				dependencyHash = hex.EncodeToString(sum[:])
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+			})
+
+			it("replaces the content of the files that the patcher returns a reader for", func() {
+				var patched []string
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithContentPatch(func(filePath string, r io.Reader) (io.Reader, error) {
+						patched = append(patched, filePath)
+						if !strings.HasSuffix(filePath, ".sh") {
+							return nil, nil
+						}
+
+						return io.MultiReader(strings.NewReader("# Copyright some-owner\n"), r), nil
+					}),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(patched).To(ConsistOf("bin/some-script.sh", "some-file"))
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "bin", "some-script.sh"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("# Copyright some-owner\n#!/bin/sh\necho some-script\n"))
+
+				info, err := os.Stat(filepath.Join(layerPath, "bin", "some-script.sh"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+
+				content, err = os.ReadFile(filepath.Join(layerPath, "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-file-content"))
+
+				files, err := filepath.Glob(filepath.Join(layerPath, "bin", "*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf(filepath.Join(layerPath, "bin", "some-script.sh")))
+			})
+
+			context("when the patcher fails", func() {
+				it("returns an error", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+						postal.WithContentPatch(func(string, io.Reader) (io.Reader, error) {
+							return nil, errors.New("failed to patch")
+						}),
+					)
+					Expect(err).To(MatchError("failed to patch files: failed to patch"))
+				})
+			})
+		})
+
+		context("when the dependency has a strip suffix", func() {
+			var dependency postal.Dependency
+
+			it.Before(func() {
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				for _, dir := range []string{"node-18.0.0", "node-18.0.0/bin", "node-18.0.0/lib/node-modules"} {
+					Expect(tw.WriteHeader(&tar.Header{Name: dir, Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+				}
+
+				for _, file := range []string{"node-18.0.0/bin/node", "node-18.0.0/lib/node-modules/some-module", "LICENSE"} {
+					Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+					_, err := tw.Write([]byte(file))
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				sum := sha256.Sum256(buffer.Bytes())
+
+				transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+				dependency = postal.Dependency{
+					ID:          "node",
+					Stacks:      []string{"some-stack"},
+					URI:         "https://dependencies.example.com/dependencies/node.tgz",
+					SHA256:      hex.EncodeToString(sum[:]),
+					Version:     "18.0.0",
+					StripSuffix: "node-*",
+				}
+			})
+
+			it("strips the matching top-level directory", func() {
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "bin"),
+					filepath.Join(layerPath, "lib"),
+					filepath.Join(layerPath, "LICENSE"),
+				}))
+
+				content, err := os.ReadFile(filepath.Join(layerPath, "bin", "node"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("node-18.0.0/bin/node"))
+
+				Expect(filepath.Join(layerPath, "lib", "node-modules", "some-module")).To(BeARegularFile())
+			})
+
+			context("when the pattern does not match", func() {
+				it.Before(func() {
+					dependency.StripSuffix = "python-*"
+				})
+
+				it("leaves the extracted files in place", func() {
+					err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(filepath.Join(layerPath, "node-18.0.0", "bin", "node")).To(BeARegularFile())
+				})
+			})
+
+			context("failure cases", func() {
+				context("when the pattern is malformed", func() {
+					it.Before(func() {
+						dependency.StripSuffix = "node-["
+					})
+
+					it("returns an error", func() {
+						err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+						Expect(err).To(MatchError(ContainSubstring("failed to strip top-level directory: syntax error in pattern")))
+					})
+				})
+
+				context("when a stripped entry collides with an existing entry", func() {
+					it.Before(func() {
+						Expect(os.WriteFile(filepath.Join(layerPath, "bin"), nil, 0644)).To(Succeed())
+					})
+
+					it("returns an error", func() {
+						err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir")
+						Expect(err).To(MatchError(ContainSubstring("already exists")))
+					})
+				})
+			})
+		})
+
+		context("when the dependency has extra uris", func() {
+			var valid []byte
+
+			it.Before(func() {
+				var err error
+				valid, err = io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+
+				transport.DropCall.Stub = func(root, uri string) (io.ReadCloser, error) {
+					if uri == "some-extra-entry.tgz" {
+						return io.NopCloser(bytes.NewReader(valid)), nil
+					}
+
+					return nil, fmt.Errorf("failed to fetch %s", uri)
+				}
+
+				deliver = func() error {
+					return service.Deliver(
+						postal.Dependency{
+							ID:        "some-entry",
+							Stacks:    []string{"some-stack"},
+							URI:       "some-entry.tgz",
+							ExtraURIs: []string{"some-extra-entry.tgz", "some-other-extra-entry.tgz"},
+							SHA256:    dependencyHash,
+							Version:   "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+				}
+			})
+
+			it("falls back to the first extra uri that can be fetched", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.CallCount).To(Equal(2))
+				Expect(transport.DropCall.Receives.Uri).To(Equal("some-extra-entry.tgz"))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "first"),
+					filepath.Join(layerPath, "second"),
+					filepath.Join(layerPath, "third"),
+					filepath.Join(layerPath, "some-dir"),
+					filepath.Join(layerPath, "symlink"),
+				}))
+			})
+
+			context("when none of the uris can be fetched", func() {
+				it.Before(func() {
+					transport.DropCall.Stub = func(root, uri string) (io.ReadCloser, error) {
+						return nil, fmt.Errorf("failed to fetch %s", uri)
+					}
+				})
+
+				it("returns an error including every failure", func() {
+					err := deliver()
+					Expect(err).To(MatchError("failed to fetch dependency: failed to fetch some-entry.tgz\nfailed to fetch some-extra-entry.tgz\nfailed to fetch some-other-extra-entry.tgz"))
+
+					Expect(transport.DropCall.CallCount).To(Equal(3))
+				})
+			})
+		})
+
+		context("when the checksum retry option is given", func() {
+			var valid, corrupt []byte
+
+			it.Before(func() {
+				var err error
+				valid, err = io.ReadAll(transport.DropCall.Returns.ReadCloser)
+				Expect(err).NotTo(HaveOccurred())
+
+				buffer := bytes.NewBuffer(nil)
+				zw := gzip.NewWriter(buffer)
+				tw := tar.NewWriter(zw)
+
+				file := "./corrupt-file"
+				Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+				_, err = tw.Write([]byte(file))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(zw.Close()).To(Succeed())
+
+				corrupt = buffer.Bytes()
+
+				transport.DropCall.Stub = func(string, string) (io.ReadCloser, error) {
+					if transport.DropCall.CallCount == 1 {
+						return io.NopCloser(bytes.NewReader(corrupt)), nil
+					}
+
+					return io.NopCloser(bytes.NewReader(valid)), nil
+				}
+			})
+
+			it("downloads the dependency again when the first download is corrupt", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "some-entry.tgz",
+						SHA256:  dependencyHash,
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+					postal.WithChecksumRetry(),
+				)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.CallCount).To(Equal(2))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "first"),
+					filepath.Join(layerPath, "second"),
+					filepath.Join(layerPath, "third"),
+					filepath.Join(layerPath, "some-dir"),
+					filepath.Join(layerPath, "symlink"),
+				}))
+			})
+
+			context("when the layer path already holds other files", func() {
+				it.Before(func() {
+					Expect(os.MkdirAll(filepath.Join(layerPath, "some-dir"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(layerPath, "some-dir", "existing-file"), nil, 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(layerPath, "existing-file"), nil, 0644)).To(Succeed())
+				})
+
+				it("only removes the files extracted from the corrupt download", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+						postal.WithChecksumRetry(),
+					)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(filepath.Join(layerPath, "existing-file")).To(BeARegularFile())
+					Expect(filepath.Join(layerPath, "some-dir", "existing-file")).To(BeARegularFile())
+					Expect(filepath.Join(layerPath, "some-dir", "some-file")).To(BeARegularFile())
+					Expect(filepath.Join(layerPath, "corrupt-file")).NotTo(BeAnExistingFile())
+				})
+			})
+
+			context("when every download is corrupt", func() {
+				it.Before(func() {
+					transport.DropCall.Stub = func(string, string) (io.ReadCloser, error) {
+						return io.NopCloser(bytes.NewReader(corrupt)), nil
+					}
+				})
+
+				it("returns an error after a single retry", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  dependencyHash,
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+						postal.WithChecksumRetry(),
+					)
+					Expect(err).To(MatchError(ContainSubstring("checksum does not match")))
+
+					Expect(transport.DropCall.CallCount).To(Equal(2))
+				})
+			})
+
+			context("when the option is not given", func() {
+				it("does not retry the download", func() {
+					err := deliver()
+					Expect(err).To(MatchError(ContainSubstring("checksum does not match")))
+
+					Expect(transport.DropCall.CallCount).To(Equal(1))
+				})
+			})
+		})
+
+		context("when there is a dependency mapping via binding", func() {
+			it.Before(func() {
+				mappingResolver.FindDependencyMappingCall.Returns.String = "dependency-mapping-entry.tgz"
+			})
+
+			context("the dependency has a checksum field", func() {
+				it("looks up the dependency from the platform binding and downloads that instead", func() {
+					err := deliver()
+
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(mappingResolver.FindDependencyMappingCall.Receives.Checksum).To(Equal("sha256:" + dependencyHash))
+					Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
+					Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+					Expect(transport.DropCall.Receives.Uri).To(Equal("dependency-mapping-entry.tgz"))
+
+					files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(files).To(ConsistOf([]string{
+						filepath.Join(layerPath, "first"),
+						filepath.Join(layerPath, "second"),
+						filepath.Join(layerPath, "third"),
+						filepath.Join(layerPath, "some-dir"),
+						filepath.Join(layerPath, "symlink"),
+					}))
+
+					info, err := os.Stat(filepath.Join(layerPath, "first"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+				})
+
+			})
+
+			context("the dependency has a SHA256 field", func() {
+				it("looks up the dependency from the platform binding and downloads that instead", func() {
+					err := deliver()
+
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(mappingResolver.FindDependencyMappingCall.Receives.Checksum).To(Equal(fmt.Sprintf("sha256:%s", dependencyHash)))
+					Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
+					Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+					Expect(transport.DropCall.Receives.Uri).To(Equal("dependency-mapping-entry.tgz"))
 
 					files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
 					Expect(err).NotTo(HaveOccurred())
@@ -728,279 +4349,1041 @@ version = "1.2.3"
 						filepath.Join(layerPath, "symlink"),
 					}))
 
-					info, err := os.Stat(filepath.Join(layerPath, "first"))
+					info, err := os.Stat(filepath.Join(layerPath, "first"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+				})
+
+			})
+		})
+
+		context("when there is a dependency mapping via binding", func() {
+			it.Before(func() {
+				mappingResolver.FindDependencyMappingCall.Returns.String = "dependency-mapping-entry.tgz"
+			})
+
+			it("looks up the dependency from the platform binding and downloads that instead", func() {
+				err := deliver()
+
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mappingResolver.FindDependencyMappingCall.Receives.Checksum).To(Equal(fmt.Sprintf("sha256:%s", dependencyHash)))
+				Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
+				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+				Expect(transport.DropCall.Receives.Uri).To(Equal("dependency-mapping-entry.tgz"))
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(layerPath, "first"),
+					filepath.Join(layerPath, "second"),
+					filepath.Join(layerPath, "third"),
+					filepath.Join(layerPath, "some-dir"),
+					filepath.Join(layerPath, "symlink"),
+				}))
+
+				info, err := os.Stat(filepath.Join(layerPath, "first"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+			})
+		})
+
+		context("when there is a dependency mirror", func() {
+			it.Before(func() {
+				mirrorResolver.FindDependencyMirrorCall.Returns.String = "https://mirror.example.com/some-entry.tgz"
+			})
+
+			it("downloads the dependency from the mirror", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mirrorResolver.FindDependencyMirrorCall.Receives.Uri).To(Equal("some-entry.tgz"))
+				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+				Expect(transport.DropCall.Receives.Uri).To(Equal("https://mirror.example.com/some-entry.tgz"))
+			})
+
+			it("fetches the dependency once, validating it as it is extracted", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.CallCount).To(Equal(1))
+			})
+
+			context("when the dependency does not match its checksum", func() {
+				it("returns an error without fetching the dependency again", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  strings.Repeat("0", 64),
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"some-platform-dir",
+					)
+					Expect(err).To(MatchError(postal.ErrChecksumMismatch))
+
+					Expect(transport.DropCall.CallCount).To(Equal(1))
+				})
+			})
+
+			context("when there is also a dependency mapping via binding", func() {
+				it.Before(func() {
+					mappingResolver.FindDependencyMappingCall.Returns.String = "dependency-mapping-entry.tgz"
+				})
+
+				it("prefers the dependency mapping", func() {
+					err := deliver()
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(mirrorResolver.FindDependencyMirrorCall.CallCount).To(Equal(0))
+					Expect(transport.DropCall.Receives.Uri).To(Equal("dependency-mapping-entry.tgz"))
+					Expect(transport.DropCall.CallCount).To(Equal(1))
+				})
+			})
+		})
+
+		context("when no dependency mirror resolver is set", func() {
+			it.Before(func() {
+				t.Setenv("BP_DEPENDENCY_MIRROR", "https://mirror.example.com")
+
+				service = postal.NewService(transport).
+					WithDependencyMappingResolver(mappingResolver)
+			})
+
+			it("downloads the dependency from its uri", func() {
+				err := deliver()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(transport.DropCall.Receives.Uri).To(Equal("some-entry.tgz"))
+			})
+		})
+
+		context("failure cases", func() {
+			context("when dependency mapping resolver fails", func() {
+				it.Before(func() {
+					mappingResolver.FindDependencyMappingCall.Returns.Error = fmt.Errorf("some dependency mapping error")
+				})
+				it("fails to find dependency mappings", func() {
+					err := deliver()
+
+					Expect(err).To(MatchError(ContainSubstring("some dependency mapping error")))
+				})
+			})
+
+			context("when dependency mirror resolver fails", func() {
+				it.Before(func() {
+					mirrorResolver.FindDependencyMirrorCall.Returns.Error = fmt.Errorf("some dependency mirror error")
+				})
+
+				it("returns an error", func() {
+					err := deliver()
+
+					Expect(err).To(MatchError("failure checking for dependency mirrors: some dependency mirror error"))
+				})
+			})
+
+			context("when the transport cannot fetch a dependency", func() {
+				it.Before(func() {
+					transport.DropCall.Returns.Error = errors.New("there was an error")
+				})
+
+				it("returns an error", func() {
+					err := deliver()
+
+					Expect(err).To(MatchError("failed to fetch dependency: there was an error"))
+				})
+			})
+
+			context("when there is a problem with the checksum", func() {
+				it.Before(func() {
+					deliver = func() error {
+						return service.Deliver(
+							postal.Dependency{
+								ID:       "some-entry",
+								Stacks:   []string{"some-stack"},
+								URI:      "some-entry.tgz",
+								Checksum: fmt.Sprintf("magic:%s", hash512),
+								Version:  "1.2.3",
+							},
+							"some-cnb-path",
+							layerPath,
+							"some-platform-dir",
+						)
+					}
+				})
+
+				it("fails to create a validated reader", func() {
+					err := deliver()
+
+					Expect(err).To(MatchError(ContainSubstring(`unsupported algorithm "magic"`)))
+				})
+			})
+
+			context("when the file contents are empty", func() {
+				it.Before(func() {
+					// This is a FLAC header
+					buffer := bytes.NewBuffer([]byte("\x66\x4C\x61\x43\x00\x00\x00\x22"))
+					transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+					sum := sha256.Sum256(buffer.Bytes())
+					dependencyHash = hex.EncodeToString(sum[:])
+				})
+
+				it("fails to create a gzip reader", func() {
+					err := deliver()
+
+					Expect(err).To(MatchError(ContainSubstring("unsupported archive type")))
+				})
+			})
+
+			context("when the file checksum does not match", func() {
+				it("fails to create a tar reader", func() {
+					err := service.Deliver(
+						postal.Dependency{
+							ID:      "some-entry",
+							Stacks:  []string{"some-stack"},
+							URI:     "some-entry.tgz",
+							SHA256:  "this is not a valid checksum",
+							Version: "1.2.3",
+						},
+						"some-cnb-path",
+						layerPath,
+						"",
+					)
+
+					Expect(err).To(MatchError(postal.ErrChecksumMismatch))
+				})
+			})
+
+			context("when it does not have permission to write into directory on container", func() {
+				it.Before(func() {
+					Expect(os.Chmod(layerPath, 0000)).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Chmod(layerPath, 0755)).To(Succeed())
+				})
+
+				it("fails to make a dir", func() {
+					err := deliver()
+
+					Expect(err).To(MatchError(ContainSubstring("failed to create archived directory")))
+				})
+			})
+
+			context("when it does not have permission to write into directory that it decompressed", func() {
+				var testDir string
+				it.Before(func() {
+					testDir = filepath.Join(layerPath, "some-dir")
+					Expect(os.MkdirAll(testDir, os.ModePerm)).To(Succeed())
+					Expect(os.Chmod(testDir, 0000)).To(Succeed())
+				})
+
+				it.After(func() {
+					Expect(os.Chmod(testDir, 0755)).To(Succeed())
+				})
+
+				it("fails to make a file", func() {
+					err := deliver()
+
+					Expect(err).To(MatchError(ContainSubstring("failed to create archived file")))
+				})
+			})
+
+			context("when it is given a broken symlink", func() {
+				it.Before(func() {
+					buffer := bytes.NewBuffer(nil)
+					zw := gzip.NewWriter(buffer)
+					tw := tar.NewWriter(zw)
+
+					linkName := "symlink"
+					Expect(tw.WriteHeader(&tar.Header{Name: linkName, Mode: 0777, Size: int64(0), Typeflag: tar.TypeSymlink, Linkname: "some-file"})).To(Succeed())
+					_, err := tw.Write([]byte{})
 					Expect(err).NotTo(HaveOccurred())
-					Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+
+					Expect(tw.Close()).To(Succeed())
+					Expect(zw.Close()).To(Succeed())
+
+					Expect(os.WriteFile(filepath.Join(layerPath, "some-file"), nil, 0644)).To(Succeed())
+					Expect(os.Symlink("some-file", filepath.Join(layerPath, "symlink"))).To(Succeed())
+
+					sum := sha256.Sum256(buffer.Bytes())
+					dependencyHash = hex.EncodeToString(sum[:])
+
+					transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
 				})
 
+				it("fails to extract the symlink", func() {
+					err := deliver()
+
+					Expect(err).To(MatchError(ContainSubstring("failed to extract symlink")))
+				})
 			})
 
-			context("the dependency has a SHA256 field", func() {
-				it("looks up the dependency from the platform binding and downloads that instead", func() {
+			context("when the has additional data in the byte stream", func() {
+				it.Before(func() {
+					var err error
+					layerPath, err = os.MkdirTemp("", "path")
+					Expect(err).NotTo(HaveOccurred())
+
+					buffer := bytes.NewBuffer(nil)
+					tw := tar.NewWriter(buffer)
+
+					file := "some-file"
+					Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+					_, err = tw.Write([]byte(file))
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(tw.Close()).To(Succeed())
+
+					sum := sha256.Sum256(buffer.Bytes())
+					dependencyHash = hex.EncodeToString(sum[:])
+
+					// Empty block is tricking tar reader into think that we have reached
+					// EOF becuase we have surpassed the maximum block header size
+					var block [1024]byte
+					_, err = buffer.Write(block[:])
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = buffer.WriteString("additional data")
+					Expect(err).NotTo(HaveOccurred())
+
+					transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+					deliver = func() error {
+						return service.Deliver(
+							postal.Dependency{
+								ID:      "some-entry",
+								Stacks:  []string{"some-stack"},
+								URI:     "https://dependencies.example.com/dependencies/some-file-name.txt",
+								SHA256:  dependencyHash,
+								Version: "1.2.3",
+							},
+							"some-cnb-path",
+							layerPath,
+							"some-platform-dir",
+						)
+					}
+				})
+
+				it.After(func() {
+					Expect(os.RemoveAll(layerPath)).To(Succeed())
+				})
+
+				it("returns an error", func() {
 					err := deliver()
+					Expect(err).To(MatchError(postal.ErrChecksumMismatch))
+
+					Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
+					Expect(transport.DropCall.Receives.Uri).To(Equal("https://dependencies.example.com/dependencies/some-file-name.txt"))
+				})
+			})
+		})
+	})
+
+	context("WithTracer", func() {
+		var exporter *tracetest.InMemoryExporter
+
+		it.Before(func() {
+			exporter = tracetest.NewInMemoryExporter()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+			service = service.WithTracer(provider.Tracer("some-tracer"))
+		})
+
+		it("records a span for Resolve", func() {
+			_, err := service.Resolve(path, "some-entry", "1.2.*", "some-stack")
+			Expect(err).NotTo(HaveOccurred())
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name).To(Equal("postal.Resolve"))
+			Expect(spans[0].Attributes).To(ConsistOf(
+				attribute.String("dependency.id", "some-entry"),
+				attribute.String("dependency.stack", "some-stack"),
+				attribute.String("dependency.version", "1.2.3"),
+				attribute.String("dependency.uri", "some-uri"),
+			))
+		})
+
+		it("records a span for Deliver", func() {
+			layerPath, err := os.MkdirTemp("", "layer")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(layerPath)
+
+			sum := sha256.Sum256([]byte("some-contents"))
+			transport.DropCall.Returns.ReadCloser = io.NopCloser(bytes.NewBufferString("some-contents"))
+
+			err = service.Deliver(
+				postal.Dependency{
+					ID:      "some-entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "https://dependencies.example.com/dependencies/some-file-name.txt",
+					SHA256:  hex.EncodeToString(sum[:]),
+					Version: "1.2.3",
+				},
+				"some-cnb-path",
+				layerPath,
+				"some-platform-dir",
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name).To(Equal("postal.Deliver"))
+			Expect(spans[0].Attributes).To(ConsistOf(
+				attribute.String("dependency.id", "some-entry"),
+				attribute.String("dependency.version", "1.2.3"),
+				attribute.String("dependency.stack", "some-stack"),
+				attribute.String("dependency.uri", "https://dependencies.example.com/dependencies/some-file-name.txt"),
+			))
+		})
+
+		context("when Resolve fails", func() {
+			it("records the error on the span", func() {
+				_, err := service.Resolve(path, "some-missing-entry", "1.2.*", "some-stack")
+				Expect(err).To(HaveOccurred())
+
+				spans := exporter.GetSpans()
+				Expect(spans).To(HaveLen(1))
+				Expect(spans[0].Status.Code).To(Equal(codes.Error))
+				Expect(spans[0].Events).To(HaveLen(1))
+			})
+		})
+	})
+
+	context("WithMeterProvider", func() {
+		var (
+			layerPath string
+			reader    sdkmetric.Reader
+			checksum  string
+
+			collect func() map[string]metricdata.Aggregation
+		)
+
+		it.Before(func() {
+			var err error
+			layerPath, err = os.MkdirTemp("", "layer")
+			Expect(err).NotTo(HaveOccurred())
+
+			sum := sha256.Sum256([]byte("some-contents"))
+			checksum = hex.EncodeToString(sum[:])
+			transport.DropCall.Returns.ReadCloser = io.NopCloser(bytes.NewBufferString("some-contents"))
+
+			reader = sdkmetric.NewManualReader()
+			service = service.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+			collect = func() map[string]metricdata.Aggregation {
+				metrics, err := collectMetrics(reader)
+				Expect(err).NotTo(HaveOccurred())
+
+				return metrics
+			}
+		})
+
+		it.After(func() {
+			Expect(os.RemoveAll(layerPath)).To(Succeed())
+		})
+
+		it("records the download metrics for Deliver", func() {
+			err := service.Deliver(
+				postal.Dependency{
+					ID:      "some-entry",
+					Stacks:  []string{"some-stack"},
+					URI:     "https://dependencies.example.com/dependencies/some-file-name.txt",
+					SHA256:  checksum,
+					Version: "1.2.3",
+				},
+				"some-cnb-path",
+				layerPath,
+				"some-platform-dir",
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			metrics := collect()
+			Expect(metrics).To(HaveKey("postal.download.bytes"))
+			Expect(metrics).To(HaveKey("postal.download.duration_ms"))
+			Expect(metrics).NotTo(HaveKey("postal.delivery.errors"))
+
+			downloadBytes, ok := metrics["postal.download.bytes"].(metricdata.Histogram)
+			Expect(ok).To(BeTrue())
+			Expect(downloadBytes.DataPoints).To(HaveLen(1))
+			Expect(downloadBytes.DataPoints[0].Count).To(Equal(uint64(1)))
+			Expect(downloadBytes.DataPoints[0].Sum).To(Equal(float64(len("some-contents"))))
+		})
+
+		context("when Deliver fails", func() {
+			it("counts the error", func() {
+				err := service.Deliver(
+					postal.Dependency{
+						ID:      "some-entry",
+						Stacks:  []string{"some-stack"},
+						URI:     "https://dependencies.example.com/dependencies/some-file-name.txt",
+						SHA256:  "some-other-sha",
+						Version: "1.2.3",
+					},
+					"some-cnb-path",
+					layerPath,
+					"some-platform-dir",
+				)
+				Expect(err).To(HaveOccurred())
+
+				deliveryErrors, ok := collect()["postal.delivery.errors"].(metricdata.Sum[int64])
+				Expect(ok).To(BeTrue())
+				Expect(deliveryErrors.DataPoints).To(HaveLen(1))
+				Expect(deliveryErrors.DataPoints[0].Value).To(Equal(int64(1)))
+			})
+		})
+	})
+
+	context("MultiDeliver", func() {
+		var (
+			layersDir  string
+			layerPaths []string
+			dependency postal.Dependency
+		)
+
+		it.Before(func() {
+			var err error
+			layersDir, err = os.MkdirTemp("", "layers")
+			Expect(err).NotTo(HaveOccurred())
+
+			layerPaths = []string{
+				filepath.Join(layersDir, "first"),
+				filepath.Join(layersDir, "second"),
+				filepath.Join(layersDir, "third"),
+			}
+			Expect(os.Mkdir(layerPaths[0], os.ModePerm)).To(Succeed())
+
+			buffer := bytes.NewBuffer(nil)
+			zw := gzip.NewWriter(buffer)
+			tw := tar.NewWriter(zw)
+
+			Expect(tw.WriteHeader(&tar.Header{Name: "./bin", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+
+			file := "./bin/some-file"
+			Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+			_, err = tw.Write([]byte(file))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tw.WriteHeader(&tar.Header{Name: "./some-link", Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: "bin/some-file"})).To(Succeed())
+
+			Expect(tw.Close()).To(Succeed())
+			Expect(zw.Close()).To(Succeed())
+
+			sum := sha256.Sum256(buffer.Bytes())
+			dependency = postal.Dependency{
+				ID:      "some-entry",
+				Stacks:  []string{"some-stack"},
+				URI:     "some-entry.tgz",
+				SHA256:  hex.EncodeToString(sum[:]),
+				Version: "1.2.3",
+			}
+
+			transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+		})
+
+		it.After(func() {
+			Expect(os.RemoveAll(layersDir)).To(Succeed())
+		})
+
+		it("delivers the dependency into every layer path", func() {
+			errs := service.MultiDeliver(dependency, "some-cnb-path", "some-platform-dir", layerPaths...)
+			Expect(errs).To(Equal([]error{nil, nil, nil}))
+
+			Expect(transport.DropCall.CallCount).To(Equal(1))
+
+			firstInfo, err := os.Stat(filepath.Join(layerPaths[0], "bin", "some-file"))
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, layerPath := range layerPaths {
+				content, err := os.ReadFile(filepath.Join(layerPath, "bin", "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("./bin/some-file"))
+
+				link, err := os.Readlink(filepath.Join(layerPath, "some-link"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link).To(Equal("bin/some-file"))
+
+				info, err := os.Stat(filepath.Join(layerPath, "bin", "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.SameFile(info, firstInfo)).To(BeTrue())
+				Expect(info.Sys().(*syscall.Stat_t).Ino).To(Equal(firstInfo.Sys().(*syscall.Stat_t).Ino))
+			}
+		})
+
+		context("when the first delivery fails", func() {
+			it.Before(func() {
+				transport.DropCall.Returns.Error = errors.New("some-error")
+			})
+
+			it("reports the error for every layer path", func() {
+				errs := service.MultiDeliver(dependency, "some-cnb-path", "some-platform-dir", layerPaths...)
+				Expect(errs).To(HaveLen(3))
+				for _, err := range errs {
+					Expect(err).To(MatchError("failed to fetch dependency: some-error"))
+				}
+
+				Expect(layerPaths[1]).NotTo(BeADirectory())
+			})
+		})
+	})
+
+	context("DeliverAll", func() {
+		var (
+			layerPath string
+
+			mutex    sync.Mutex
+			received []string
+
+			deliveries []postal.Delivery
+		)
+
+		it.Before(func() {
+			var err error
+			layerPath, err = os.MkdirTemp("", "layer")
+			Expect(err).NotTo(HaveOccurred())
+
+			content := "some-content"
+			sum := sha256.Sum256([]byte(content))
+			hash := hex.EncodeToString(sum[:])
+
+			received = nil
+			transport.DropCall.Stub = func(root, uri string) (io.ReadCloser, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
 
-					Expect(err).NotTo(HaveOccurred())
+				received = append(received, uri)
+				return io.NopCloser(bytes.NewBufferString(content)), nil
+			}
 
-					Expect(mappingResolver.FindDependencyMappingCall.Receives.Checksum).To(Equal(fmt.Sprintf("sha256:%s", dependencyHash)))
-					Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
-					Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
-					Expect(transport.DropCall.Receives.Uri).To(Equal("dependency-mapping-entry.tgz"))
+			for _, name := range []string{"low", "high", "medium", "also-medium"} {
+				Expect(os.Mkdir(filepath.Join(layerPath, name), os.ModePerm)).To(Succeed())
+			}
 
-					files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
-					Expect(err).NotTo(HaveOccurred())
-					Expect(files).To(ConsistOf([]string{
-						filepath.Join(layerPath, "first"),
-						filepath.Join(layerPath, "second"),
-						filepath.Join(layerPath, "third"),
-						filepath.Join(layerPath, "some-dir"),
-						filepath.Join(layerPath, "symlink"),
-					}))
+			deliveries = []postal.Delivery{
+				{
+					Dependency: postal.Dependency{ID: "low", URI: "low.txt", SHA256: hash, DownloadPriority: 1},
+					LayerPath:  filepath.Join(layerPath, "low"),
+				},
+				{
+					Dependency: postal.Dependency{ID: "medium", URI: "medium.txt", SHA256: hash, DownloadPriority: 5},
+					LayerPath:  filepath.Join(layerPath, "medium"),
+				},
+				{
+					Dependency: postal.Dependency{ID: "high", URI: "high.txt", SHA256: hash, DownloadPriority: 10},
+					LayerPath:  filepath.Join(layerPath, "high"),
+				},
+				{
+					Dependency: postal.Dependency{ID: "also-medium", URI: "also-medium.txt", SHA256: hash, DownloadPriority: 5},
+					LayerPath:  filepath.Join(layerPath, "also-medium"),
+				},
+			}
 
-					info, err := os.Stat(filepath.Join(layerPath, "first"))
-					Expect(err).NotTo(HaveOccurred())
-					Expect(info.Mode()).To(Equal(os.FileMode(0755)))
-				})
+			service = service.WithConcurrency(1)
+		})
 
-			})
+		it.After(func() {
+			Expect(os.RemoveAll(layerPath)).To(Succeed())
 		})
 
-		context("when there is a dependency mapping via binding", func() {
-			it.Before(func() {
-				mappingResolver.FindDependencyMappingCall.Returns.String = "dependency-mapping-entry.tgz"
-			})
+		it("delivers every dependency in priority order", func() {
+			err := service.DeliverAll(deliveries, "some-cnb-path", "some-platform-dir")
+			Expect(err).NotTo(HaveOccurred())
 
-			it("looks up the dependency from the platform binding and downloads that instead", func() {
-				err := deliver()
+			Expect(received).To(Equal([]string{"high.txt", "also-medium.txt", "medium.txt", "low.txt"}))
 
+			for _, name := range []string{"low", "high", "medium", "also-medium"} {
+				content, err := os.ReadFile(filepath.Join(layerPath, name, fmt.Sprintf("%s.txt", name)))
 				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-content"))
+			}
+		})
 
-				Expect(mappingResolver.FindDependencyMappingCall.Receives.Checksum).To(Equal(fmt.Sprintf("sha256:%s", dependencyHash)))
-				Expect(mappingResolver.FindDependencyMappingCall.Receives.PlatformDir).To(Equal("some-platform-dir"))
-				Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
-				Expect(transport.DropCall.Receives.Uri).To(Equal("dependency-mapping-entry.tgz"))
+		context("when delivering concurrently", func() {
+			it.Before(func() {
+				service = service.WithConcurrency(4)
+			})
 
-				files, err := filepath.Glob(fmt.Sprintf("%s/*", layerPath))
+			it("delivers every dependency", func() {
+				err := service.DeliverAll(deliveries, "some-cnb-path", "some-platform-dir")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(files).To(ConsistOf([]string{
-					filepath.Join(layerPath, "first"),
-					filepath.Join(layerPath, "second"),
-					filepath.Join(layerPath, "third"),
-					filepath.Join(layerPath, "some-dir"),
-					filepath.Join(layerPath, "symlink"),
-				}))
 
-				info, err := os.Stat(filepath.Join(layerPath, "first"))
-				Expect(err).NotTo(HaveOccurred())
-				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+				Expect(received).To(ConsistOf("high.txt", "also-medium.txt", "medium.txt", "low.txt"))
 			})
 		})
 
 		context("failure cases", func() {
-			context("when dependency mapping resolver fails", func() {
+			context("when a delivery fails", func() {
 				it.Before(func() {
-					mappingResolver.FindDependencyMappingCall.Returns.Error = fmt.Errorf("some dependency mapping error")
+					deliveries[1].Dependency.SHA256 = "some-other-sha"
 				})
-				it("fails to find dependency mappings", func() {
-					err := deliver()
 
-					Expect(err).To(MatchError(ContainSubstring("some dependency mapping error")))
+				it("delivers the remaining dependencies and returns an error", func() {
+					err := service.DeliverAll(deliveries, "some-cnb-path", "some-platform-dir")
+					Expect(err).To(MatchError(ContainSubstring(`failed to deliver "medium": failed to validate dependency: checksum does not match`)))
+
+					Expect(received).To(HaveLen(4))
 				})
 			})
+		})
+	})
 
-			context("when the transport cannot fetch a dependency", func() {
-				it.Before(func() {
-					transport.DropCall.Returns.Error = errors.New("there was an error")
+	context("ValidateNoConflicts", func() {
+		it("returns nil when no dependencies conflict", func() {
+			err := service.ValidateNoConflicts([]postal.Dependency{
+				{ID: "python", Version: "3.11.0", ConflictsWith: []string{"python2"}},
+				{ID: "pip", Version: "23.0.0"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		context("when dependencies conflict", func() {
+			it("returns an error listing every conflicting pair", func() {
+				err := service.ValidateNoConflicts([]postal.Dependency{
+					{ID: "python", Version: "3.11.0", ConflictsWith: []string{"python2"}},
+					{ID: "python2", Version: "2.7.18", ConflictsWith: []string{"python", "pypy"}},
+					{ID: "pypy", Version: "7.3.11"},
 				})
+				Expect(err).To(MatchError(`found conflicting dependencies: "pypy" conflicts with "python2", "python" conflicts with "python2"`))
+			})
+		})
+	})
 
-				it("returns an error", func() {
-					err := deliver()
+	context("FetchSBOM", func() {
+		var (
+			sbomTransport *fakes.Transport
+			dependency    postal.Dependency
+		)
 
-					Expect(err).To(MatchError("failed to fetch dependency: there was an error"))
+		it.Before(func() {
+			sbomTransport = &fakes.Transport{}
+			sbomTransport.DropCall.Returns.ReadCloser = io.NopCloser(strings.NewReader(`{"bomFormat": "CycloneDX"}`))
+
+			dependency = postal.Dependency{
+				ID:      "some-entry",
+				Version: "1.2.3",
+				SBOMURIs: map[string]string{
+					"cyclonedx": "https://example.com/some-entry.cdx.json",
+					"spdx":      "https://example.com/some-entry.spdx.json",
+				},
+			}
+		})
+
+		it("returns the sbom in the given format", func() {
+			content, err := service.FetchSBOM(dependency, "cyclonedx", sbomTransport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(`{"bomFormat": "CycloneDX"}`))
+
+			Expect(sbomTransport.DropCall.Receives.Uri).To(Equal("https://example.com/some-entry.cdx.json"))
+		})
+
+		context("failure cases", func() {
+			context("when the dependency has no sbom in the given format", func() {
+				it("returns an error", func() {
+					_, err := service.FetchSBOM(dependency, "syft", sbomTransport)
+					Expect(err).To(MatchError(`failed to fetch sbom: dependency "some-entry" has no "syft" sbom`))
 				})
 			})
 
-			context("when there is a problem with the checksum", func() {
+			context("when the sbom cannot be fetched", func() {
 				it.Before(func() {
-					deliver = func() error {
-						return service.Deliver(
-							postal.Dependency{
-								ID:       "some-entry",
-								Stacks:   []string{"some-stack"},
-								URI:      "some-entry.tgz",
-								Checksum: fmt.Sprintf("magic:%s", hash512),
-								Version:  "1.2.3",
-							},
-							"some-cnb-path",
-							layerPath,
-							"some-platform-dir",
-						)
-					}
+					sbomTransport.DropCall.Returns.Error = errors.New("some-error")
 				})
 
-				it("fails to create a validated reader", func() {
-					err := deliver()
-
-					Expect(err).To(MatchError(ContainSubstring(`unsupported algorithm "magic"`)))
+				it("returns an error", func() {
+					_, err := service.FetchSBOM(dependency, "spdx", sbomTransport)
+					Expect(err).To(MatchError("failed to fetch sbom: some-error"))
 				})
 			})
+		})
+	})
 
-			context("when the file contents are empty", func() {
-				it.Before(func() {
-					// This is a FLAC header
-					buffer := bytes.NewBuffer([]byte("\x66\x4C\x61\x43\x00\x00\x00\x22"))
-					transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+	context("ComputedChecksum", func() {
+		it.Before(func() {
+			transport.DropCall.Returns.ReadCloser = io.NopCloser(strings.NewReader("some-content"))
+		})
 
-					sum := sha256.Sum256(buffer.Bytes())
-					dependencyHash = hex.EncodeToString(sum[:])
-				})
+		it("returns the checksum of the content of the dependency", func() {
+			checksum, err := service.ComputedChecksum(postal.Dependency{ID: "some-entry", URI: "some-uri"}, "sha512")
+			Expect(err).NotTo(HaveOccurred())
 
-				it("fails to create a gzip reader", func() {
-					err := deliver()
+			sum := sha512.Sum512([]byte("some-content"))
+			Expect(checksum).To(Equal("sha512:" + hex.EncodeToString(sum[:])))
 
-					Expect(err).To(MatchError(ContainSubstring("unsupported archive type")))
+			Expect(transport.DropCall.Receives.Uri).To(Equal("some-uri"))
+		})
+
+		context("failure cases", func() {
+			context("when the algorithm is not supported", func() {
+				it("returns an error", func() {
+					_, err := service.ComputedChecksum(postal.Dependency{ID: "some-entry", URI: "some-uri"}, "md5")
+					Expect(err).To(MatchError(`unsupported algorithm "md5": the following algorithms are supported [sha256, sha512]`))
 				})
 			})
 
-			context("when the file checksum does not match", func() {
-				it("fails to create a tar reader", func() {
-					err := service.Deliver(
-						postal.Dependency{
-							ID:      "some-entry",
-							Stacks:  []string{"some-stack"},
-							URI:     "some-entry.tgz",
-							SHA256:  "this is not a valid checksum",
-							Version: "1.2.3",
-						},
-						"some-cnb-path",
-						layerPath,
-						"",
-					)
+			context("when the dependency cannot be fetched", func() {
+				it.Before(func() {
+					transport.DropCall.Returns.Error = errors.New("some-error")
+				})
 
-					Expect(err).To(MatchError("validation error: checksum does not match"))
+				it("returns an error", func() {
+					_, err := service.ComputedChecksum(postal.Dependency{ID: "some-entry", URI: "some-uri"}, "sha256")
+					Expect(err).To(MatchError("failed to fetch dependency: some-error"))
 				})
 			})
+		})
+	})
 
-			context("when it does not have permission to write into directory on container", func() {
+	context("PopulateChecksums", func() {
+		it.Before(func() {
+			err := os.WriteFile(path, []byte(`api = "0.7"
+
+[buildpack]
+id = "some-buildpack"
+
+# some-entry is kept on its legacy sha256
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+checksum = "sha256:some-existing-sha"
+id = "some-entry"
+stacks = ["some-stack"]
+uri = "some-existing-uri"
+version = "1.2.4"
+
+  [[metadata.dependencies]]
+    version = "2.0.0" # the latest release
+    id = "other-entry"
+    uri = "other-uri"
+    stacks = ["some-stack"]
+`), 0600)
+			Expect(err).NotTo(HaveOccurred())
+
+			transport.DropCall.Stub = func(root, uri string) (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader(fmt.Sprintf("content of %s", uri))), nil
+			}
+		})
+
+		it("adds the computed checksum of each dependency without one to the buildpack.toml, keeping the rest of the file", func() {
+			err := service.PopulateChecksums(path, "sha256")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(transport.DropCall.CallCount).To(Equal(1))
+			Expect(transport.DropCall.Receives.Root).To(Equal(filepath.Dir(path)))
+			Expect(transport.DropCall.Receives.Uri).To(Equal("other-uri"))
+
+			otherSum := sha256.Sum256([]byte("content of other-uri"))
+
+			content, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(fmt.Sprintf(`api = "0.7"
+
+[buildpack]
+id = "some-buildpack"
+
+# some-entry is kept on its legacy sha256
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+checksum = "sha256:some-existing-sha"
+id = "some-entry"
+stacks = ["some-stack"]
+uri = "some-existing-uri"
+version = "1.2.4"
+
+  [[metadata.dependencies]]
+    checksum = "sha256:%s"
+    version = "2.0.0" # the latest release
+    id = "other-entry"
+    uri = "other-uri"
+    stacks = ["some-stack"]
+`, hex.EncodeToString(otherSum[:]))))
+		})
+
+		context("failure cases", func() {
+			context("when a dependency cannot be fetched", func() {
 				it.Before(func() {
-					Expect(os.Chmod(layerPath, 0000)).To(Succeed())
+					transport.DropCall.Stub = func(root, uri string) (io.ReadCloser, error) {
+						return nil, errors.New("some-error")
+					}
 				})
 
-				it.After(func() {
-					Expect(os.Chmod(layerPath, 0755)).To(Succeed())
-				})
+				it("returns an error and leaves the buildpack.toml unchanged", func() {
+					original, err := os.ReadFile(path)
+					Expect(err).NotTo(HaveOccurred())
 
-				it("fails to make a dir", func() {
-					err := deliver()
+					err = service.PopulateChecksums(path, "sha256")
+					Expect(err).To(MatchError("failed to compute checksum of other-entry 2.0.0: failed to fetch dependency: some-error"))
 
-					Expect(err).To(MatchError(ContainSubstring("failed to create archived directory")))
+					content, err := os.ReadFile(path)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(content).To(Equal(original))
 				})
 			})
+		})
+	})
 
-			context("when it does not have permission to write into directory that it decompressed", func() {
-				var testDir string
-				it.Before(func() {
-					testDir = filepath.Join(layerPath, "some-dir")
-					Expect(os.MkdirAll(testDir, os.ModePerm)).To(Succeed())
-					Expect(os.Chmod(testDir, 0000)).To(Succeed())
-				})
+	context("FetchChangelog", func() {
+		var (
+			changelogTransport *fakes.Transport
+			dependency         postal.Dependency
+		)
 
-				it.After(func() {
-					Expect(os.Chmod(testDir, 0755)).To(Succeed())
-				})
+		it.Before(func() {
+			changelogTransport = &fakes.Transport{}
+			changelogTransport.DropCall.Returns.ReadCloser = io.NopCloser(strings.NewReader("## 1.2.3\n- some-change\n"))
 
-				it("fails to make a file", func() {
-					err := deliver()
+			dependency = postal.Dependency{
+				ID:           "some-entry",
+				Version:      "1.2.3",
+				ChangelogURI: "https://example.com/some-entry/CHANGELOG.md",
+			}
+		})
 
-					Expect(err).To(MatchError(ContainSubstring("failed to create archived file")))
-				})
+		it("returns the changelog of the dependency", func() {
+			changelog, err := service.FetchChangelog(dependency, changelogTransport)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changelog).To(Equal("## 1.2.3\n- some-change\n"))
+
+			Expect(changelogTransport.DropCall.Receives.Uri).To(Equal("https://example.com/some-entry/CHANGELOG.md"))
+		})
+
+		context("when the dependency has no changelog uri", func() {
+			it("returns an empty changelog", func() {
+				dependency.ChangelogURI = ""
+
+				changelog, err := service.FetchChangelog(dependency, changelogTransport)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changelog).To(BeEmpty())
+
+				Expect(changelogTransport.DropCall.CallCount).To(Equal(0))
 			})
+		})
 
-			context("when it is given a broken symlink", func() {
+		context("failure cases", func() {
+			context("when the changelog cannot be fetched", func() {
 				it.Before(func() {
-					buffer := bytes.NewBuffer(nil)
-					zw := gzip.NewWriter(buffer)
-					tw := tar.NewWriter(zw)
+					changelogTransport.DropCall.Returns.Error = errors.New("some-error")
+				})
 
-					linkName := "symlink"
-					Expect(tw.WriteHeader(&tar.Header{Name: linkName, Mode: 0777, Size: int64(0), Typeflag: tar.TypeSymlink, Linkname: "some-file"})).To(Succeed())
-					_, err := tw.Write([]byte{})
-					Expect(err).NotTo(HaveOccurred())
+				it("returns an error", func() {
+					_, err := service.FetchChangelog(dependency, changelogTransport)
+					Expect(err).To(MatchError("failed to fetch changelog: some-error"))
+				})
+			})
+		})
+	})
 
-					Expect(tw.Close()).To(Succeed())
-					Expect(zw.Close()).To(Succeed())
+	context("GenerateBuildPlanProvisions", func() {
+		it("returns a deduplicated list of the provisions of every dependency", func() {
+			entries := service.GenerateBuildPlanProvisions(
+				postal.Dependency{ID: "python", Version: "3.11.0", Provides: []string{"python", "pip"}},
+				postal.Dependency{ID: "cpython", Version: "3.11.0", Provides: []string{"pip", "cpython"}},
+			)
+
+			Expect(entries).To(Equal([]packit.BuildpackPlanEntry{
+				{Name: "python"},
+				{Name: "pip"},
+				{Name: "cpython"},
+			}))
+		})
+	})
 
-					Expect(os.WriteFile(filepath.Join(layerPath, "some-file"), nil, 0644)).To(Succeed())
-					Expect(os.Symlink("some-file", filepath.Join(layerPath, "symlink"))).To(Succeed())
+	context("AttestBillOfMaterials", func() {
+		var (
+			submitter *fakes.RekorSubmitter
+			layer     packit.Layer
+		)
 
-					sum := sha256.Sum256(buffer.Bytes())
-					dependencyHash = hex.EncodeToString(sum[:])
+		it.Before(func() {
+			submitter = &fakes.RekorSubmitter{}
+			submitter.SubmitCall.Returns.RekorURL = "https://rekor.example.com/api/v1/log/entries/some-uuid"
 
-					transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
-				})
+			service = service.WithRekorSubmitter(submitter)
 
-				it("fails to extract the symlink", func() {
-					err := deliver()
+			layer = packit.Layer{
+				Name:     "some-layer",
+				Metadata: map[string]interface{}{"some-key": "some-value"},
+				SBOM: packit.SBOMFormats{
+					{Extension: "cdx.json", Content: strings.NewReader(`{"bomFormat":"CycloneDX"}`)},
+					{Extension: "spdx.json", Content: strings.NewReader(`{"spdxVersion":"SPDX-2.2"}`)},
+				},
+			}
+		})
 
-					Expect(err).To(MatchError(ContainSubstring("failed to extract symlink")))
-				})
-			})
+		it("submits the sbom of the layer and records the entry in the layer metadata", func() {
+			layer, err := service.AttestBillOfMaterials(layer)
+			Expect(err).NotTo(HaveOccurred())
 
-			context("when the has additional data in the byte stream", func() {
-				it.Before(func() {
-					var err error
-					layerPath, err = os.MkdirTemp("", "path")
-					Expect(err).NotTo(HaveOccurred())
+			Expect(layer.Metadata).To(Equal(map[string]interface{}{
+				"some-key":    "some-value",
+				"rekor-entry": "https://rekor.example.com/api/v1/log/entries/some-uuid",
+			}))
 
-					buffer := bytes.NewBuffer(nil)
-					tw := tar.NewWriter(buffer)
+			Expect(submitter.SubmitCall.CallCount).To(Equal(1))
+			Expect(string(submitter.SubmitCall.Receives.BomJSON)).To(Equal(`{"bomFormat":"CycloneDX"}`))
+		})
 
-					file := "some-file"
-					Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
-					_, err = tw.Write([]byte(file))
-					Expect(err).NotTo(HaveOccurred())
+		it("keeps the content of every format of the sbom", func() {
+			layer, err := service.AttestBillOfMaterials(layer)
+			Expect(err).NotTo(HaveOccurred())
 
-					Expect(tw.Close()).To(Succeed())
+			formats := layer.SBOM.Formats()
+			Expect(formats).To(HaveLen(2))
 
-					sum := sha256.Sum256(buffer.Bytes())
-					dependencyHash = hex.EncodeToString(sum[:])
+			Expect(formats[0].Extension).To(Equal("cdx.json"))
+			content, err := io.ReadAll(formats[0].Content)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(`{"bomFormat":"CycloneDX"}`))
 
-					// Empty block is tricking tar reader into think that we have reached
-					// EOF becuase we have surpassed the maximum block header size
-					var block [1024]byte
-					_, err = buffer.Write(block[:])
-					Expect(err).NotTo(HaveOccurred())
+			Expect(formats[1].Extension).To(Equal("spdx.json"))
+			content, err = io.ReadAll(formats[1].Content)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(`{"spdxVersion":"SPDX-2.2"}`))
+		})
 
-					_, err = buffer.WriteString("additional data")
-					Expect(err).NotTo(HaveOccurred())
+		context("when no RekorSubmitter is set", func() {
+			it.Before(func() {
+				service = service.WithRekorSubmitter(nil)
+			})
 
-					transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+			it("returns the layer unchanged", func() {
+				attested, err := service.AttestBillOfMaterials(layer)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(attested).To(Equal(layer))
+			})
+		})
 
-					deliver = func() error {
-						return service.Deliver(
-							postal.Dependency{
-								ID:      "some-entry",
-								Stacks:  []string{"some-stack"},
-								URI:     "https://dependencies.example.com/dependencies/some-file-name.txt",
-								SHA256:  dependencyHash,
-								Version: "1.2.3",
-							},
-							"some-cnb-path",
-							layerPath,
-							"some-platform-dir",
-						)
-					}
+		context("failure cases", func() {
+			context("when the layer has no sbom", func() {
+				it("returns an error", func() {
+					_, err := service.AttestBillOfMaterials(packit.Layer{Name: "some-layer"})
+					Expect(err).To(MatchError(`failed to attest bill of materials: layer "some-layer" has no SBOM`))
 				})
+			})
 
-				it.After(func() {
-					Expect(os.RemoveAll(layerPath)).To(Succeed())
+			context("when the submitter fails", func() {
+				it.Before(func() {
+					submitter.SubmitCall.Returns.Err = errors.New("rekor is unavailable")
 				})
 
 				it("returns an error", func() {
-					err := deliver()
-					Expect(err).To(MatchError("failed to validate dependency: checksum does not match"))
-
-					Expect(transport.DropCall.Receives.Root).To(Equal("some-cnb-path"))
-					Expect(transport.DropCall.Receives.Uri).To(Equal("https://dependencies.example.com/dependencies/some-file-name.txt"))
+					_, err := service.AttestBillOfMaterials(layer)
+					Expect(err).To(MatchError("failed to submit bill of materials to rekor: rekor is unavailable"))
 				})
 			})
 		})
@@ -1152,6 +5535,90 @@ version = "1.2.3"
 					}))
 				})
 
+				context("when multi-CPE support is enabled", func() {
+					it("includes both CPE and CPEs", func() {
+						entries := service.WithMultiCPESupport().GenerateBillOfMaterials(
+							postal.Dependency{
+								CPE:            "some-cpe",
+								CPEs:           []string{"some-cpe", "some-other-cpe"},
+								ID:             "some-entry",
+								Name:           "Some Entry",
+								Checksum:       "sha256:some-sha",
+								Source:         "some-source",
+								SourceChecksum: "sha256:some-source-sha",
+								Stacks:         []string{"some-stack"},
+								URI:            "some-uri",
+								Version:        "1.2.3",
+							},
+						)
+
+						Expect(entries).To(Equal([]packit.BOMEntry{
+							{
+								Name: "Some Entry",
+								Metadata: paketosbom.BOMMetadata{
+									CPE:  "some-cpe",
+									CPEs: []string{"some-cpe", "some-other-cpe"},
+									Checksum: paketosbom.BOMChecksum{
+										Algorithm: paketosbom.SHA256,
+										Hash:      "some-sha",
+									},
+									Source: paketosbom.BOMSource{
+										Checksum: paketosbom.BOMChecksum{
+											Algorithm: paketosbom.SHA256,
+											Hash:      "some-source-sha",
+										},
+										URI: "some-source",
+									},
+
+									URI:     "some-uri",
+									Version: "1.2.3",
+								},
+							},
+						}))
+					})
+				})
+
+			})
+		})
+
+		context("when there are build flags", func() {
+			it("generates a BOM with the build flags", func() {
+				entries := service.GenerateBillOfMaterials(
+					postal.Dependency{
+						BuildFlags:     []string{"-O2", "-fstack-protector-strong"},
+						ID:             "some-entry",
+						Name:           "Some Entry",
+						Checksum:       "sha256:some-sha",
+						Source:         "some-source",
+						SourceChecksum: "sha256:some-source-sha",
+						Stacks:         []string{"some-stack"},
+						URI:            "some-uri",
+						Version:        "1.2.3",
+					},
+				)
+
+				Expect(entries).To(Equal([]packit.BOMEntry{
+					{
+						Name: "Some Entry",
+						Metadata: paketosbom.BOMMetadata{
+							BuildFlags: []string{"-O2", "-fstack-protector-strong"},
+							Checksum: paketosbom.BOMChecksum{
+								Algorithm: paketosbom.SHA256,
+								Hash:      "some-sha",
+							},
+							Source: paketosbom.BOMSource{
+								Checksum: paketosbom.BOMChecksum{
+									Algorithm: paketosbom.SHA256,
+									Hash:      "some-source-sha",
+								},
+								URI: "some-source",
+							},
+
+							URI:     "some-uri",
+							Version: "1.2.3",
+						},
+					},
+				}))
 			})
 		})
 
@@ -1286,6 +5753,121 @@ version = "1.2.3"
 			})
 		})
 
+		context("when PURL auto-generation is enabled", func() {
+			it("generates a BOM with a generic pURL", func() {
+				entries := service.WithAutoGeneratePURL().GenerateBillOfMaterials(
+					postal.Dependency{
+						ID:             "some-entry",
+						Name:           "Some Entry",
+						Checksum:       "sha256:some-sha",
+						Source:         "some-source",
+						SourceChecksum: "sha256:some-source-sha",
+						Stacks:         []string{"some-stack"},
+						URI:            "some-uri",
+						Version:        "1.2.3",
+					},
+				)
+
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0].Metadata.(paketosbom.BOMMetadata).PURL).To(Equal("pkg:generic/some-entry@1.2.3"))
+			})
+
+			context("when the dependency declares a pURL", func() {
+				it("keeps the declared pURL", func() {
+					entries := service.WithAutoGeneratePURL().GenerateBillOfMaterials(
+						postal.Dependency{
+							ID:      "some-entry",
+							Name:    "Some Entry",
+							PURL:    "some-purl",
+							URI:     "some-uri",
+							Version: "1.2.3",
+						},
+					)
+
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].Metadata.(paketosbom.BOMMetadata).PURL).To(Equal("some-purl"))
+				})
+			})
+		})
+
+		context("when version normalization is enabled", func() {
+			it("strips the build metadata from the version", func() {
+				entries := service.WithNormalizedVersions().WithAutoGeneratePURL().GenerateBillOfMaterials(
+					postal.Dependency{
+						ID:             "some-entry",
+						Name:           "Some Entry",
+						Checksum:       "sha256:some-sha",
+						Source:         "some-source",
+						SourceChecksum: "sha256:some-source-sha",
+						Stacks:         []string{"some-stack"},
+						URI:            "some-uri",
+						Version:        "1.2.3+build.1",
+					},
+				)
+
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0].Metadata.(paketosbom.BOMMetadata).Version).To(Equal("1.2.3"))
+				Expect(entries[0].Metadata.(paketosbom.BOMMetadata).PURL).To(Equal("pkg:generic/some-entry@1.2.3"))
+			})
+
+			context("when normalization is not enabled", func() {
+				it("keeps the build metadata", func() {
+					entries := service.GenerateBillOfMaterials(
+						postal.Dependency{
+							ID:      "some-entry",
+							Name:    "Some Entry",
+							URI:     "some-uri",
+							Version: "1.2.3+build.1",
+						},
+					)
+
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].Metadata.(paketosbom.BOMMetadata).Version).To(Equal("1.2.3+build.1"))
+				})
+			})
+		})
+
+		context("when there is a homepage", func() {
+			it("generates a BOM with the homepage", func() {
+				entries := service.GenerateBillOfMaterials(
+					postal.Dependency{
+						ID:             "some-entry",
+						Name:           "Some Entry",
+						Homepage:       "https://example.com/some-entry",
+						Checksum:       "sha256:some-sha",
+						Source:         "some-source",
+						SourceChecksum: "sha256:some-source-sha",
+						Stacks:         []string{"some-stack"},
+						URI:            "some-uri",
+						Version:        "1.2.3",
+					},
+				)
+
+				Expect(entries).To(Equal([]packit.BOMEntry{
+					{
+						Name: "Some Entry",
+						Metadata: paketosbom.BOMMetadata{
+							Homepage: "https://example.com/some-entry",
+							Checksum: paketosbom.BOMChecksum{
+								Algorithm: paketosbom.SHA256,
+								Hash:      "some-sha",
+							},
+							Source: paketosbom.BOMSource{
+								Checksum: paketosbom.BOMChecksum{
+									Algorithm: paketosbom.SHA256,
+									Hash:      "some-source-sha",
+								},
+								URI: "some-source",
+							},
+
+							URI:     "some-uri",
+							Version: "1.2.3",
+						},
+					},
+				}))
+			})
+		})
+
 		context("when there is a SHA256 instead of Checksum", func() {
 			it("generates a BOM with the SHA256", func() {
 				entries := service.GenerateBillOfMaterials(
@@ -1442,4 +6024,154 @@ version = "1.2.3"
 			})
 		})
 	})
+
+	context("GenerateInTotoStatement", func() {
+		it("returns an in-toto statement describing the dependencies", func() {
+			content, err := service.GenerateInTotoStatement("some-builder-id",
+				postal.Dependency{
+					ID:       "some-entry",
+					Name:     "Some Entry",
+					SHA256:   "some-sha",
+					Licenses: []string{"MIT"},
+					Version:  "1.2.3",
+				},
+				postal.Dependency{
+					ID:       "other-entry",
+					Name:     "Other Entry",
+					Checksum: "sha512:other-sha",
+					Version:  "4.5.6",
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			var statement struct {
+				Type    string `json:"_type"`
+				Subject []struct {
+					Name   string            `json:"name"`
+					Digest map[string]string `json:"digest"`
+				} `json:"subject"`
+				PredicateType string `json:"predicateType"`
+				Predicate     struct {
+					Builder struct {
+						ID string `json:"id"`
+					} `json:"builder"`
+					Dependencies []struct {
+						ID       string   `json:"id"`
+						Version  string   `json:"version"`
+						Licenses []string `json:"licenses"`
+					} `json:"dependencies"`
+				} `json:"predicate"`
+			}
+			Expect(json.Unmarshal(content, &statement)).To(Succeed())
+
+			Expect(statement.Type).To(Equal("https://in-toto.io/Statement/v1"))
+			Expect(statement.PredicateType).To(Equal(postal.InTotoPredicateType))
+
+			Expect(statement.Subject).To(HaveLen(2))
+			Expect(statement.Subject[0].Name).To(Equal("some-entry"))
+			Expect(statement.Subject[0].Digest).To(Equal(map[string]string{"sha256": "some-sha"}))
+			Expect(statement.Subject[1].Name).To(Equal("other-entry"))
+			Expect(statement.Subject[1].Digest).To(Equal(map[string]string{"sha512": "other-sha"}))
+
+			Expect(statement.Predicate.Builder.ID).To(Equal("some-builder-id"))
+			Expect(statement.Predicate.Dependencies).To(HaveLen(2))
+			Expect(statement.Predicate.Dependencies[0].ID).To(Equal("some-entry"))
+			Expect(statement.Predicate.Dependencies[0].Version).To(Equal("1.2.3"))
+			Expect(statement.Predicate.Dependencies[0].Licenses).To(Equal([]string{"MIT"}))
+			Expect(statement.Predicate.Dependencies[1].ID).To(Equal("other-entry"))
+			Expect(statement.Predicate.Dependencies[1].Version).To(Equal("4.5.6"))
+			Expect(statement.Predicate.Dependencies[1].Licenses).To(BeEmpty())
+		})
+
+		context("failure cases", func() {
+			context("when a dependency has no checksum", func() {
+				it("returns an error", func() {
+					_, err := service.GenerateInTotoStatement("some-builder-id", postal.Dependency{
+						ID:      "some-entry",
+						Version: "1.2.3",
+					})
+					Expect(err).To(MatchError(`failed to generate in-toto statement: dependency "some-entry" has no checksum`))
+				})
+			})
+		})
+	})
+
+	context("GenerateGitHubDependencySnapshot", func() {
+		it("returns a snapshot describing the dependencies", func() {
+			content, err := service.GenerateGitHubDependencySnapshot("refs/heads/main", "some-commit-sha",
+				postal.Dependency{
+					ID:      "some-entry",
+					PURL:    "pkg:generic/some-entry@1.2.3?checksum=some-sha",
+					Version: "1.2.3",
+				},
+				postal.Dependency{
+					ID:      "other-entry",
+					Version: "4.5.6",
+				},
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			var snapshot map[string]interface{}
+			Expect(json.Unmarshal(content, &snapshot)).To(Succeed())
+			Expect(snapshot).To(HaveKey("version"))
+			Expect(snapshot).To(HaveKey("job"))
+			Expect(snapshot).To(HaveKeyWithValue("sha", "some-commit-sha"))
+			Expect(snapshot).To(HaveKeyWithValue("ref", "refs/heads/main"))
+			Expect(snapshot).To(HaveKeyWithValue("detector", map[string]interface{}{
+				"name":    "packit-postal",
+				"version": "v2",
+				"url":     "https://github.com/paketo-buildpacks/packit",
+			}))
+			Expect(snapshot).To(HaveKeyWithValue("manifests", map[string]interface{}{
+				"buildpack.toml": map[string]interface{}{
+					"name": "buildpack.toml",
+					"file": map[string]interface{}{
+						"source_location": "buildpack.toml",
+					},
+					"resolved": map[string]interface{}{
+						"some-entry@1.2.3": map[string]interface{}{
+							"package_url":  "pkg:generic/some-entry@1.2.3?checksum=some-sha",
+							"relationship": "direct",
+							"scope":        "runtime",
+						},
+						"other-entry@4.5.6": map[string]interface{}{
+							"package_url":  "pkg:generic/other-entry@4.5.6",
+							"relationship": "direct",
+							"scope":        "runtime",
+						},
+					},
+				},
+			}))
+		})
+	})
+}
+
+// gatedReader blocks every read until its gate is closed.
+type gatedReader struct {
+	gate   chan struct{}
+	reader io.Reader
+}
+
+func (r gatedReader) Read(p []byte) (int, error) {
+	<-r.gate
+	return r.reader.Read(p)
+}
+
+// collectMetrics gathers the data recorded by every instrument read by the
+// given reader, keyed by instrument name.
+func collectMetrics(reader sdkmetric.Reader) (map[string]metricdata.Aggregation, error) {
+	var resourceMetrics metricdata.ResourceMetrics
+	err := reader.Collect(gocontext.Background(), &resourceMetrics)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := map[string]metricdata.Aggregation{}
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, m := range scopeMetrics.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+
+	return metrics, nil
 }
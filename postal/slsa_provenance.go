@@ -0,0 +1,94 @@
+package postal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// SLSAProvenancePredicateType is the type identifier of the SLSA
+	// provenance predicate written by the WithSLSAProvenance option.
+	SLSAProvenancePredicateType = "https://slsa.dev/provenance/v0.2"
+
+	// SLSAProvenanceBuildType is the type identifier of the build recorded in
+	// the SLSA provenance written by the WithSLSAProvenance option, which is
+	// the delivery of a dependency into a layer.
+	SLSAProvenanceBuildType = "https://paketo.io/postal/deliver/v1"
+)
+
+type slsaStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaPredicate   `json:"predicate"`
+}
+
+type slsaPredicate struct {
+	Builder    inTotoBuilder  `json:"builder"`
+	BuildType  string         `json:"buildType"`
+	Invocation slsaInvocation `json:"invocation"`
+	Metadata   slsaMetadata   `json:"metadata"`
+	Materials  []slsaMaterial `json:"materials"`
+}
+
+type slsaInvocation struct {
+	Parameters map[string]string `json:"parameters"`
+}
+
+type slsaMetadata struct {
+	BuildFinishedOn time.Time `json:"buildFinishedOn"`
+}
+
+type slsaMaterial struct {
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// writeSLSAProvenance writes an in-toto Statement with a SLSA provenance
+// predicate describing the delivery of the given dependency, which was
+// downloaded from the given uri and validated against the given checksum.
+func writeSLSAProvenance(w io.Writer, builderID string, dependency Dependency, uri, checksum string) error {
+	digest := map[string]string{}
+	if checksum != "" {
+		digest[Checksum(checksum).Algorithm()] = Checksum(checksum).Hash()
+	}
+
+	if dependency.EphemeralURI {
+		// Ephemeral uris are not recorded as they stop working once they expire
+		// and may grant access to whoever holds them
+		uri = ""
+	}
+
+	statement := slsaStatement{
+		Type: InTotoStatementType,
+		Subject: []inTotoSubject{
+			{Name: dependency.ID, Digest: digest},
+		},
+		PredicateType: SLSAProvenancePredicateType,
+		Predicate: slsaPredicate{
+			Builder:   inTotoBuilder{ID: builderID},
+			BuildType: SLSAProvenanceBuildType,
+			Invocation: slsaInvocation{
+				Parameters: map[string]string{
+					"id":      dependency.ID,
+					"version": dependency.Version,
+				},
+			},
+			Metadata: slsaMetadata{
+				BuildFinishedOn: time.Now().UTC(),
+			},
+			Materials: []slsaMaterial{
+				{URI: uri, Digest: digest},
+			},
+		},
+	}
+
+	err := json.NewEncoder(w).Encode(statement)
+	if err != nil {
+		return fmt.Errorf("failed to write slsa provenance: %w", err)
+	}
+
+	return nil
+}
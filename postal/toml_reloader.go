@@ -0,0 +1,78 @@
+package postal
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tomlReloader keeps the most recently parsed contents of each buildpack.toml
+// file it is asked for, re-reading every file in the background at a fixed
+// interval so that changes made to it while a build is running are picked up.
+// The background reloading stops once its context is done.
+type tomlReloader struct {
+	ctx      context.Context
+	interval time.Duration
+
+	mutex  sync.Mutex
+	loaded map[string]*atomic.Value
+}
+
+func newTOMLReloader(ctx context.Context, interval time.Duration) *tomlReloader {
+	return &tomlReloader{
+		ctx:      ctx,
+		interval: interval,
+		loaded:   map[string]*atomic.Value{},
+	}
+}
+
+// Parse returns the most recently loaded contents of the buildpack.toml at
+// the given path. The first call for a path parses the file right away and
+// starts reloading it in the background. A reload that fails to parse the
+// file keeps the previously loaded contents. Once the context of the reloader
+// is done, the file is parsed on every call instead.
+func (r *tomlReloader) Parse(path string, parse func(string) (buildpackMetadata, error)) (buildpackMetadata, error) {
+	if r.ctx.Err() != nil {
+		return parse(path)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if value, ok := r.loaded[path]; ok {
+		return value.Load().(buildpackMetadata), nil
+	}
+
+	buildpack, err := parse(path)
+	if err != nil {
+		return buildpackMetadata{}, err
+	}
+
+	value := &atomic.Value{}
+	value.Store(buildpack)
+	r.loaded[path] = value
+
+	go r.reload(path, value, parse)
+
+	return buildpack, nil
+}
+
+func (r *tomlReloader) reload(path string, value *atomic.Value, parse func(string) (buildpackMetadata, error)) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			buildpack, err := parse(path)
+			if err != nil {
+				continue
+			}
+
+			value.Store(buildpack)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package postal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxTrailerHashLength is the length of the longest hex-encoded hash that can
+// appear in a checksum trailer, which is that of a sha512 hash.
+const maxTrailerHashLength = 128
+
+// ErrTrailerChecksumMismatch is returned by TrailerChecksumReader when the
+// checksum in the trailer of the stream does not match the expected checksum.
+var ErrTrailerChecksumMismatch = errors.New("trailer checksum does not match")
+
+// TrailerChecksumReader reads a stream that ends with a checksum trailer made
+// up of a delimiter followed by a hex-encoded hash. The content that precedes
+// the trailer is returned from Read, and the hash in the trailer is compared
+// against an expected checksum once the end of the stream is reached. The
+// content itself is not hashed, so a TrailerChecksumReader is intended to be
+// used alongside the existing validation of the dependency content.
+type TrailerChecksumReader struct {
+	reader    io.Reader
+	delimiter []byte
+	checksum  Checksum
+
+	buffer  []byte
+	pending []byte
+	eof     bool
+	trailer string
+	err     error
+}
+
+// NewTrailerChecksumReader returns a TrailerChecksumReader that reads from
+// reader and compares the hash following the last occurrence of delimiter
+// against the given checksum. The checksum may be given as a bare hash, as in
+// Dependency.SHA256, or in the algorithm:hash form.
+func NewTrailerChecksumReader(reader io.Reader, delimiter []byte, checksum string) *TrailerChecksumReader {
+	return &TrailerChecksumReader{
+		reader:    reader,
+		delimiter: delimiter,
+		checksum:  Checksum(checksum),
+	}
+}
+
+// Read reads the content of the stream that precedes the checksum trailer.
+// When the end of the stream is reached, an error is returned instead of
+// io.EOF if the trailer is missing or does not match the expected checksum.
+func (r *TrailerChecksumReader) Read(p []byte) (int, error) {
+	// Enough of the stream is held back that the trailer is never returned as
+	// content before the end of the stream has been found.
+	holdback := len(r.delimiter) + maxTrailerHashLength
+
+	if r.buffer == nil {
+		r.buffer = make([]byte, 32*1024)
+	}
+
+	for !r.eof && len(r.pending) <= holdback+len(p) {
+		n, err := r.reader.Read(r.buffer)
+		r.pending = append(r.pending, r.buffer[:n]...)
+
+		if err == io.EOF {
+			r.eof = true
+			r.splitTrailer()
+			break
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	available := len(r.pending)
+	if !r.eof {
+		available -= holdback
+	}
+
+	n := copy(p, r.pending[:available])
+	r.pending = r.pending[n:]
+
+	if r.eof && len(r.pending) == 0 {
+		if r.err != nil {
+			return n, r.err
+		}
+
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Trailer returns the hash found in the trailer of the stream. It is empty
+// until the end of the stream has been read.
+func (r *TrailerChecksumReader) Trailer() string {
+	return r.trailer
+}
+
+func (r *TrailerChecksumReader) splitTrailer() {
+	index := bytes.LastIndex(r.pending, r.delimiter)
+	if index < 0 {
+		r.err = errors.New("failed to find checksum trailer")
+		return
+	}
+
+	r.trailer = strings.TrimSpace(string(r.pending[index+len(r.delimiter):]))
+	r.pending = r.pending[:index]
+
+	if !strings.EqualFold(r.trailer, r.checksum.Hash()) {
+		r.err = fmt.Errorf("%w: found %q, expected %q", ErrTrailerChecksumMismatch, r.trailer, r.checksum.Hash())
+	}
+}
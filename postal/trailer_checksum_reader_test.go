@@ -0,0 +1,86 @@
+package postal_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testTrailerChecksumReader(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		content []byte
+		hash    string
+		stream  []byte
+	)
+
+	it.Before(func() {
+		content = bytes.Repeat([]byte("some-content"), 10000)
+
+		sum := sha256.Sum256(content)
+		hash = hex.EncodeToString(sum[:])
+
+		stream = append(append(append([]byte{}, content...), []byte("--checksum--")...), []byte(hash+"\n")...)
+	})
+
+	context("Read", func() {
+		it("returns the content that precedes the trailer", func() {
+			reader := postal.NewTrailerChecksumReader(iotest.HalfReader(bytes.NewReader(stream)), []byte("--checksum--"), hash)
+
+			result, err := io.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(content))
+			Expect(reader.Trailer()).To(Equal(hash))
+		})
+
+		context("when the checksum is given in the algorithm:hash form", func() {
+			it("compares the hash portion", func() {
+				reader := postal.NewTrailerChecksumReader(bytes.NewReader(stream), []byte("--checksum--"), "sha256:"+hash)
+
+				_, err := io.ReadAll(reader)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the trailer does not match the checksum", func() {
+				it("returns an error once the stream has been read", func() {
+					reader := postal.NewTrailerChecksumReader(bytes.NewReader(stream), []byte("--checksum--"), "some-other-hash")
+
+					result, err := io.ReadAll(reader)
+					Expect(errors.Is(err, postal.ErrTrailerChecksumMismatch)).To(BeTrue())
+					Expect(err).To(MatchError(ContainSubstring(`expected "some-other-hash"`)))
+					Expect(result).To(Equal(content))
+				})
+			})
+
+			context("when the stream has no trailer", func() {
+				it("returns an error", func() {
+					reader := postal.NewTrailerChecksumReader(bytes.NewReader(content), []byte("--checksum--"), hash)
+
+					_, err := io.ReadAll(reader)
+					Expect(err).To(MatchError("failed to find checksum trailer"))
+				})
+			})
+
+			context("when the underlying reader fails", func() {
+				it("returns an error", func() {
+					reader := postal.NewTrailerChecksumReader(iotest.ErrReader(errors.New("some read error")), []byte("--checksum--"), hash)
+
+					_, err := io.ReadAll(reader)
+					Expect(err).To(MatchError("some read error"))
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package postal
+
+import (
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// VersionMatrix enumerates every (id, version, stack) combination of the
+// dependencies declared in a buildpack.toml file.
+type VersionMatrix struct {
+	entries map[string]map[string]map[string]Dependency
+}
+
+// NewVersionMatrix parses the buildpack.toml file at the given path and
+// returns a VersionMatrix describing its dependencies. An error is returned if
+// any dependency declares a version that is not valid SemVer.
+func NewVersionMatrix(path string) (*VersionMatrix, error) {
+	buildpack, err := parseBuildpack(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]map[string]map[string]Dependency{}
+	for _, dependency := range buildpack.Dependencies {
+		_, err := semver.NewVersion(dependency.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := entries[dependency.ID]; !ok {
+			entries[dependency.ID] = map[string]map[string]Dependency{}
+		}
+
+		if _, ok := entries[dependency.ID][dependency.Version]; !ok {
+			entries[dependency.ID][dependency.Version] = map[string]Dependency{}
+		}
+
+		for _, stack := range dependency.Stacks {
+			entries[dependency.ID][dependency.Version][stack] = dependency
+		}
+	}
+
+	return &VersionMatrix{entries: entries}, nil
+}
+
+// IDs returns the ids of every dependency in the matrix in alphabetical
+// order.
+func (m *VersionMatrix) IDs() []string {
+	var ids []string
+	for id := range m.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// VersionsFor returns every version of the dependency with the given id,
+// ordered from newest to oldest.
+func (m *VersionMatrix) VersionsFor(id string) []string {
+	var versions []string
+	for version := range m.entries[id] {
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return semver.MustParse(versions[i]).GreaterThan(semver.MustParse(versions[j]))
+	})
+
+	return versions
+}
+
+// StacksFor returns the stacks that the given version of the dependency with
+// the given id is built for in alphabetical order.
+func (m *VersionMatrix) StacksFor(id, version string) []string {
+	var stacks []string
+	for stack := range m.entries[id][version] {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	return stacks
+}
+
+// Get returns the dependency matching the given id, version, and stack. A
+// dependency that supports the wildcard stack is returned when no dependency
+// is built for that stack specifically.
+func (m *VersionMatrix) Get(id, version, stack string) (Dependency, bool) {
+	stacks := m.entries[id][version]
+
+	if dependency, ok := stacks[stack]; ok {
+		return dependency, true
+	}
+
+	dependency, ok := stacks["*"]
+	return dependency, ok
+}
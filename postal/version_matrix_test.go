@@ -0,0 +1,146 @@
+package postal_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testVersionMatrix(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		path string
+	)
+
+	it.Before(func() {
+		file, err := os.CreateTemp("", "buildpack.toml")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = file.Name()
+		_, err = file.WriteString(`
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-sha"
+stacks = ["some-stack"]
+uri = "some-uri"
+version = "1.2.3"
+
+[[metadata.dependencies]]
+id = "some-entry"
+sha256 = "some-other-sha"
+stacks = ["some-stack", "other-stack"]
+uri = "some-other-uri"
+version = "4.5.6"
+
+[[metadata.dependencies]]
+id = "other-entry"
+sha256 = "other-sha"
+stacks = ["*"]
+uri = "other-uri"
+version = "1.10.0"
+
+[[metadata.dependencies]]
+id = "other-entry"
+sha256 = "another-sha"
+stacks = ["some-stack"]
+uri = "another-uri"
+version = "1.9.0"
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(path)).To(Succeed())
+	})
+
+	context("IDs", func() {
+		it("returns every dependency id", func() {
+			matrix, err := postal.NewVersionMatrix(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(matrix.IDs()).To(Equal([]string{"other-entry", "some-entry"}))
+		})
+	})
+
+	context("VersionsFor", func() {
+		it("returns the versions of the dependency from newest to oldest", func() {
+			matrix, err := postal.NewVersionMatrix(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(matrix.VersionsFor("some-entry")).To(Equal([]string{"4.5.6", "1.2.3"}))
+			Expect(matrix.VersionsFor("other-entry")).To(Equal([]string{"1.10.0", "1.9.0"}))
+			Expect(matrix.VersionsFor("missing-entry")).To(BeEmpty())
+		})
+	})
+
+	context("StacksFor", func() {
+		it("returns the stacks of the dependency version", func() {
+			matrix, err := postal.NewVersionMatrix(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(matrix.StacksFor("some-entry", "4.5.6")).To(Equal([]string{"other-stack", "some-stack"}))
+			Expect(matrix.StacksFor("some-entry", "1.2.3")).To(Equal([]string{"some-stack"}))
+			Expect(matrix.StacksFor("other-entry", "1.10.0")).To(Equal([]string{"*"}))
+			Expect(matrix.StacksFor("some-entry", "7.8.9")).To(BeEmpty())
+		})
+	})
+
+	context("Get", func() {
+		it("returns the matching dependency", func() {
+			matrix, err := postal.NewVersionMatrix(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			dependency, ok := matrix.Get("some-entry", "4.5.6", "other-stack")
+			Expect(ok).To(BeTrue())
+			Expect(dependency).To(Equal(postal.Dependency{
+				ID:      "some-entry",
+				SHA256:  "some-other-sha",
+				Stacks:  []string{"some-stack", "other-stack"},
+				URI:     "some-other-uri",
+				Version: "4.5.6",
+			}))
+
+			_, ok = matrix.Get("some-entry", "1.2.3", "other-stack")
+			Expect(ok).To(BeFalse())
+		})
+
+		context("when the dependency supports the wildcard stack", func() {
+			it("returns the dependency for any stack", func() {
+				matrix, err := postal.NewVersionMatrix(path)
+				Expect(err).NotTo(HaveOccurred())
+
+				dependency, ok := matrix.Get("other-entry", "1.10.0", "some-stack")
+				Expect(ok).To(BeTrue())
+				Expect(dependency.URI).To(Equal("other-uri"))
+			})
+		})
+	})
+
+	context("failure cases", func() {
+		context("when the buildpack.toml cannot be parsed", func() {
+			it("returns an error", func() {
+				_, err := postal.NewVersionMatrix("/no/such/buildpack.toml")
+				Expect(err).To(MatchError(ContainSubstring("failed to parse buildpack.toml")))
+			})
+		})
+
+		context("when a dependency version is not valid semver", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(path, []byte(`
+[[metadata.dependencies]]
+id = "some-entry"
+stacks = ["some-stack"]
+version = "not-a-version"
+`), 0600)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := postal.NewVersionMatrix(path)
+				Expect(err).To(MatchError(ContainSubstring("Invalid Semantic Version")))
+			})
+		})
+	})
+}
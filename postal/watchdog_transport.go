@@ -0,0 +1,106 @@
+package postal
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// WatchdogTransport is a Transport that wraps another Transport and aborts
+// downloads that stall. When no bytes are received from a dependency for the
+// configured idle timeout, the underlying reader is closed and reading the
+// dependency fails.
+type WatchdogTransport struct {
+	inner       Transport
+	idleTimeout time.Duration
+}
+
+// NewWatchdogTransport returns a WatchdogTransport that fetches dependencies
+// using the given Transport and aborts them after idleTimeout has elapsed
+// without receiving any bytes.
+func NewWatchdogTransport(inner Transport, idleTimeout time.Duration) *WatchdogTransport {
+	return &WatchdogTransport{
+		inner:       inner,
+		idleTimeout: idleTimeout,
+	}
+}
+
+// Drop fetches the dependency at the given uri using the wrapped Transport
+// and returns a reader that fails if the download stalls.
+func (t *WatchdogTransport) Drop(root, uri string) (io.ReadCloser, error) {
+	readCloser, err := t.inner.Drop(root, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWatchdogReader(readCloser, t.idleTimeout), nil
+}
+
+// watchdogReader closes the reader it wraps when a read from it goes for
+// longer than the idle timeout without returning any bytes. The timer only
+// runs while a read is in progress so that a slow consumer does not trip it.
+type watchdogReader struct {
+	readCloser  io.ReadCloser
+	idleTimeout time.Duration
+	timer       *time.Timer
+
+	mutex    sync.Mutex
+	timedOut bool
+}
+
+func newWatchdogReader(readCloser io.ReadCloser, idleTimeout time.Duration) *watchdogReader {
+	r := &watchdogReader{
+		readCloser:  readCloser,
+		idleTimeout: idleTimeout,
+	}
+
+	r.timer = time.AfterFunc(idleTimeout, r.expire)
+	r.timer.Stop()
+
+	return r
+}
+
+func (r *watchdogReader) expire() {
+	r.mutex.Lock()
+	r.timedOut = true
+	r.mutex.Unlock()
+
+	r.readCloser.Close()
+}
+
+func (r *watchdogReader) Read(p []byte) (int, error) {
+	if r.expired() {
+		return 0, r.timeoutError()
+	}
+
+	r.timer.Reset(r.idleTimeout)
+	n, err := r.readCloser.Read(p)
+	r.timer.Stop()
+
+	if err != nil && r.expired() {
+		return n, r.timeoutError()
+	}
+
+	return n, err
+}
+
+func (r *watchdogReader) Close() error {
+	r.timer.Stop()
+	if r.expired() {
+		return nil
+	}
+
+	return r.readCloser.Close()
+}
+
+func (r *watchdogReader) expired() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.timedOut
+}
+
+func (r *watchdogReader) timeoutError() error {
+	return fmt.Errorf("failed to read dependency: no data received for %s", r.idleTimeout)
+}
@@ -0,0 +1,98 @@
+package postal_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/postal/fakes"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testWatchdogTransport(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect     = NewWithT(t).Expect
+		Eventually = NewWithT(t).Eventually
+
+		inner      *fakes.Transport
+		pipeReader *io.PipeReader
+		pipeWriter *io.PipeWriter
+
+		transport *postal.WatchdogTransport
+	)
+
+	it.Before(func() {
+		pipeReader, pipeWriter = io.Pipe()
+
+		inner = &fakes.Transport{}
+		inner.DropCall.Returns.ReadCloser = pipeReader
+
+		transport = postal.NewWatchdogTransport(inner, 100*time.Millisecond)
+	})
+
+	it.After(func() {
+		Expect(pipeWriter.Close()).To(Succeed())
+	})
+
+	context("Drop", func() {
+		it("returns the contents of the wrapped transport", func() {
+			go func() {
+				_, _ = pipeWriter.Write([]byte("some-"))
+				time.Sleep(50 * time.Millisecond)
+				_, _ = pipeWriter.Write([]byte("contents"))
+				_ = pipeWriter.Close()
+			}()
+
+			readCloser, err := transport.Drop("some-root", "some-uri")
+			Expect(err).NotTo(HaveOccurred())
+			defer readCloser.Close()
+
+			Expect(inner.DropCall.Receives.Root).To(Equal("some-root"))
+			Expect(inner.DropCall.Receives.Uri).To(Equal("some-uri"))
+
+			content, err := io.ReadAll(readCloser)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("some-contents"))
+		})
+
+		context("when the download stalls", func() {
+			it("returns an error once the idle timeout has elapsed", func() {
+				go func() {
+					_, _ = pipeWriter.Write([]byte("some-"))
+				}()
+
+				readCloser, err := transport.Drop("some-root", "some-uri")
+				Expect(err).NotTo(HaveOccurred())
+				defer readCloser.Close()
+
+				done := make(chan error)
+				go func() {
+					_, err := io.ReadAll(readCloser)
+					done <- err
+				}()
+
+				var readErr error
+				Eventually(done, "1s").Should(Receive(&readErr))
+				Expect(readErr).To(MatchError("failed to read dependency: no data received for 100ms"))
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the wrapped transport fails", func() {
+				it.Before(func() {
+					inner.DropCall.Returns.ReadCloser = nil
+					inner.DropCall.Returns.Error = errors.New("failed to drop")
+				})
+
+				it("returns the error", func() {
+					_, err := transport.Drop("some-root", "some-uri")
+					Expect(err).To(MatchError("failed to drop"))
+				})
+			})
+		})
+	})
+}
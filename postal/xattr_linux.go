@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package postal
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func setXAttrs(path string, attrs map[string][]byte) error {
+	for name, value := range attrs {
+		err := unix.Lsetxattr(path, name, value, 0)
+		if err != nil {
+			return fmt.Errorf("failed to set %q on %s: %w", name, path, err)
+		}
+	}
+
+	return nil
+}
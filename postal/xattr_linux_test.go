@@ -0,0 +1,188 @@
+//go:build linux
+// +build linux
+
+package postal_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/postal"
+	"github.com/paketo-buildpacks/packit/v2/postal/fakes"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/gomega"
+)
+
+// The revision and flag bits of a security.capability value, which are not
+// defined by golang.org/x/sys/unix.
+const (
+	vfsCapRevision2      = 0x02000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+func TestUnitPostalXAttr(t *testing.T) {
+	spec.Run(t, "packit/postal/xattr", testXAttr, spec.Report(report.Terminal{}))
+}
+
+func testXAttr(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		layerPath  string
+		dependency postal.Dependency
+		transport  *fakes.Transport
+		service    postal.Service
+	)
+
+	it.Before(func() {
+		var err error
+		layerPath, err = os.MkdirTemp("", "layer")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = unix.Setxattr(layerPath, "user.probe", []byte("probe"), 0)
+		if errors.Is(err, unix.ENOTSUP) {
+			t.Skip("the filesystem does not support extended attributes")
+		}
+		Expect(err).NotTo(HaveOccurred())
+
+		buffer := bytes.NewBuffer(nil)
+		zw := gzip.NewWriter(buffer)
+		tw := tar.NewWriter(zw)
+
+		Expect(tw.WriteHeader(&tar.Header{Name: "bin/", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+
+		for _, file := range []string{"bin/some-tool", "some-file"} {
+			Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+			_, err = tw.Write([]byte(file))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(tw.Close()).To(Succeed())
+		Expect(zw.Close()).To(Succeed())
+
+		sum := sha256.Sum256(buffer.Bytes())
+
+		transport = &fakes.Transport{}
+		transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+
+		service = postal.NewService(transport).
+			WithDependencyMappingResolver(&fakes.MappingResolver{}).
+			WithDependencyMirrorResolver(&fakes.MirrorResolver{})
+
+		dependency = postal.Dependency{
+			ID:      "some-entry",
+			Stacks:  []string{"some-stack"},
+			URI:     "some-entry.tgz",
+			SHA256:  hex.EncodeToString(sum[:]),
+			Version: "1.2.3",
+		}
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(layerPath)).To(Succeed())
+	})
+
+	it("sets the extended attributes returned by the mapper", func() {
+		var names []string
+		err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir",
+			postal.WithXAttrMapper(func(header *tar.Header) map[string][]byte {
+				names = append(names, header.Name)
+
+				if header.Name == "bin/some-tool" {
+					return map[string][]byte{"user.some-attribute": []byte("some-value")}
+				}
+
+				return nil
+			}),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(names).To(ConsistOf("bin/", "bin/some-tool", "some-file"))
+
+		value := make([]byte, 64)
+		n, err := unix.Getxattr(filepath.Join(layerPath, "bin", "some-tool"), "user.some-attribute", value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(value[:n])).To(Equal("some-value"))
+
+		_, err = unix.Getxattr(filepath.Join(layerPath, "some-file"), "user.some-attribute", value)
+		Expect(err).To(MatchError(unix.ENODATA))
+	})
+
+	context("when capabilities are preserved", func() {
+		var capability []byte
+
+		it.Before(func() {
+			// A version 2 capability set with CAP_NET_BIND_SERVICE permitted and
+			// effective
+			capability = make([]byte, 20)
+			binary.LittleEndian.PutUint32(capability[0:], vfsCapRevision2|vfsCapFlagsEffective)
+			binary.LittleEndian.PutUint32(capability[4:], 1<<unix.CAP_NET_BIND_SERVICE)
+
+			probe := filepath.Join(layerPath, "probe")
+			Expect(os.WriteFile(probe, nil, 0644)).To(Succeed())
+
+			err := unix.Setxattr(probe, "security.capability", capability, 0)
+			if errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENOTSUP) {
+				t.Skip("file capabilities cannot be set in this environment")
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Remove(probe)).To(Succeed())
+
+			buffer := bytes.NewBuffer(nil)
+			zw := gzip.NewWriter(buffer)
+			tw := tar.NewWriter(zw)
+
+			Expect(tw.WriteHeader(&tar.Header{
+				Name:       "bin/some-server",
+				Mode:       0755,
+				Size:       int64(len("some-server")),
+				Format:     tar.FormatPAX,
+				PAXRecords: map[string]string{"SCHILY.xattr.security.capability": string(capability)},
+			})).To(Succeed())
+			_, err = tw.Write([]byte("some-server"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tw.Close()).To(Succeed())
+			Expect(zw.Close()).To(Succeed())
+
+			sum := sha256.Sum256(buffer.Bytes())
+			dependency.SHA256 = hex.EncodeToString(sum[:])
+			transport.DropCall.Returns.ReadCloser = io.NopCloser(buffer)
+		})
+
+		it("sets the capabilities recorded in the archive", func() {
+			err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir", postal.WithPreserveCapabilities())
+			Expect(err).NotTo(HaveOccurred())
+
+			value := make([]byte, 64)
+			n, err := unix.Getxattr(filepath.Join(layerPath, "bin", "some-server"), "security.capability", value)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value[:n]).To(Equal(capability))
+		})
+	})
+
+	context("failure cases", func() {
+		context("when an attribute cannot be set", func() {
+			it("returns an error", func() {
+				err := service.Deliver(dependency, "some-cnb-path", layerPath, "some-platform-dir",
+					postal.WithXAttrMapper(func(header *tar.Header) map[string][]byte {
+						return map[string][]byte{"invalid-namespace.some-attribute": []byte("some-value")}
+					}),
+				)
+				Expect(err).To(MatchError(ContainSubstring("failed to set extended attributes")))
+			})
+		})
+	})
+}
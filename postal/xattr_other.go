@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package postal
+
+import "errors"
+
+func setXAttrs(path string, attrs map[string][]byte) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	return errors.New("extended attributes are not supported on this platform")
+}
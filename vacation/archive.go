@@ -2,8 +2,10 @@ package vacation
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/gabriel-vasile/mimetype"
 )
@@ -12,12 +14,19 @@ type Decompressor interface {
 	Decompress(destination string) error
 }
 
-// An Archive decompresses tar, gzip, xz, and bzip2 compressed tar, and zip files from
-// an input stream.
+// An Archive decompresses tar, gzip, xz, bzip2, lz4, and brotli compressed tar,
+// zip, rpm, and deb files from an input stream.
 type Archive struct {
 	reader     io.Reader
 	components int
 	name       string
+	extension  string
+
+	preserveTimestamps   bool
+	preserveCapabilities bool
+	globs                []string
+	workers              int
+	stats                *Stats
 }
 
 // NewArchive returns a new Archive that reads from inputReader.
@@ -37,6 +46,10 @@ func NewArchive(inputReader io.Reader) Archive {
 // - "application/octet-stream"
 // and write the contents of the input stream to a file name specified by the
 // `Archive.WithName()` option in the destination directory.
+//
+// Brotli compressed files have no magic number, so they are only detected when
+// the extension given with `Archive.WithExtension()` is ".br", or when the name
+// given with `Archive.WithName()` has a ".br" suffix.
 func (a Archive) Decompress(destination string) error {
 	// Convert reader into a buffered read so that the header can be peeked to
 	// determine the type.
@@ -50,27 +63,87 @@ func (a Archive) Decompress(destination string) error {
 		return err
 	}
 
+	// LZ4 frames are not recognized by the mimetype library, so they are
+	// detected by their magic number instead
+	if bytes.HasPrefix(header, lz4Magic) {
+		lz4Archive := NewLZ4Archive(bufferedReader).StripComponents(a.components).WithName(a.name).WithGlobs(a.globs...).WithWorkers(a.workers).WithStats(a.stats)
+		if a.preserveTimestamps {
+			lz4Archive = lz4Archive.PreserveTimestamps()
+		}
+		if a.preserveCapabilities {
+			lz4Archive = lz4Archive.PreserveCapabilities()
+		}
+
+		return lz4Archive.Decompress(destination)
+	}
+
+	// Brotli streams have no magic number, so they are detected by the .br
+	// extension of the file they were read from, or of their name, instead
+	if a.extension == ".br" || strings.HasSuffix(a.name, ".br") {
+		brotliArchive := NewBrotliArchive(bufferedReader).StripComponents(a.components).WithName(a.name).WithGlobs(a.globs...).WithWorkers(a.workers).WithStats(a.stats)
+		if a.preserveTimestamps {
+			brotliArchive = brotliArchive.PreserveTimestamps()
+		}
+		if a.preserveCapabilities {
+			brotliArchive = brotliArchive.PreserveCapabilities()
+		}
+
+		return brotliArchive.Decompress(destination)
+	}
+
 	mime := mimetype.Detect(header)
 
 	// This switch case is responsible for determining the decompression strategy
 	var decompressor Decompressor
 	switch mime.String() {
 	case "application/x-tar":
-		decompressor = NewTarArchive(bufferedReader).StripComponents(a.components)
+		tarArchive := NewTarArchive(bufferedReader).StripComponents(a.components).WithGlobs(a.globs...).WithWorkers(a.workers).WithStats(a.stats)
+		if a.preserveTimestamps {
+			tarArchive = tarArchive.PreserveTimestamps()
+		}
+		if a.preserveCapabilities {
+			tarArchive = tarArchive.PreserveCapabilities()
+		}
+		decompressor = tarArchive
 	case "application/gzip":
-		decompressor = NewGzipArchive(bufferedReader).StripComponents(a.components).WithName(a.name)
+		gzipArchive := NewGzipArchive(bufferedReader).StripComponents(a.components).WithName(a.name).WithGlobs(a.globs...).WithWorkers(a.workers).WithStats(a.stats)
+		if a.preserveTimestamps {
+			gzipArchive = gzipArchive.PreserveTimestamps()
+		}
+		if a.preserveCapabilities {
+			gzipArchive = gzipArchive.PreserveCapabilities()
+		}
+		decompressor = gzipArchive
 	case "application/x-xz":
-		decompressor = NewXZArchive(bufferedReader).StripComponents(a.components).WithName(a.name)
+		xzArchive := NewXZArchive(bufferedReader).StripComponents(a.components).WithName(a.name).WithGlobs(a.globs...).WithWorkers(a.workers).WithStats(a.stats)
+		if a.preserveTimestamps {
+			xzArchive = xzArchive.PreserveTimestamps()
+		}
+		if a.preserveCapabilities {
+			xzArchive = xzArchive.PreserveCapabilities()
+		}
+		decompressor = xzArchive
 	case "application/x-bzip2":
-		decompressor = NewBzip2Archive(bufferedReader).StripComponents(a.components).WithName(a.name)
+		bzip2Archive := NewBzip2Archive(bufferedReader).StripComponents(a.components).WithName(a.name).WithGlobs(a.globs...).WithWorkers(a.workers).WithStats(a.stats)
+		if a.preserveTimestamps {
+			bzip2Archive = bzip2Archive.PreserveTimestamps()
+		}
+		if a.preserveCapabilities {
+			bzip2Archive = bzip2Archive.PreserveCapabilities()
+		}
+		decompressor = bzip2Archive
 	case "application/zip":
-		decompressor = NewZipArchive(bufferedReader).StripComponents(a.components)
+		decompressor = NewZipArchive(bufferedReader).StripComponents(a.components).WithStats(a.stats)
+	case "application/x-rpm":
+		decompressor = NewRpmArchive(bufferedReader).StripComponents(a.components).WithStats(a.stats)
+	case "application/vnd.debian.binary-package":
+		decompressor = NewDebArchive(bufferedReader).StripComponents(a.components).WithStats(a.stats)
 	case "application/x-executable":
-		decompressor = NewExecutable(bufferedReader).WithName(a.name)
+		decompressor = NewExecutable(bufferedReader).WithName(a.name).WithStats(a.stats)
 	case "text/plain; charset=utf-8",
 		"application/jar",
 		"application/octet-stream":
-		decompressor = NewNopArchive(bufferedReader).WithName(a.name)
+		decompressor = NewNopArchive(bufferedReader).WithName(a.name).WithStats(a.stats)
 	default:
 		return fmt.Errorf("unsupported archive type: %s", mime.String())
 	}
@@ -93,3 +166,52 @@ func (a Archive) WithName(name string) Archive {
 	a.name = name
 	return a
 }
+
+// WithExtension provides the extension, such as ".br", of the file that the
+// input stream is read from. It is used to detect archive types that cannot be
+// detected from their content, when the name given with WithName does not
+// carry that extension.
+func (a Archive) WithExtension(extension string) Archive {
+	a.extension = extension
+	return a
+}
+
+// PreserveTimestamps causes the access and modification times recorded in a
+// tar archive, compressed or not, to be applied to the directories and files
+// extracted from it. Setting this is a no-op for other archive types.
+func (a Archive) PreserveTimestamps() Archive {
+	a.preserveTimestamps = true
+	return a
+}
+
+// WithGlobs causes only the entries of a tar archive, compressed or not, whose
+// paths match one of the given glob patterns to be extracted. See
+// TarArchive.WithGlobs. Setting this is a no-op for other archive types.
+func (a Archive) WithGlobs(globs ...string) Archive {
+	a.globs = globs
+	return a
+}
+
+// WithWorkers causes the regular files of a tar archive, compressed or not, to
+// be written by the given number of goroutines. See TarArchive.WithWorkers.
+// Setting this is a no-op for other archive types.
+func (a Archive) WithWorkers(workers int) Archive {
+	a.workers = workers
+	return a
+}
+
+// PreserveCapabilities causes the file capabilities recorded in a tar archive,
+// compressed or not, to be applied to the files extracted from it. See
+// TarArchive.PreserveCapabilities. Setting this is a no-op for other archive
+// types.
+func (a Archive) PreserveCapabilities() Archive {
+	a.preserveCapabilities = true
+	return a
+}
+
+// WithStats causes the entries written into the destination to be counted in
+// the given Stats, whatever the type of the archive.
+func (a Archive) WithStats(stats *Stats) Archive {
+	a.stats = stats
+	return a
+}
@@ -12,8 +12,10 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/andybalholm/brotli"
 	dsnetBzip2 "github.com/dsnet/compress/bzip2"
 	"github.com/paketo-buildpacks/packit/v2/vacation"
+	"github.com/pierrec/lz4/v4"
 	"github.com/sclevine/spec"
 	"github.com/ulikunitz/xz"
 
@@ -146,6 +148,18 @@ func testArchive(t *testing.T, context spec.G, it spec.S) {
 					filepath.Join(tempDir, "some-nested-file"),
 				}))
 			})
+
+			it("counts the entries written into the path in the given stats", func() {
+				var stats vacation.Stats
+				err := archive.WithStats(&stats).Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stats).To(Equal(vacation.Stats{
+					Files: 2,
+					Bytes: int64(len(filepath.Join("some-dir", "some-nested-file")) + len("some-file")),
+					Dirs:  1,
+				}))
+			})
 		})
 
 		context("when passed the reader of a tar xz file", func() {
@@ -212,6 +226,150 @@ func testArchive(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when passed the reader of a tar lz4 file", func() {
+			var (
+				archive vacation.Archive
+				tempDir string
+			)
+
+			it.Before(func() {
+				var err error
+				tempDir, err = os.MkdirTemp("", "vacation")
+				Expect(err).NotTo(HaveOccurred())
+
+				buffer := bytes.NewBuffer(nil)
+				lz4w := lz4.NewWriter(buffer)
+
+				tw := tar.NewWriter(lz4w)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-dir", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+				_, err = tw.Write(nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				nestedFile := filepath.Join("some-dir", "some-nested-file")
+				Expect(tw.WriteHeader(&tar.Header{Name: nestedFile, Mode: 0755, Size: int64(len(nestedFile))})).To(Succeed())
+				_, err = tw.Write([]byte(nestedFile))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0755, Size: int64(len("some-file"))})).To(Succeed())
+				_, err = tw.Write([]byte("some-file"))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(lz4w.Close()).To(Succeed())
+
+				archive = vacation.NewArchive(buffer)
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(tempDir)).To(Succeed())
+			})
+
+			it("unpackages the archive into the path", func() {
+				err := archive.Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(filepath.Join(tempDir, "*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(tempDir, "some-dir"),
+					filepath.Join(tempDir, "some-file"),
+				}))
+			})
+
+			it("unpackages the archive into the path but also strips the first component", func() {
+				err := archive.StripComponents(1).Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(filepath.Join(tempDir, "*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(tempDir, "some-nested-file"),
+				}))
+			})
+		})
+
+		context("when passed the reader of a tar brotli file", func() {
+			var (
+				archive vacation.Archive
+				tempDir string
+			)
+
+			it.Before(func() {
+				var err error
+				tempDir, err = os.MkdirTemp("", "vacation")
+				Expect(err).NotTo(HaveOccurred())
+
+				buffer := bytes.NewBuffer(nil)
+				bw := brotli.NewWriter(buffer)
+
+				tw := tar.NewWriter(bw)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-dir", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+				_, err = tw.Write(nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				nestedFile := filepath.Join("some-dir", "some-nested-file")
+				Expect(tw.WriteHeader(&tar.Header{Name: nestedFile, Mode: 0755, Size: int64(len(nestedFile))})).To(Succeed())
+				_, err = tw.Write([]byte(nestedFile))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0755, Size: int64(len("some-file"))})).To(Succeed())
+				_, err = tw.Write([]byte("some-file"))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+				Expect(bw.Close()).To(Succeed())
+
+				archive = vacation.NewArchive(buffer).WithName("some-archive.tar.br")
+			})
+
+			it.After(func() {
+				Expect(os.RemoveAll(tempDir)).To(Succeed())
+			})
+
+			it("unpackages the archive into the path", func() {
+				err := archive.Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(filepath.Join(tempDir, "*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(tempDir, "some-dir"),
+					filepath.Join(tempDir, "some-file"),
+				}))
+			})
+
+			it("unpackages the archive into the path but also strips the first component", func() {
+				err := archive.StripComponents(1).Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(filepath.Join(tempDir, "*"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(tempDir, "some-nested-file"),
+				}))
+			})
+
+			context("when the name does not have a .br suffix but the extension is given", func() {
+				it.Before(func() {
+					archive = archive.WithName("some-archive").WithExtension(".br")
+				})
+
+				it("unpackages the archive into the path", func() {
+					err := archive.Decompress(tempDir)
+					Expect(err).NotTo(HaveOccurred())
+
+					files, err := filepath.Glob(filepath.Join(tempDir, "*"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(files).To(ConsistOf([]string{
+						filepath.Join(tempDir, "some-dir"),
+						filepath.Join(tempDir, "some-file"),
+					}))
+				})
+			})
+		})
+
 		context("when passed the reader of a bzip2 file", func() {
 			var (
 				archive vacation.Archive
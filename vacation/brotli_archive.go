@@ -0,0 +1,95 @@
+package vacation
+
+import (
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// A BrotliArchive decompresses brotli files from an input stream.
+type BrotliArchive struct {
+	reader     io.Reader
+	components int
+	name       string
+
+	preserveTimestamps   bool
+	preserveCapabilities bool
+	globs                []string
+	workers              int
+	stats                *Stats
+}
+
+// NewBrotliArchive returns a new BrotliArchive that reads from inputReader.
+func NewBrotliArchive(inputReader io.Reader) BrotliArchive {
+	return BrotliArchive{reader: inputReader}
+}
+
+// Decompress reads from BrotliArchive and writes files into the destination
+// specified.
+func (brotliArchive BrotliArchive) Decompress(destination string) error {
+	// The suffix is removed from the name so that the decompressed stream is
+	// not detected as a brotli file again
+	name := strings.TrimSuffix(brotliArchive.name, ".br")
+
+	archive := NewArchive(brotli.NewReader(brotliArchive.reader)).WithName(name).StripComponents(brotliArchive.components).WithGlobs(brotliArchive.globs...).WithWorkers(brotliArchive.workers).WithStats(brotliArchive.stats)
+	if brotliArchive.preserveTimestamps {
+		archive = archive.PreserveTimestamps()
+	}
+	if brotliArchive.preserveCapabilities {
+		archive = archive.PreserveCapabilities()
+	}
+
+	return archive.Decompress(destination)
+}
+
+// StripComponents behaves like the --strip-components flag on tar command
+// removing the first n levels from the final decompression destination.
+func (brotliArchive BrotliArchive) StripComponents(components int) BrotliArchive {
+	brotliArchive.components = components
+	return brotliArchive
+}
+
+// WithName provides a way of overriding the name of the file
+// that the decompressed file will be copied into.
+func (brotliArchive BrotliArchive) WithName(name string) BrotliArchive {
+	brotliArchive.name = name
+	return brotliArchive
+}
+
+// PreserveTimestamps causes the access and modification times recorded in a
+// tar archive to be applied to the directories and files extracted from it.
+func (brotliArchive BrotliArchive) PreserveTimestamps() BrotliArchive {
+	brotliArchive.preserveTimestamps = true
+	return brotliArchive
+}
+
+// WithGlobs causes only the entries of a tar archive whose paths, after any
+// components have been stripped, match one of the given glob patterns to be
+// extracted. See TarArchive.WithGlobs.
+func (brotliArchive BrotliArchive) WithGlobs(globs ...string) BrotliArchive {
+	brotliArchive.globs = globs
+	return brotliArchive
+}
+
+// WithWorkers causes the regular files of a tar archive to be written by the
+// given number of goroutines. See TarArchive.WithWorkers.
+func (brotliArchive BrotliArchive) WithWorkers(workers int) BrotliArchive {
+	brotliArchive.workers = workers
+	return brotliArchive
+}
+
+// PreserveCapabilities causes the file capabilities recorded in a tar archive
+// to be applied to the files extracted from it. See
+// TarArchive.PreserveCapabilities.
+func (brotliArchive BrotliArchive) PreserveCapabilities() BrotliArchive {
+	brotliArchive.preserveCapabilities = true
+	return brotliArchive
+}
+
+// WithStats causes the entries written into the destination to be counted in
+// the given Stats.
+func (brotliArchive BrotliArchive) WithStats(stats *Stats) BrotliArchive {
+	brotliArchive.stats = stats
+	return brotliArchive
+}
@@ -10,6 +10,12 @@ type Bzip2Archive struct {
 	reader     io.Reader
 	components int
 	name       string
+
+	preserveTimestamps   bool
+	preserveCapabilities bool
+	globs                []string
+	workers              int
+	stats                *Stats
 }
 
 // NewBzip2Archive returns a new Bzip2Archive that reads from inputReader.
@@ -20,7 +26,15 @@ func NewBzip2Archive(inputReader io.Reader) Bzip2Archive {
 // Decompress reads from Bzip2Archive and writes files into the destination
 // specified.
 func (bz Bzip2Archive) Decompress(destination string) error {
-	return NewArchive(bzip2.NewReader(bz.reader)).WithName(bz.name).StripComponents(bz.components).Decompress(destination)
+	archive := NewArchive(bzip2.NewReader(bz.reader)).WithName(bz.name).StripComponents(bz.components).WithGlobs(bz.globs...).WithWorkers(bz.workers).WithStats(bz.stats)
+	if bz.preserveTimestamps {
+		archive = archive.PreserveTimestamps()
+	}
+	if bz.preserveCapabilities {
+		archive = archive.PreserveCapabilities()
+	}
+
+	return archive.Decompress(destination)
 }
 
 // StripComponents behaves like the --strip-components flag on tar command
@@ -36,3 +50,40 @@ func (bz Bzip2Archive) WithName(name string) Bzip2Archive {
 	bz.name = name
 	return bz
 }
+
+// PreserveTimestamps causes the access and modification times recorded in a
+// tar archive to be applied to the directories and files extracted from it.
+func (bz Bzip2Archive) PreserveTimestamps() Bzip2Archive {
+	bz.preserveTimestamps = true
+	return bz
+}
+
+// WithGlobs causes only the entries of a tar archive whose paths, after any
+// components have been stripped, match one of the given glob patterns to be
+// extracted. See TarArchive.WithGlobs.
+func (bz Bzip2Archive) WithGlobs(globs ...string) Bzip2Archive {
+	bz.globs = globs
+	return bz
+}
+
+// WithWorkers causes the regular files of a tar archive to be written by the
+// given number of goroutines. See TarArchive.WithWorkers.
+func (bz Bzip2Archive) WithWorkers(workers int) Bzip2Archive {
+	bz.workers = workers
+	return bz
+}
+
+// PreserveCapabilities causes the file capabilities recorded in a tar archive
+// to be applied to the files extracted from it. See
+// TarArchive.PreserveCapabilities.
+func (bz Bzip2Archive) PreserveCapabilities() Bzip2Archive {
+	bz.preserveCapabilities = true
+	return bz
+}
+
+// WithStats causes the entries written into the destination to be counted in
+// the given Stats.
+func (bz Bzip2Archive) WithStats(stats *Stats) Bzip2Archive {
+	bz.stats = stats
+	return bz
+}
@@ -0,0 +1,113 @@
+//go:build linux
+// +build linux
+
+package vacation_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/vacation"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/gomega"
+)
+
+// The revision and flag bits of a security.capability value, which are not
+// defined by golang.org/x/sys/unix.
+const (
+	vfsCapRevision2      = 0x02000000
+	vfsCapFlagsEffective = 0x000001
+)
+
+func TestVacationCapability(t *testing.T) {
+	spec.Run(t, "vacation/capability", testCapability, spec.Report(report.Terminal{}))
+}
+
+func testCapability(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		tempDir    string
+		capability []byte
+		tarArchive vacation.TarArchive
+	)
+
+	it.Before(func() {
+		var err error
+		tempDir, err = os.MkdirTemp("", "vacation")
+		Expect(err).NotTo(HaveOccurred())
+
+		// A version 2 capability set with CAP_NET_BIND_SERVICE permitted and
+		// effective
+		capability = make([]byte, 20)
+		binary.LittleEndian.PutUint32(capability[0:], vfsCapRevision2|vfsCapFlagsEffective)
+		binary.LittleEndian.PutUint32(capability[4:], 1<<unix.CAP_NET_BIND_SERVICE)
+
+		probe := filepath.Join(tempDir, "probe")
+		Expect(os.WriteFile(probe, nil, 0644)).To(Succeed())
+
+		err = unix.Setxattr(probe, "security.capability", capability, 0)
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENOTSUP) {
+			t.Skip("file capabilities cannot be set in this environment")
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(probe)).To(Succeed())
+
+		buffer := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buffer)
+
+		Expect(tw.WriteHeader(&tar.Header{
+			Name:       "some-server",
+			Mode:       0755,
+			Size:       int64(len("some-server")),
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{"SCHILY.xattr.security.capability": string(capability)},
+		})).To(Succeed())
+		_, err = tw.Write([]byte("some-server"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0644, Size: int64(len("some-file"))})).To(Succeed())
+		_, err = tw.Write([]byte("some-file"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(tw.Close()).To(Succeed())
+
+		tarArchive = vacation.NewTarArchive(bytes.NewReader(buffer.Bytes()))
+	})
+
+	it.After(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	it("applies the capabilities recorded in the archive", func() {
+		err := tarArchive.PreserveCapabilities().Decompress(tempDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		value := make([]byte, 64)
+		n, err := unix.Getxattr(filepath.Join(tempDir, "some-server"), "security.capability", value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value[:n]).To(Equal(capability))
+
+		_, err = unix.Getxattr(filepath.Join(tempDir, "some-file"), "security.capability", value)
+		Expect(err).To(MatchError(unix.ENODATA))
+	})
+
+	context("when capabilities are not preserved", func() {
+		it("does not apply the capabilities", func() {
+			err := tarArchive.Decompress(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			value := make([]byte, 64)
+			_, err = unix.Getxattr(filepath.Join(tempDir, "some-server"), "security.capability", value)
+			Expect(err).To(MatchError(unix.ENODATA))
+		})
+	})
+}
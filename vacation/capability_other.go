@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package vacation
+
+import "errors"
+
+func setCapability(path string, value []byte) error {
+	return errors.New("file capabilities are not supported on this platform")
+}
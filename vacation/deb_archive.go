@@ -0,0 +1,81 @@
+package vacation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A DebArchive decompresses the data member of debian packages from an input
+// stream.
+type DebArchive struct {
+	reader     io.Reader
+	components int
+	stats      *Stats
+}
+
+// NewDebArchive returns a new DebArchive that reads from inputReader.
+func NewDebArchive(inputReader io.Reader) DebArchive {
+	return DebArchive{reader: inputReader}
+}
+
+// Decompress reads from DebArchive and writes the files contained in the
+// data.tar member of the package into the destination specified. The member
+// may be an uncompressed tar file or one that is compressed using gzip, xz, or
+// bzip2.
+func (da DebArchive) Decompress(destination string) error {
+	magic := make([]byte, 8)
+	_, err := io.ReadFull(da.reader, magic)
+	if err != nil {
+		return fmt.Errorf("failed to read deb header: %w", err)
+	}
+
+	if string(magic) != "!<arch>\n" {
+		return errors.New("failed to read deb header: invalid magic")
+	}
+
+	for {
+		header := make([]byte, 60)
+		_, err := io.ReadFull(da.reader, header)
+		if err == io.EOF {
+			return errors.New("failed to find data.tar member in deb package")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read deb member header: %w", err)
+		}
+
+		// The GNU variant of the ar format terminates member names with a "/"
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to read deb member header: %w", err)
+		}
+
+		if strings.HasPrefix(name, "data.tar") {
+			return NewArchive(io.LimitReader(da.reader, size)).StripComponents(da.components).WithStats(da.stats).Decompress(destination)
+		}
+
+		// Member data is padded out to an even number of bytes
+		_, err = io.CopyN(io.Discard, da.reader, size+size%2)
+		if err != nil {
+			return fmt.Errorf("failed to read deb member %q: %w", name, err)
+		}
+	}
+}
+
+// StripComponents behaves like the --strip-components flag on tar command
+// removing the first n levels from the final decompression destination.
+func (da DebArchive) StripComponents(components int) DebArchive {
+	da.components = components
+	return da
+}
+
+// WithStats causes the entries written into the destination from the
+// data.tar member of the package to be counted in the given Stats.
+func (da DebArchive) WithStats(stats *Stats) DebArchive {
+	da.stats = stats
+	return da
+}
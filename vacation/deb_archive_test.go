@@ -0,0 +1,123 @@
+package vacation_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/vacation"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+// buildDeb assembles a minimal debian package, an ar archive made up of the
+// given members in order.
+func buildDeb(members map[string][]byte, order ...string) []byte {
+	buffer := bytes.NewBufferString("!<arch>\n")
+	for _, name := range order {
+		content := members[name]
+		fmt.Fprintf(buffer, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name+"/", 0, 0, 0, "100644", len(content))
+		buffer.Write(content)
+		if len(content)%2 != 0 {
+			buffer.WriteString("\n")
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+func testDebArchive(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	context("Decompress", func() {
+		var (
+			tempDir    string
+			debArchive vacation.DebArchive
+		)
+
+		it.Before(func() {
+			var err error
+			tempDir, err = os.MkdirTemp("", "vacation")
+			Expect(err).NotTo(HaveOccurred())
+
+			buffer := bytes.NewBuffer(nil)
+			gw := gzip.NewWriter(buffer)
+			tw := tar.NewWriter(gw)
+
+			Expect(tw.WriteHeader(&tar.Header{Name: "./usr/", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+			Expect(tw.WriteHeader(&tar.Header{Name: "./usr/bin/", Mode: 0755, Typeflag: tar.TypeDir})).To(Succeed())
+
+			file := "./usr/bin/some-tool"
+			Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file))})).To(Succeed())
+			_, err = tw.Write([]byte(file))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tw.Close()).To(Succeed())
+			Expect(gw.Close()).To(Succeed())
+
+			deb := buildDeb(map[string][]byte{
+				"debian-binary":  []byte("2.0\n"),
+				"control.tar.gz": []byte("some-control"),
+				"data.tar.gz":    buffer.Bytes(),
+			}, "debian-binary", "control.tar.gz", "data.tar.gz")
+
+			debArchive = vacation.NewDebArchive(bytes.NewReader(deb))
+		})
+
+		it.After(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		it("unpackages the data member into the path", func() {
+			err := debArchive.Decompress(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			content, err := os.ReadFile(filepath.Join(tempDir, "usr", "bin", "some-tool"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("./usr/bin/some-tool"))
+
+			info, err := os.Stat(filepath.Join(tempDir, "usr", "bin", "some-tool"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+		})
+
+		it("unpackages the data member into the path but also strips the first component", func() {
+			err := debArchive.StripComponents(1).Decompress(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			files, err := filepath.Glob(fmt.Sprintf("%s/*", tempDir))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(ConsistOf([]string{
+				filepath.Join(tempDir, "bin"),
+			}))
+		})
+
+		context("failure cases", func() {
+			context("when the input is not an ar archive", func() {
+				it("returns an error", func() {
+					err := vacation.NewDebArchive(bytes.NewBufferString("not an ar archive")).Decompress(tempDir)
+					Expect(err).To(MatchError("failed to read deb header: invalid magic"))
+				})
+			})
+
+			context("when there is no data member", func() {
+				it("returns an error", func() {
+					deb := buildDeb(map[string][]byte{
+						"debian-binary":  []byte("2.0\n"),
+						"control.tar.gz": []byte("some-control"),
+					}, "debian-binary", "control.tar.gz")
+
+					err := vacation.NewDebArchive(bytes.NewReader(deb)).Decompress(tempDir)
+					Expect(err).To(MatchError("failed to find data.tar member in deb package"))
+				})
+			})
+		})
+	})
+}
@@ -12,6 +12,7 @@ import (
 type Executable struct {
 	reader io.Reader
 	name   string
+	stats  *Stats
 }
 
 // NewExecutable returns a new Executable that reads from inputReader.
@@ -31,7 +32,7 @@ func (e Executable) Decompress(destination string) error {
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, e.reader)
+	n, err := io.Copy(file, e.reader)
 	if err != nil {
 		return err
 	}
@@ -41,6 +42,8 @@ func (e Executable) Decompress(destination string) error {
 		return err
 	}
 
+	e.stats.addFile(n)
+
 	return nil
 }
 
@@ -52,3 +55,10 @@ func (e Executable) WithName(name string) Executable {
 	}
 	return e
 }
+
+// WithStats causes the file written into the destination to be counted in the
+// given Stats.
+func (e Executable) WithStats(stats *Stats) Executable {
+	e.stats = stats
+	return e
+}
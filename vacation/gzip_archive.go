@@ -11,6 +11,12 @@ type GzipArchive struct {
 	reader     io.Reader
 	components int
 	name       string
+
+	preserveTimestamps   bool
+	preserveCapabilities bool
+	globs                []string
+	workers              int
+	stats                *Stats
 }
 
 // NewGzipArchive returns a new GzipArchive that reads from inputReader.
@@ -26,7 +32,15 @@ func (gz GzipArchive) Decompress(destination string) error {
 		return fmt.Errorf("failed to create gzip reader: %w", err)
 	}
 
-	return NewArchive(gzr).WithName(gz.name).StripComponents(gz.components).Decompress(destination)
+	archive := NewArchive(gzr).WithName(gz.name).StripComponents(gz.components).WithGlobs(gz.globs...).WithWorkers(gz.workers).WithStats(gz.stats)
+	if gz.preserveTimestamps {
+		archive = archive.PreserveTimestamps()
+	}
+	if gz.preserveCapabilities {
+		archive = archive.PreserveCapabilities()
+	}
+
+	return archive.Decompress(destination)
 }
 
 // StripComponents behaves like the --strip-components flag on tar command
@@ -42,3 +56,40 @@ func (gz GzipArchive) WithName(name string) GzipArchive {
 	gz.name = name
 	return gz
 }
+
+// PreserveTimestamps causes the access and modification times recorded in a
+// tar archive to be applied to the directories and files extracted from it.
+func (gz GzipArchive) PreserveTimestamps() GzipArchive {
+	gz.preserveTimestamps = true
+	return gz
+}
+
+// WithGlobs causes only the entries of a tar archive whose paths, after any
+// components have been stripped, match one of the given glob patterns to be
+// extracted. See TarArchive.WithGlobs.
+func (gz GzipArchive) WithGlobs(globs ...string) GzipArchive {
+	gz.globs = globs
+	return gz
+}
+
+// WithWorkers causes the regular files of a tar archive to be written by the
+// given number of goroutines. See TarArchive.WithWorkers.
+func (gz GzipArchive) WithWorkers(workers int) GzipArchive {
+	gz.workers = workers
+	return gz
+}
+
+// PreserveCapabilities causes the file capabilities recorded in a tar archive
+// to be applied to the files extracted from it. See
+// TarArchive.PreserveCapabilities.
+func (gz GzipArchive) PreserveCapabilities() GzipArchive {
+	gz.preserveCapabilities = true
+	return gz
+}
+
+// WithStats causes the entries written into the destination to be counted in
+// the given Stats.
+func (gz GzipArchive) WithStats(stats *Stats) GzipArchive {
+	gz.stats = stats
+	return gz
+}
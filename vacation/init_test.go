@@ -10,11 +10,15 @@ import (
 func TestVacation(t *testing.T) {
 	suite := spec.New("vacation", spec.Report(report.Terminal{}))
 	suite("Archive", testArchive)
+	suite("BrotliArchive", testBrotliArchive)
 	suite("Bzip2Archive", testBzip2Archive)
+	suite("DebArchive", testDebArchive)
 	suite("Executable", testExecutable)
 	suite("GzipArchive", testGzipArchive)
 	suite("LinkSorting", testLinkSorting)
+	suite("LZ4Archive", testLZ4Archive)
 	suite("NopArchive", testNopArchive)
+	suite("RpmArchive", testRpmArchive)
 	suite("TarArchive", testTarArchive)
 	suite("XZArchive", testXZArchive)
 	suite("ZipArchive", testZipArchive)
@@ -0,0 +1,93 @@
+package vacation
+
+import (
+	"io"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// lz4Magic is the magic number that starts every LZ4 frame.
+var lz4Magic = []byte{0x04, 0x22, 0x4d, 0x18}
+
+// A LZ4Archive decompresses lz4 files from an input stream.
+type LZ4Archive struct {
+	reader     io.Reader
+	components int
+	name       string
+
+	preserveTimestamps   bool
+	preserveCapabilities bool
+	globs                []string
+	workers              int
+	stats                *Stats
+}
+
+// NewLZ4Archive returns a new LZ4Archive that reads from inputReader.
+func NewLZ4Archive(inputReader io.Reader) LZ4Archive {
+	return LZ4Archive{reader: inputReader}
+}
+
+// Decompress reads from LZ4Archive and writes files into the destination
+// specified.
+func (lz4Archive LZ4Archive) Decompress(destination string) error {
+	archive := NewArchive(lz4.NewReader(lz4Archive.reader)).WithName(lz4Archive.name).StripComponents(lz4Archive.components).WithGlobs(lz4Archive.globs...).WithWorkers(lz4Archive.workers).WithStats(lz4Archive.stats)
+	if lz4Archive.preserveTimestamps {
+		archive = archive.PreserveTimestamps()
+	}
+	if lz4Archive.preserveCapabilities {
+		archive = archive.PreserveCapabilities()
+	}
+
+	return archive.Decompress(destination)
+}
+
+// StripComponents behaves like the --strip-components flag on tar command
+// removing the first n levels from the final decompression destination.
+func (lz4Archive LZ4Archive) StripComponents(components int) LZ4Archive {
+	lz4Archive.components = components
+	return lz4Archive
+}
+
+// WithName provides a way of overriding the name of the file
+// that the decompressed file will be copied into.
+func (lz4Archive LZ4Archive) WithName(name string) LZ4Archive {
+	lz4Archive.name = name
+	return lz4Archive
+}
+
+// PreserveTimestamps causes the access and modification times recorded in a
+// tar archive to be applied to the directories and files extracted from it.
+func (lz4Archive LZ4Archive) PreserveTimestamps() LZ4Archive {
+	lz4Archive.preserveTimestamps = true
+	return lz4Archive
+}
+
+// WithGlobs causes only the entries of a tar archive whose paths, after any
+// components have been stripped, match one of the given glob patterns to be
+// extracted. See TarArchive.WithGlobs.
+func (lz4Archive LZ4Archive) WithGlobs(globs ...string) LZ4Archive {
+	lz4Archive.globs = globs
+	return lz4Archive
+}
+
+// WithWorkers causes the regular files of a tar archive to be written by the
+// given number of goroutines. See TarArchive.WithWorkers.
+func (lz4Archive LZ4Archive) WithWorkers(workers int) LZ4Archive {
+	lz4Archive.workers = workers
+	return lz4Archive
+}
+
+// PreserveCapabilities causes the file capabilities recorded in a tar archive
+// to be applied to the files extracted from it. See
+// TarArchive.PreserveCapabilities.
+func (lz4Archive LZ4Archive) PreserveCapabilities() LZ4Archive {
+	lz4Archive.preserveCapabilities = true
+	return lz4Archive
+}
+
+// WithStats causes the entries written into the destination to be counted in
+// the given Stats.
+func (lz4Archive LZ4Archive) WithStats(stats *Stats) LZ4Archive {
+	lz4Archive.stats = stats
+	return lz4Archive
+}
@@ -13,6 +13,7 @@ import (
 type NopArchive struct {
 	reader io.Reader
 	name   string
+	stats  *Stats
 }
 
 // NewNopArchive returns a new NopArchive
@@ -31,11 +32,13 @@ func (na NopArchive) Decompress(destination string) error {
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, na.reader)
+	n, err := io.Copy(file, na.reader)
 	if err != nil {
 		return err
 	}
 
+	na.stats.addFile(n)
+
 	return nil
 }
 
@@ -47,3 +50,10 @@ func (na NopArchive) WithName(name string) NopArchive {
 	}
 	return na
 }
+
+// WithStats causes the file written into the destination to be counted in the
+// given Stats.
+func (na NopArchive) WithStats(stats *Stats) NopArchive {
+	na.stats = stats
+	return na
+}
@@ -0,0 +1,369 @@
+package vacation
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	rpmLeadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+// These mode bits describe the file type of an entry in a cpio archive.
+const (
+	cpioModeTypeMask = 0170000
+	cpioModeDir      = 0040000
+	cpioModeRegular  = 0100000
+	cpioModeSymlink  = 0120000
+)
+
+// A RpmArchive decompresses the payload of rpm packages from an input stream.
+type RpmArchive struct {
+	reader     io.Reader
+	components int
+	stats      *Stats
+}
+
+// NewRpmArchive returns a new RpmArchive that reads from inputReader.
+func NewRpmArchive(inputReader io.Reader) RpmArchive {
+	return RpmArchive{reader: inputReader}
+}
+
+// Decompress reads from RpmArchive and writes the files contained in its
+// cpio payload into the destination specified. The payload may be
+// compressed using gzip, xz, or bzip2.
+func (ra RpmArchive) Decompress(destination string) error {
+	lead := make([]byte, 96)
+	_, err := io.ReadFull(ra.reader, lead)
+	if err != nil {
+		return fmt.Errorf("failed to read rpm lead: %w", err)
+	}
+
+	if !bytes.Equal(lead[:4], rpmLeadMagic) {
+		return errors.New("failed to read rpm lead: invalid magic")
+	}
+
+	// The signature header is padded out to an 8 byte boundary, the main
+	// header that follows it is not.
+	err = skipRpmHeader(ra.reader, true)
+	if err != nil {
+		return fmt.Errorf("failed to read rpm signature header: %w", err)
+	}
+
+	err = skipRpmHeader(ra.reader, false)
+	if err != nil {
+		return fmt.Errorf("failed to read rpm header: %w", err)
+	}
+
+	payload, err := rpmPayloadReader(ra.reader)
+	if err != nil {
+		return err
+	}
+
+	directories := map[string]interface{}{}
+
+	var symlinks []link
+	hardlinks := map[uint64][]string{}
+
+	cpioReader := newCpioReader(payload)
+	for {
+		hdr, err := cpioReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read rpm payload: %s", err)
+		}
+
+		var name string
+		if name = filepath.Clean(hdr.name); name == "." {
+			continue
+		}
+
+		err = checkExtractPath(name, destination)
+		if err != nil {
+			return err
+		}
+
+		fileNames := strings.Split(name, "/")
+
+		// Checks to see if file should be written when stripping components
+		if len(fileNames) <= ra.components {
+			continue
+		}
+
+		// Constructs the path that conforms to the stripped components.
+		path := filepath.Join(append([]string{destination}, fileNames[ra.components:]...)...)
+
+		switch hdr.mode & cpioModeTypeMask {
+		case cpioModeDir:
+			err = os.MkdirAll(path, os.ModePerm)
+			if err != nil {
+				return fmt.Errorf("failed to create archived directory: %s", err)
+			}
+
+			directories[path] = nil
+			ra.stats.addDir()
+
+		default:
+			dir := filepath.Dir(path)
+			_, ok := directories[dir]
+			if !ok {
+				err = os.MkdirAll(dir, os.ModePerm)
+				if err != nil {
+					return fmt.Errorf("failed to create archived directory from file path: %s", err)
+				}
+				directories[dir] = nil
+			}
+		}
+
+		switch hdr.mode & cpioModeTypeMask {
+		case cpioModeRegular:
+			// Hard linked files only carry their contents on the last entry
+			// that refers to them, all prior entries are empty.
+			if hdr.nlink > 1 && hdr.size == 0 {
+				hardlinks[hdr.ino] = append(hardlinks[hdr.ino], path)
+				continue
+			}
+
+			file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.mode&0777))
+			if err != nil {
+				return fmt.Errorf("failed to create archived file: %s", err)
+			}
+
+			n, err := io.Copy(file, cpioReader)
+			if err != nil {
+				return err
+			}
+
+			err = file.Close()
+			if err != nil {
+				return err
+			}
+
+			ra.stats.addFile(n)
+
+			for _, linkPath := range hardlinks[hdr.ino] {
+				err = os.Link(path, linkPath)
+				if err != nil {
+					return fmt.Errorf("failed to extract link: %s", err)
+				}
+
+				ra.stats.addFile(0)
+			}
+			delete(hardlinks, hdr.ino)
+
+		case cpioModeSymlink:
+			linkname, err := io.ReadAll(cpioReader)
+			if err != nil {
+				return err
+			}
+
+			// Collect all of the headers for symlinks so that they can be verified
+			// after all other files are written
+			symlinks = append(symlinks, link{
+				name: string(linkname),
+				path: path,
+			})
+		}
+	}
+
+	symlinks, err = sortLinks(symlinks)
+	if err != nil {
+		return err
+	}
+
+	for _, link := range symlinks {
+		// Check to see if the file that will be linked to is valid for symlinking
+		_, err := filepath.EvalSymlinks(linknameFullPath(link.path, link.name))
+		if err != nil {
+			return fmt.Errorf("failed to evaluate symlink %s: %w", link.path, err)
+		}
+
+		err = os.Symlink(link.name, link.path)
+		if err != nil {
+			return fmt.Errorf("failed to extract symlink: %s", err)
+		}
+
+		ra.stats.addSymlink()
+	}
+
+	return nil
+}
+
+// StripComponents behaves like the --strip-components flag on tar command
+// removing the first n levels from the final decompression destination.
+func (ra RpmArchive) StripComponents(components int) RpmArchive {
+	ra.components = components
+	return ra
+}
+
+// WithStats causes the directories, files, and links written into the
+// destination to be counted in the given Stats.
+func (ra RpmArchive) WithStats(stats *Stats) RpmArchive {
+	ra.stats = stats
+	return ra
+}
+
+// skipRpmHeader reads past an rpm header structure, which is made up of a
+// 16 byte preamble followed by the index entries and the data store.
+func skipRpmHeader(reader io.Reader, padded bool) error {
+	preamble := make([]byte, 16)
+	_, err := io.ReadFull(reader, preamble)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(preamble[:4], rpmHeaderMagic) {
+		return errors.New("invalid magic")
+	}
+
+	entries := int64(binary.BigEndian.Uint32(preamble[8:12]))
+	size := entries*16 + int64(binary.BigEndian.Uint32(preamble[12:16]))
+	if padded {
+		size += (8 - (16+size)%8) % 8
+	}
+
+	_, err = io.CopyN(io.Discard, reader, size)
+	return err
+}
+
+func rpmPayloadReader(reader io.Reader) (io.Reader, error) {
+	bufferedReader := bufio.NewReader(reader)
+
+	header, err := bufferedReader.Peek(3072)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	mime := mimetype.Detect(header)
+
+	switch mime.String() {
+	case "application/gzip":
+		gzr, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return gzr, nil
+	case "application/x-xz":
+		xzr, err := xz.NewReader(bufferedReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create xz reader: %w", err)
+		}
+		return xzr, nil
+	case "application/x-bzip2":
+		return bzip2.NewReader(bufferedReader), nil
+	default:
+		return nil, fmt.Errorf("unsupported rpm payload compression: %s", mime.String())
+	}
+}
+
+type cpioHeader struct {
+	name  string
+	ino   uint64
+	mode  uint64
+	nlink uint64
+	size  int64
+}
+
+// cpioReader reads the entries of an archive written in the "newc" cpio
+// format, which is the format used for rpm payloads.
+type cpioReader struct {
+	reader    io.Reader
+	remaining int64
+	padding   int64
+}
+
+func newCpioReader(reader io.Reader) *cpioReader {
+	return &cpioReader{reader: reader}
+}
+
+// Next advances to the next entry in the archive. io.EOF is returned once the
+// trailer entry is reached.
+func (cr *cpioReader) Next() (cpioHeader, error) {
+	// Skip over any unread contents of the previous entry
+	_, err := io.CopyN(io.Discard, cr.reader, cr.remaining+cr.padding)
+	if err != nil {
+		return cpioHeader{}, err
+	}
+
+	raw := make([]byte, 110)
+	_, err = io.ReadFull(cr.reader, raw)
+	if err != nil {
+		return cpioHeader{}, err
+	}
+
+	magic := string(raw[:6])
+	if magic != "070701" && magic != "070702" {
+		return cpioHeader{}, fmt.Errorf("invalid cpio magic %q", magic)
+	}
+
+	fields := make([]uint64, 13)
+	for i := range fields {
+		fields[i], err = strconv.ParseUint(string(raw[6+i*8:14+i*8]), 16, 32)
+		if err != nil {
+			return cpioHeader{}, fmt.Errorf("invalid cpio header: %w", err)
+		}
+	}
+
+	nameSize := int64(fields[11])
+	name := make([]byte, nameSize+cpioPadding(110+nameSize))
+	_, err = io.ReadFull(cr.reader, name)
+	if err != nil {
+		return cpioHeader{}, err
+	}
+
+	hdr := cpioHeader{
+		name:  strings.TrimRight(string(name[:nameSize]), "\x00"),
+		ino:   fields[0],
+		mode:  fields[1],
+		nlink: fields[4],
+		size:  int64(fields[6]),
+	}
+
+	if hdr.name == "TRAILER!!!" {
+		return cpioHeader{}, io.EOF
+	}
+
+	cr.remaining = hdr.size
+	cr.padding = cpioPadding(hdr.size)
+
+	return hdr, nil
+}
+
+// Read reads from the contents of the current entry in the archive.
+func (cr *cpioReader) Read(p []byte) (int, error) {
+	if cr.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+
+	n, err := cr.reader.Read(p)
+	cr.remaining -= int64(n)
+	if err == io.EOF && cr.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func cpioPadding(size int64) int64 {
+	return (4 - size%4) % 4
+}
@@ -0,0 +1,168 @@
+package vacation_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paketo-buildpacks/packit/v2/vacation"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+type rpmEntry struct {
+	name    string
+	mode    int
+	content string
+}
+
+// buildRpm assembles a minimal rpm package with empty signature and main
+// headers and a gzip compressed "newc" cpio payload containing the given
+// entries.
+func buildRpm(entries []rpmEntry) ([]byte, error) {
+	cpio := bytes.NewBuffer(nil)
+	for i, entry := range append(entries, rpmEntry{name: "TRAILER!!!"}) {
+		name := entry.name + "\x00"
+		fmt.Fprintf(cpio, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			i+1, entry.mode, 0, 0, 1, 0, len(entry.content), 0, 0, 0, 0, len(name), 0)
+		cpio.WriteString(name)
+		cpio.Write(make([]byte, (4-(110+len(name))%4)%4))
+		cpio.WriteString(entry.content)
+		cpio.Write(make([]byte, (4-len(entry.content)%4)%4))
+	}
+
+	buffer := bytes.NewBuffer(nil)
+
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 0x03, 0x00})
+	buffer.Write(lead)
+
+	// The signature header followed by the main header, both without any
+	// entries
+	for i := 0; i < 2; i++ {
+		buffer.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	}
+
+	gw := gzip.NewWriter(buffer)
+	_, err := gw.Write(cpio.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	err = gw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func testRpmArchive(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+	)
+
+	context("Decompress", func() {
+		var (
+			tempDir    string
+			rpmArchive vacation.RpmArchive
+		)
+
+		it.Before(func() {
+			var err error
+			tempDir, err = os.MkdirTemp("", "vacation")
+			Expect(err).NotTo(HaveOccurred())
+
+			rpm, err := buildRpm([]rpmEntry{
+				{name: "./usr", mode: 0040755},
+				{name: "./usr/bin", mode: 0040755},
+				{name: "./usr/bin/some-tool", mode: 0100755, content: "some-tool-contents"},
+				{name: "./usr/bin/some-symlink", mode: 0120777, content: "some-tool"},
+				{name: "./usr/share/some-file", mode: 0100644, content: "some-file"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			rpmArchive = vacation.NewRpmArchive(bytes.NewReader(rpm))
+		})
+
+		it.After(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		it("unpackages the payload into the path", func() {
+			err := rpmArchive.Decompress(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			files, err := filepath.Glob(fmt.Sprintf("%s/*", tempDir))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(ConsistOf([]string{
+				filepath.Join(tempDir, "usr"),
+			}))
+
+			info, err := os.Stat(filepath.Join(tempDir, "usr", "bin", "some-tool"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+
+			content, err := os.ReadFile(filepath.Join(tempDir, "usr", "bin", "some-tool"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("some-tool-contents"))
+
+			link, err := os.Readlink(filepath.Join(tempDir, "usr", "bin", "some-symlink"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(Equal("some-tool"))
+
+			Expect(filepath.Join(tempDir, "usr", "share", "some-file")).To(BeARegularFile())
+		})
+
+		it("unpackages the payload into the path but also strips the first component", func() {
+			err := rpmArchive.StripComponents(1).Decompress(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			files, err := filepath.Glob(fmt.Sprintf("%s/*", tempDir))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(ConsistOf([]string{
+				filepath.Join(tempDir, "bin"),
+				filepath.Join(tempDir, "share"),
+			}))
+		})
+
+		context("failure cases", func() {
+			context("when the lead does not have the rpm magic", func() {
+				it("returns an error", func() {
+					err := vacation.NewRpmArchive(bytes.NewReader(make([]byte, 96))).Decompress(tempDir)
+					Expect(err).To(MatchError("failed to read rpm lead: invalid magic"))
+				})
+			})
+
+			context("when the signature header is truncated", func() {
+				it("returns an error", func() {
+					lead := make([]byte, 96)
+					copy(lead, []byte{0xed, 0xab, 0xee, 0xdb})
+
+					err := vacation.NewRpmArchive(bytes.NewReader(lead)).Decompress(tempDir)
+					Expect(err).To(MatchError(ContainSubstring("failed to read rpm signature header")))
+				})
+			})
+
+			context("when the payload contains a zip slip", func() {
+				it.Before(func() {
+					rpm, err := buildRpm([]rpmEntry{
+						{name: "../some-file", mode: 0100644, content: "some-file"},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					rpmArchive = vacation.NewRpmArchive(bytes.NewReader(rpm))
+				})
+
+				it("returns an error", func() {
+					err := rpmArchive.Decompress(tempDir)
+					Expect(err).To(MatchError(ContainSubstring("illegal file path \"../some-file\"")))
+				})
+			})
+		})
+	})
+}
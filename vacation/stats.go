@@ -0,0 +1,46 @@
+package vacation
+
+// Stats counts the entries that an archive writes into its destination as it
+// is decompressed, as requested with the WithStats option of the archive.
+// Entries that are not extracted, such as those removed by StripComponents or
+// that do not match the globs given with WithGlobs, are not counted.
+type Stats struct {
+	// Files is the number of regular files written, including hard links to
+	// other files.
+	Files int
+
+	// Bytes is the total number of bytes written to regular files.
+	Bytes int64
+
+	// Dirs is the number of directory entries of the archive that were
+	// created.
+	Dirs int
+
+	// Symlinks is the number of symlinks created.
+	Symlinks int
+}
+
+func (s *Stats) addFile(size int64) {
+	if s == nil {
+		return
+	}
+
+	s.Files++
+	s.Bytes += size
+}
+
+func (s *Stats) addDir() {
+	if s == nil {
+		return
+	}
+
+	s.Dirs++
+}
+
+func (s *Stats) addSymlink() {
+	if s == nil {
+		return
+	}
+
+	s.Symlinks++
+}
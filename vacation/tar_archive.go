@@ -2,17 +2,42 @@ package vacation
 
 import (
 	"archive/tar"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 // A TarArchive decompresses tar files from an input stream.
 type TarArchive struct {
 	reader     io.Reader
 	components int
+
+	preserveTimestamps   bool
+	preserveCapabilities bool
+	globs                []string
+	workers              int
+	stats                *Stats
+}
+
+// paxCapabilityRecord is the PAX record that holds the value of the
+// security.capability extended attribute of a file, as written by GNU tar.
+const paxCapabilityRecord = "SCHILY.xattr.security.capability"
+
+type capability struct {
+	path  string
+	value []byte
+}
+
+type timestamp struct {
+	path  string
+	atime time.Time
+	mtime time.Time
 }
 
 // NewTarArchive returns a new TarArchive that reads from inputReader.
@@ -32,7 +57,18 @@ func (ta TarArchive) Decompress(destination string) error {
 
 	var symlinks []link
 	var links []link
+	var timestamps []timestamp
+	var capabilities []capability
+
+	var writer *fileWriter
+	if ta.workers > 1 {
+		writer = newFileWriter(ta.workers)
+		defer writer.Wait()
+	}
 
+	// The tar reader merges GNU long name and long link entries, as well as
+	// PAX extended headers, into the header of the entry that follows them, so
+	// they never need to be extracted themselves
 	tarReader := tar.NewReader(ta.reader)
 	for {
 		hdr, err := tarReader.Next()
@@ -63,6 +99,18 @@ func (ta TarArchive) Decompress(destination string) error {
 			continue
 		}
 
+		// Checks to see if the entry matches the globs to be extracted, if any
+		if len(ta.globs) > 0 {
+			match, err := matchGlobs(ta.globs, strings.Join(fileNames[ta.components:], "/"))
+			if err != nil {
+				return err
+			}
+
+			if !match {
+				continue
+			}
+		}
+
 		// Constructs the path that conforms to the stripped components.
 		path := filepath.Join(append([]string{destination}, fileNames[ta.components:]...)...)
 
@@ -76,6 +124,7 @@ func (ta TarArchive) Decompress(destination string) error {
 			}
 
 			directories[path] = nil
+			ta.stats.addDir()
 
 		default:
 			dir := filepath.Dir(path)
@@ -92,12 +141,30 @@ func (ta TarArchive) Decompress(destination string) error {
 		// This switch case handles the creation of files during the untaring process.
 		switch hdr.Typeflag {
 		case tar.TypeReg:
+			if writer != nil {
+				// The content of the file is buffered so that the tar stream can
+				// move on to the next entry while the file is written. Its
+				// directory has already been created above.
+				content, err := io.ReadAll(tarReader)
+				if err != nil {
+					return fmt.Errorf("failed to read archived file: %s", err)
+				}
+
+				err = writer.Write(path, hdr.FileInfo().Mode(), content)
+				if err != nil {
+					return err
+				}
+
+				ta.stats.addFile(int64(len(content)))
+				break
+			}
+
 			file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, hdr.FileInfo().Mode())
 			if err != nil {
 				return fmt.Errorf("failed to create archived file: %s", err)
 			}
 
-			_, err = io.Copy(file, tarReader)
+			n, err := io.Copy(file, tarReader)
 			if err != nil {
 				return err
 			}
@@ -107,6 +174,8 @@ func (ta TarArchive) Decompress(destination string) error {
 				return err
 			}
 
+			ta.stats.addFile(n)
+
 		case tar.TypeLink:
 			// Collect all of the headers for links so that they can be verified
 			// after all other files are written
@@ -123,6 +192,37 @@ func (ta TarArchive) Decompress(destination string) error {
 				path: path,
 			})
 		}
+
+		if ta.preserveCapabilities && hdr.Typeflag == tar.TypeReg {
+			value, ok := hdr.PAXRecords[paxCapabilityRecord]
+			if ok {
+				capabilities = append(capabilities, capability{
+					path:  path,
+					value: []byte(value),
+				})
+			}
+		}
+
+		if ta.preserveTimestamps && (hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tar.TypeReg) {
+			atime := hdr.AccessTime
+			if atime.IsZero() {
+				atime = hdr.ModTime
+			}
+
+			timestamps = append(timestamps, timestamp{
+				path:  path,
+				atime: atime,
+				mtime: hdr.ModTime,
+			})
+		}
+	}
+
+	// Every file must be written before any links to it are created
+	if writer != nil {
+		err := writer.Wait()
+		if err != nil {
+			return err
+		}
 	}
 
 	symlinks, err := sortLinks(symlinks)
@@ -134,6 +234,11 @@ func (ta TarArchive) Decompress(destination string) error {
 		// Check to see if the file that will be linked to is valid for symlinking
 		_, err := filepath.EvalSymlinks(linknameFullPath(link.path, link.name))
 		if err != nil {
+			// Links to entries that did not match the globs are left out
+			if len(ta.globs) > 0 && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
 			return fmt.Errorf("failed to evaluate symlink %s: %w", link.path, err)
 		}
 
@@ -141,6 +246,8 @@ func (ta TarArchive) Decompress(destination string) error {
 		if err != nil {
 			return fmt.Errorf("failed to extract symlink: %s", err)
 		}
+
+		ta.stats.addSymlink()
 	}
 
 	links, err = sortLinks(links)
@@ -151,8 +258,32 @@ func (ta TarArchive) Decompress(destination string) error {
 	for _, link := range links {
 		err := os.Link(filepath.Join(destination, link.name), link.path)
 		if err != nil {
+			// Links to entries that did not match the globs are left out
+			if len(ta.globs) > 0 && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
 			return fmt.Errorf("failed to extract link: %s", err)
 		}
+
+		ta.stats.addFile(0)
+	}
+
+	for _, capability := range capabilities {
+		err := setCapability(capability.path, capability.value)
+		if err != nil {
+			return fmt.Errorf("failed to set capabilities on %s: %w", capability.path, err)
+		}
+	}
+
+	// Timestamps are applied last, in reverse order, so that the creation of
+	// files and links does not change the modification time of a directory
+	// after it has been set
+	for i := len(timestamps) - 1; i >= 0; i-- {
+		err := os.Chtimes(timestamps[i].path, timestamps[i].atime, timestamps[i].mtime)
+		if err != nil {
+			return fmt.Errorf("failed to set timestamps: %s", err)
+		}
 	}
 
 	return nil
@@ -164,3 +295,146 @@ func (ta TarArchive) StripComponents(components int) TarArchive {
 	ta.components = components
 	return ta
 }
+
+// PreserveTimestamps causes the access and modification times recorded in a
+// tar archive to be applied to the directories and files extracted from it.
+func (ta TarArchive) PreserveTimestamps() TarArchive {
+	ta.preserveTimestamps = true
+	return ta
+}
+
+// PreserveCapabilities causes the file capabilities recorded for the regular
+// files of a tar archive, in the PAX record for their security.capability
+// extended attribute, to be applied to the files extracted from it. Setting
+// capabilities is only supported on Linux, and requires the CAP_SETFCAP
+// capability. Note that changing the owner of a file afterwards clears its
+// capabilities.
+func (ta TarArchive) PreserveCapabilities() TarArchive {
+	ta.preserveCapabilities = true
+	return ta
+}
+
+// WithGlobs causes only the entries whose paths, after any components have
+// been stripped, match one of the given glob patterns to be extracted.
+// Patterns use the syntax of path.Match and are matched against the
+// slash-separated path of each entry, so "bin/*" matches the files directly
+// within the bin directory. The directories leading to a matching entry are
+// created as needed. Links are only extracted when they match, and are left
+// out when the entry they point at is not extracted.
+func (ta TarArchive) WithGlobs(globs ...string) TarArchive {
+	ta.globs = globs
+	return ta
+}
+
+// WithWorkers causes the regular files of the tar archive to be written by the
+// given number of goroutines, which can speed up the extraction of archives
+// made up of many small files. The content of each file is read into memory
+// from the tar stream before it is handed to a goroutine, so up to the whole
+// archive may be held in memory at once. Directories are always created before
+// the files within them, and links are created once every file has been
+// written. A value of 1 or less extracts the archive serially.
+func (ta TarArchive) WithWorkers(workers int) TarArchive {
+	ta.workers = workers
+	return ta
+}
+
+// WithStats causes the directories, files, and links written into the
+// destination to be counted in the given Stats. Files written by the
+// goroutines of WithWorkers are counted as they are handed to them.
+func (ta TarArchive) WithStats(stats *Stats) TarArchive {
+	ta.stats = stats
+	return ta
+}
+
+// fileWriter writes files from a pool of goroutines, keeping the first error
+// that any of them encounters.
+type fileWriter struct {
+	files chan tarFile
+	group sync.WaitGroup
+	once  sync.Once
+
+	m   sync.Mutex
+	err error
+}
+
+type tarFile struct {
+	path    string
+	mode    os.FileMode
+	content []byte
+}
+
+func newFileWriter(workers int) *fileWriter {
+	w := &fileWriter{files: make(chan tarFile)}
+
+	w.group.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer w.group.Done()
+
+			for file := range w.files {
+				err := os.WriteFile(file.path, file.content, file.mode)
+				if err != nil {
+					w.setErr(fmt.Errorf("failed to create archived file: %s", err))
+				}
+			}
+		}()
+	}
+
+	return w
+}
+
+// Write hands the file to one of the goroutines to be written, returning the
+// error encountered while writing any previous file.
+func (w *fileWriter) Write(path string, mode os.FileMode, content []byte) error {
+	err := w.getErr()
+	if err != nil {
+		return err
+	}
+
+	w.files <- tarFile{path: path, mode: mode, content: content}
+	return nil
+}
+
+// Wait waits for every file to be written and returns the first error that
+// was encountered. It is safe to call more than once.
+func (w *fileWriter) Wait() error {
+	w.once.Do(func() {
+		close(w.files)
+	})
+	w.group.Wait()
+
+	return w.getErr()
+}
+
+func (w *fileWriter) setErr(err error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *fileWriter) getErr() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.err
+}
+
+// matchGlobs reports whether the given slash-separated path matches any of
+// the given glob patterns.
+func matchGlobs(globs []string, name string) (bool, error) {
+	for _, glob := range globs {
+		match, err := path.Match(glob, name)
+		if err != nil {
+			return false, fmt.Errorf("failed to match glob %q: %w", glob, err)
+		}
+
+		if match {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/paketo-buildpacks/packit/v2/vacation"
 	"github.com/sclevine/spec"
@@ -123,6 +125,209 @@ func testTarArchive(t *testing.T, context spec.G, it spec.S) {
 
 		})
 
+		context("when timestamps are preserved", func() {
+			var modTime time.Time
+
+			it.Before(func() {
+				modTime = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+				buffer := bytes.NewBuffer(nil)
+				tw := tar.NewWriter(buffer)
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-dir", Mode: 0755, Typeflag: tar.TypeDir, ModTime: modTime})).To(Succeed())
+
+				file := filepath.Join("some-dir", "some-file")
+				Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: int64(len(file)), ModTime: modTime})).To(Succeed())
+				_, err := tw.Write([]byte(file))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.Close()).To(Succeed())
+
+				tarArchive = vacation.NewTarArchive(bytes.NewReader(buffer.Bytes()))
+			})
+
+			it("applies the modification times from the archive", func() {
+				err := tarArchive.PreserveTimestamps().Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				info, err := os.Stat(filepath.Join(tempDir, "some-dir", "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.ModTime().UTC()).To(Equal(modTime))
+
+				info, err = os.Stat(filepath.Join(tempDir, "some-dir"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.ModTime().UTC()).To(Equal(modTime))
+			})
+		})
+
+		context("when globs are given", func() {
+			it("only unpackages the entries that match the globs", func() {
+				err := tarArchive.WithGlobs("first", "symlink", "some-dir/some-other-dir/*").Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", tempDir))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(tempDir, "first"),
+					filepath.Join(tempDir, "symlink"),
+					filepath.Join(tempDir, "some-dir"),
+				}))
+
+				Expect(filepath.Join(tempDir, "some-dir", "some-other-dir", "some-file")).To(BeARegularFile())
+			})
+
+			it("matches the globs against the paths with their components stripped", func() {
+				err := tarArchive.StripComponents(1).WithGlobs("some-other-dir/*").Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", tempDir))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(tempDir, "some-other-dir"),
+				}))
+
+				Expect(filepath.Join(tempDir, "some-other-dir", "some-file")).To(BeARegularFile())
+			})
+
+			context("when a link matches but the entry it points at does not", func() {
+				it("leaves the link out", func() {
+					err := tarArchive.WithGlobs("symlink", "hardlink", "second").Decompress(tempDir)
+					Expect(err).NotTo(HaveOccurred())
+
+					files, err := filepath.Glob(fmt.Sprintf("%s/*", tempDir))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(files).To(ConsistOf([]string{
+						filepath.Join(tempDir, "second"),
+					}))
+				})
+			})
+
+			context("when a glob is malformed", func() {
+				it("returns an error", func() {
+					err := tarArchive.WithGlobs("[").Decompress(tempDir)
+					Expect(err).To(MatchError(ContainSubstring(`failed to match glob "["`)))
+				})
+			})
+		})
+
+		context("when the archive has GNU long names", func() {
+			var longDir string
+
+			it.Before(func() {
+				// A path of 256 characters made up of directories that are each
+				// short enough for the filesystem
+				longDir = strings.Join([]string{strings.Repeat("a", 100), strings.Repeat("b", 100), strings.Repeat("c", 44)}, "/")
+
+				buffer := bytes.NewBuffer(nil)
+				tw := tar.NewWriter(buffer)
+
+				longFile := longDir + "/some-file"
+				Expect(longFile).To(HaveLen(256))
+
+				Expect(tw.WriteHeader(&tar.Header{Name: longFile, Mode: 0755, Size: int64(len("some-content")), Format: tar.FormatGNU})).To(Succeed())
+				_, err := tw.Write([]byte("some-content"))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(tw.WriteHeader(&tar.Header{Name: "some-symlink", Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: longFile, Format: tar.FormatGNU})).To(Succeed())
+
+				Expect(tw.Close()).To(Succeed())
+
+				Expect(buffer.String()).To(ContainSubstring("././@LongLink"))
+
+				tarArchive = vacation.NewTarArchive(bytes.NewReader(buffer.Bytes()))
+			})
+
+			it("extracts the entries to their long names", func() {
+				err := tarArchive.Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(tempDir, longDir, "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("some-content"))
+
+				link, err := os.Readlink(filepath.Join(tempDir, "some-symlink"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link).To(Equal(longDir + "/some-file"))
+
+				Expect(filepath.Join(tempDir, "././@LongLink")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		context("when workers are given", func() {
+			it("unpackages the archive into the path", func() {
+				err := tarArchive.WithWorkers(4).Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				files, err := filepath.Glob(fmt.Sprintf("%s/*", tempDir))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(files).To(ConsistOf([]string{
+					filepath.Join(tempDir, "first"),
+					filepath.Join(tempDir, "hardlink"),
+					filepath.Join(tempDir, "second"),
+					filepath.Join(tempDir, "some-dir"),
+					filepath.Join(tempDir, "symlink"),
+					filepath.Join(tempDir, "third"),
+				}))
+
+				info, err := os.Stat(filepath.Join(tempDir, "first"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode()).To(Equal(os.FileMode(0755)))
+
+				data, err := os.ReadFile(filepath.Join(tempDir, "some-dir", "some-other-dir", "some-file"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(Equal(filepath.Join("some-dir", "some-other-dir", "some-file")))
+
+				data, err = os.ReadFile(filepath.Join(tempDir, "symlink"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(data).To(Equal([]byte(`first`)))
+
+				data, err = os.ReadFile(filepath.Join(tempDir, "hardlink"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(data).To(Equal([]byte(`first`)))
+			})
+		})
+
+		context("when stats are given", func() {
+			var expected vacation.Stats
+
+			it.Before(func() {
+				expected = vacation.Stats{
+					Files:    5,
+					Bytes:    int64(len(filepath.Join("some-dir", "some-other-dir", "some-file")) + len("first") + len("second") + len("third")),
+					Dirs:     2,
+					Symlinks: 1,
+				}
+			})
+
+			it("counts the entries written into the path", func() {
+				var stats vacation.Stats
+				err := tarArchive.WithStats(&stats).Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stats).To(Equal(expected))
+			})
+
+			it("counts the entries written into the path by the workers", func() {
+				var stats vacation.Stats
+				err := tarArchive.WithWorkers(4).WithStats(&stats).Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stats).To(Equal(expected))
+			})
+
+			it("does not count the entries that are stripped", func() {
+				var stats vacation.Stats
+				err := tarArchive.StripComponents(1).WithStats(&stats).Decompress(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stats).To(Equal(vacation.Stats{
+					Files: 1,
+					Bytes: int64(len(filepath.Join("some-dir", "some-other-dir", "some-file"))),
+					Dirs:  1,
+				}))
+			})
+		})
+
 		context("there is no directory metadata", func() {
 			it.Before(func() {
 				var err error
@@ -12,6 +12,12 @@ type XZArchive struct {
 	reader     io.Reader
 	components int
 	name       string
+
+	preserveTimestamps   bool
+	preserveCapabilities bool
+	globs                []string
+	workers              int
+	stats                *Stats
 }
 
 // NewXZArchive returns a new XZArchive that reads from inputReader.
@@ -27,7 +33,15 @@ func (xzArchive XZArchive) Decompress(destination string) error {
 		return fmt.Errorf("failed to create xz reader: %w", err)
 	}
 
-	return NewArchive(xzr).WithName(xzArchive.name).StripComponents(xzArchive.components).Decompress(destination)
+	archive := NewArchive(xzr).WithName(xzArchive.name).StripComponents(xzArchive.components).WithGlobs(xzArchive.globs...).WithWorkers(xzArchive.workers).WithStats(xzArchive.stats)
+	if xzArchive.preserveTimestamps {
+		archive = archive.PreserveTimestamps()
+	}
+	if xzArchive.preserveCapabilities {
+		archive = archive.PreserveCapabilities()
+	}
+
+	return archive.Decompress(destination)
 }
 
 // StripComponents behaves like the --strip-components flag on tar command
@@ -43,3 +57,40 @@ func (xzArchive XZArchive) WithName(name string) XZArchive {
 	xzArchive.name = name
 	return xzArchive
 }
+
+// PreserveTimestamps causes the access and modification times recorded in a
+// tar archive to be applied to the directories and files extracted from it.
+func (xzArchive XZArchive) PreserveTimestamps() XZArchive {
+	xzArchive.preserveTimestamps = true
+	return xzArchive
+}
+
+// WithGlobs causes only the entries of a tar archive whose paths, after any
+// components have been stripped, match one of the given glob patterns to be
+// extracted. See TarArchive.WithGlobs.
+func (xzArchive XZArchive) WithGlobs(globs ...string) XZArchive {
+	xzArchive.globs = globs
+	return xzArchive
+}
+
+// WithWorkers causes the regular files of a tar archive to be written by the
+// given number of goroutines. See TarArchive.WithWorkers.
+func (xzArchive XZArchive) WithWorkers(workers int) XZArchive {
+	xzArchive.workers = workers
+	return xzArchive
+}
+
+// PreserveCapabilities causes the file capabilities recorded in a tar archive
+// to be applied to the files extracted from it. See
+// TarArchive.PreserveCapabilities.
+func (xzArchive XZArchive) PreserveCapabilities() XZArchive {
+	xzArchive.preserveCapabilities = true
+	return xzArchive
+}
+
+// WithStats causes the entries written into the destination to be counted in
+// the given Stats.
+func (xzArchive XZArchive) WithStats(stats *Stats) XZArchive {
+	xzArchive.stats = stats
+	return xzArchive
+}
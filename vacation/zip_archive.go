@@ -13,6 +13,7 @@ import (
 type ZipArchive struct {
 	reader     io.Reader
 	components int
+	stats      *Stats
 }
 
 // NewZipArchive returns a new ZipArchive that reads from inputReader.
@@ -74,6 +75,8 @@ func (z ZipArchive) Decompress(destination string) error {
 			if err != nil {
 				return fmt.Errorf("failed to unzip directory: %w", err)
 			}
+
+			z.stats.addDir()
 		case f.FileInfo().Mode()&os.ModeSymlink != 0:
 			fd, err := f.Open()
 			if err != nil {
@@ -108,7 +111,7 @@ func (z ZipArchive) Decompress(destination string) error {
 				return err
 			}
 
-			_, err = io.Copy(dst, src)
+			n, err := io.Copy(dst, src)
 			if err != nil {
 				return err
 			}
@@ -120,6 +123,8 @@ func (z ZipArchive) Decompress(destination string) error {
 			if err := src.Close(); err != nil {
 				return err
 			}
+
+			z.stats.addFile(n)
 		}
 	}
 
@@ -139,6 +144,8 @@ func (z ZipArchive) Decompress(destination string) error {
 		if err != nil {
 			return fmt.Errorf("failed to unzip symlink: %s", err)
 		}
+
+		z.stats.addSymlink()
 	}
 
 	return nil
@@ -150,3 +157,10 @@ func (z ZipArchive) StripComponents(components int) ZipArchive {
 	z.components = components
 	return z
 }
+
+// WithStats causes the directories, files, and symlinks written into the
+// destination to be counted in the given Stats.
+func (z ZipArchive) WithStats(stats *Stats) ZipArchive {
+	z.stats = stats
+	return z
+}
@@ -112,6 +112,19 @@ func testZipArchive(t *testing.T, context spec.G, it spec.S) {
 			Expect(data).To(Equal([]byte("nested file")))
 		})
 
+		it("counts the entries written into the path in the given stats", func() {
+			var stats vacation.Stats
+			err := zipArchive.WithStats(&stats).Decompress(tempDir)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(stats).To(Equal(vacation.Stats{
+				Files:    4,
+				Bytes:    int64(len("nested file") + len("first") + len("second") + len("third")),
+				Dirs:     2,
+				Symlinks: 1,
+			}))
+		})
+
 		it("unpackages the archive into the path but also strips the first component", func() {
 			err := zipArchive.StripComponents(1).Decompress(tempDir)
 			Expect(err).ToNot(HaveOccurred())